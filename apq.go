@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// PersistedQueries configures automatic persisted queries. In automatic mode
+// only the sha256 hash is sent first and the full query follows if the server
+// answers PersistedQueryNotFound. A server answering PersistedQueryNotSupported
+// is sent full queries without hashes from then on. With a manifest, only
+// queries registered in it may be sent and the query text never is.
+type PersistedQueries struct {
+	// manifest maps query text to its registered ID; nil in automatic mode.
+	manifest map[string]string
+	// unsupported is set once the server said it doesn't support persisted
+	// queries.
+	unsupported atomic.Bool
+}
+
+// enabled reports whether operations are sent as persisted queries.
+func (pq *PersistedQueries) enabled() bool {
+	return pq != nil && !pq.unsupported.Load()
+}
+
+// lookup returns the hash to send for query.
+func (pq *PersistedQueries) lookup(query string) (string, error) {
+	if pq.manifest == nil {
+		return queryHash(query), nil
+	}
+	id, ok := pq.manifest[query]
+	if !ok {
+		return "", fmt.Errorf("operation is not in the persisted query manifest (sha256 %s)", queryHash(query))
+	}
+	return id, nil
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+func persistedQueryExtension(hash string) map[string]interface{} {
+	return map[string]interface{}{
+		"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+	}
+}
+
+// loadPersistedQueryManifest reads either an Apollo persisted query manifest
+// (`{"operations": [{"id", "body"}]}`) or a plain `{"<hash>": "<query>"}`
+// object.
+func loadPersistedQueryManifest(path string) (*PersistedQueries, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var apollo struct {
+		Operations []struct {
+			ID   string `json:"id"`
+			Body string `json:"body"`
+		} `json:"operations"`
+	}
+	manifest := make(map[string]string)
+	if err := json.Unmarshal(data, &apollo); err == nil && len(apollo.Operations) > 0 {
+		for _, op := range apollo.Operations {
+			manifest[op.Body] = op.ID
+		}
+		return &PersistedQueries{manifest: manifest}, nil
+	}
+
+	var plain map[string]string
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return nil, fmt.Errorf("%s: not an Apollo manifest or hash to query object: %w", path, err)
+	}
+	for id, body := range plain {
+		manifest[body] = id
+	}
+	return &PersistedQueries{manifest: manifest}, nil
+}
+
+// isPersistedQueryNotFound reports whether an `error` message or a `next`
+// result rejects the hash because the server hasn't seen the query yet.
+func isPersistedQueryNotFound(msg GraphQLMessage) bool {
+	return hasPersistedQueryError(msg, "PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND")
+}
+
+// isPersistedQueryNotSupported reports whether an `error` message or a
+// `next` result rejects the hash because the server has no persisted queries.
+func isPersistedQueryNotSupported(msg GraphQLMessage) bool {
+	return hasPersistedQueryError(msg, "PersistedQueryNotSupported", "PERSISTED_QUERY_NOT_SUPPORTED")
+}
+
+func hasPersistedQueryError(msg GraphQLMessage, message, code string) bool {
+	var errs GraphQLErrors
+	switch msg.Type {
+	case "error":
+		if err := json.Unmarshal(msg.Payload, &errs); err != nil {
+			return false
+		}
+	case "next":
+		var result struct {
+			Errors GraphQLErrors `json:"errors"`
+		}
+		if err := json.Unmarshal(msg.Payload, &result); err != nil {
+			return false
+		}
+		errs = result.Errors
+	default:
+		return false
+	}
+	for _, err := range errs {
+		if err.Message == message || err.Extensions["code"] == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentRequest is what an operation was sent with: "hash", "query" or
+// "hash+query".
+func sentRequest(payload []byte) string {
+	var req mockRequest
+	json.Unmarshal(payload, &req)
+	_, hasHash := req.Extensions["persistedQuery"]
+	switch {
+	case hasHash && req.Query != "":
+		return "hash+query"
+	case hasHash:
+		return "hash"
+	}
+	return "query"
+}
+
+func TestPersistedQueries(t *testing.T) {
+	tests := []struct {
+		name        string
+		supported   bool
+		want        []string
+		unsupported bool
+	}{
+		// The first operation misses, is retried with its query and
+		// registers it, so the second hits.
+		{name: "supported", supported: true, want: []string{"hash", "hash+query", "hash"}},
+		// A server without persisted queries gets full queries from then on.
+		{name: "not supported", want: []string{"hash", "query", "query"}, unsupported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, transport := range []string{"ws", "http"} {
+				t.Run(transport, func(t *testing.T) {
+					server := newTestMockServer(t, FaultPlan{})
+					server.noPersistedQueries = !tt.supported
+					pq := &PersistedQueries{}
+					exec, sent := persistedQueryExecutor(t, server, transport, pq)
+
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					for i := 0; i < 2; i++ {
+						payload, err := exec.Execute(ctx, OperationQuery, "sessions", `query sessions { sessions { id } }`, "")
+						if err == nil {
+							err = decodeResult(payload, &struct{}{})
+						}
+						if err != nil {
+							t.Fatalf("operation %d: %v", i+1, err)
+						}
+					}
+					if got := sent(); !slices.Equal(got, tt.want) {
+						t.Errorf("sent %v, want %v", got, tt.want)
+					}
+					if pq.unsupported.Load() != tt.unsupported {
+						t.Errorf("got unsupported %v, want %v", pq.unsupported.Load(), tt.unsupported)
+					}
+				})
+			}
+		})
+	}
+}
+
+// persistedQueryExecutor returns an executor for transport sending persisted
+// queries to server, and a function listing what its operations were sent
+// with.
+func persistedQueryExecutor(t *testing.T, server *mockServer, transport string, pq *PersistedQueries) (Executor, func() []string) {
+	var mu sync.Mutex
+	var sent []string
+	if transport == "http" {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			sent = append(sent, sentRequest(body))
+			mu.Unlock()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			server.ServeHTTP(w, r)
+		}))
+		t.Cleanup(ts.Close)
+		client := NewHTTPClient(ts.URL, http.MethodPost)
+		client.PersistedQueries = pq
+		return client, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return sent
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic = r
+	t.Cleanup(func() {
+		traffic = nil
+		r.Close()
+	})
+	client := dialMock(t, serveMock(t, server))
+	client.PersistedQueries = pq
+	return client, func() []string {
+		records, err := readTraffic(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sent []string
+		for _, rec := range records {
+			var msg GraphQLMessage
+			if rec.Direction == "out" && json.Unmarshal([]byte(rec.Payload), &msg) == nil && msg.Type == "subscribe" {
+				sent = append(sent, sentRequest(msg.Payload))
+			}
+		}
+		return sent
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// arrivalStage changes the arrival rate linearly from from to to iterations
+// per second over duration.
+type arrivalStage struct {
+	duration time.Duration
+	from, to float64
+}
+
+// arrivalSchedule is when iterations of an open model run start, whatever
+// the latency of earlier ones.
+type arrivalSchedule struct {
+	stages []arrivalStage
+}
+
+// parseArrival builds the schedule of an -arrival executor:
+//
+//	constant: rate iterations per second for duration
+//	ramping:  from rate, ramp linearly to each stage's rate over its duration
+//	stepped:  hold each stage's rate for its duration
+//
+// stages look like 30s:10,1m:100,30s:0.
+func parseArrival(kind string, rate float64, duration time.Duration, stages string) (*arrivalSchedule, error) {
+	sched := &arrivalSchedule{}
+	switch kind {
+	case "constant":
+		if rate <= 0 || duration <= 0 {
+			return nil, fmt.Errorf("constant arrivals need a positive -rate and -duration")
+		}
+		sched.stages = []arrivalStage{{duration: duration, from: rate, to: rate}}
+	case "ramping", "stepped":
+		if stages == "" {
+			return nil, fmt.Errorf("%s arrivals need -stages", kind)
+		}
+		if rate < 0 {
+			return nil, fmt.Errorf("invalid -rate %v", rate)
+		}
+		from := rate
+		for _, spec := range strings.Split(stages, ",") {
+			d, r, ok := strings.Cut(strings.TrimSpace(spec), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid stage %q, expected duration:rate", spec)
+			}
+			dur, err := time.ParseDuration(d)
+			if err != nil || dur <= 0 {
+				return nil, fmt.Errorf("invalid stage duration %q", d)
+			}
+			to, err := strconv.ParseFloat(r, 64)
+			if err != nil || to < 0 {
+				return nil, fmt.Errorf("invalid stage rate %q", r)
+			}
+			if kind == "stepped" {
+				from = to
+			}
+			sched.stages = append(sched.stages, arrivalStage{duration: dur, from: from, to: to})
+			from = to
+		}
+	default:
+		return nil, fmt.Errorf("unknown arrival executor %q, expected constant, ramping or stepped", kind)
+	}
+	return sched, nil
+}
+
+// at returns when iteration n (from 0) starts, relative to the start of the
+// schedule, or false once the schedule is over. Iteration n starts when
+// the integral of the rate reaches n.
+func (s *arrivalSchedule) at(n int64) (time.Duration, bool) {
+	var offset time.Duration
+	remaining := float64(n)
+	for _, st := range s.stages {
+		secs := st.duration.Seconds()
+		count := (st.from + st.to) / 2 * secs
+		if remaining < count {
+			// Solve from*t + (to-from)/(2*secs)*t² = remaining for t.
+			a, b := (st.to-st.from)/(2*secs), st.from
+			var t float64
+			if a == 0 {
+				t = remaining / b
+			} else {
+				t = (-b + math.Sqrt(max(b*b+4*a*remaining, 0))) / (2 * a)
+			}
+			return offset + time.Duration(t*float64(time.Second)), true
+		}
+		remaining -= count
+		offset += st.duration
+	}
+	return 0, false
+}
+
+// peak is the highest rate of the schedule.
+func (s *arrivalSchedule) peak() float64 {
+	var peak float64
+	for _, st := range s.stages {
+		peak = max(peak, st.from, st.to)
+	}
+	return peak
+}
+
+// arrivalExecutor starts iterations on schedule. Each goes to an idle
+// virtual user; when none is idle a new one is started, up to maxVUs, and
+// past that the iteration is dropped rather than delayed, so a slow system
+// under test shows up as dropped iterations instead of a lower rate.
+type arrivalExecutor struct {
+	schedule *arrivalSchedule
+	preVUs   int
+	maxVUs   int
+	// iterate runs iteration n, which was due at scheduled.
+	iterate func(n int64, scheduled time.Time)
+
+	vus     atomic.Int64
+	started atomic.Int64
+}
+
+func (e *arrivalExecutor) run(ctx context.Context) {
+	work := make(chan arrival)
+	var wg sync.WaitGroup
+	startVU := func() {
+		e.vus.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for a := range work {
+				e.started.Add(1)
+				e.iterate(a.n, a.scheduled)
+			}
+		}()
+	}
+	for i := 0; i < e.preVUs; i++ {
+		startVU()
+	}
+
+	begin := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for n := int64(0); ; n++ {
+		offset, ok := e.schedule.at(n)
+		if !ok {
+			break
+		}
+		scheduled := begin.Add(offset)
+		timer.Reset(time.Until(scheduled))
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		a := arrival{n: n + 1, scheduled: scheduled}
+		select {
+		case work <- a:
+			continue
+		default:
+		}
+		if int(e.vus.Load()) < e.maxVUs {
+			startVU()
+			slog.Debug("Started virtual user", "vus", e.vus.Load())
+			work <- a
+			continue
+		}
+		runStats.IterationDropped()
+		metrics.IterationDropped()
+	}
+	close(work)
+	wg.Wait()
+}
+
+// arrival is one scheduled iteration.
+type arrival struct {
+	n         int64
+	scheduled time.Time
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestArrivalScheduleAt(t *testing.T) {
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	type arrival struct {
+		n    int64
+		want time.Duration
+		ok   bool
+	}
+	tests := []struct {
+		name     string
+		kind     string
+		rate     float64
+		duration time.Duration
+		stages   string
+		arrivals []arrival
+	}{
+		{
+			name: "constant", kind: "constant", rate: 10, duration: time.Second,
+			arrivals: []arrival{{0, 0, true}, {5, 500 * time.Millisecond, true}, {9, 900 * time.Millisecond, true}, {10, 0, false}},
+		},
+		{
+			// The rate is 5t, so n iterations have started by 2.5t².
+			name: "ramp up from zero", kind: "ramping", stages: "2s:10",
+			arrivals: []arrival{{0, 0, true}, {1, seconds(math.Sqrt(0.4)), true}, {5, seconds(math.Sqrt2), true}, {10, 0, false}},
+		},
+		{
+			// The rate is 10-5t, so n iterations have started by 10t-2.5t².
+			name: "ramp down to zero", kind: "ramping", rate: 10, stages: "2s:0",
+			arrivals: []arrival{{0, 0, true}, {5, seconds(2 - math.Sqrt2), true}, {9, seconds(2 - math.Sqrt(0.4)), true}, {10, 0, false}},
+		},
+		{
+			// The second stage's rate is 10+20t, so 10t+10t² more have started.
+			name: "ramp across stages", kind: "ramping", rate: 10, stages: "1s:10,1s:30",
+			arrivals: []arrival{{10, time.Second, true}, {20, seconds(1 + (math.Sqrt(5)-1)/2), true}, {29, seconds(1 + (math.Sqrt(8.6)-1)/2), true}, {30, 0, false}},
+		},
+		{
+			name: "stepped with a pause", kind: "stepped", stages: "1s:10,1s:0,1s:20",
+			arrivals: []arrival{{9, 900 * time.Millisecond, true}, {10, 2 * time.Second, true}, {15, 2250 * time.Millisecond, true}, {30, 0, false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseArrival(tt.kind, tt.rate, tt.duration, tt.stages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range tt.arrivals {
+				got, ok := s.at(a.n)
+				if ok != a.ok {
+					t.Fatalf("at(%d) ok = %v, want %v", a.n, ok, a.ok)
+				}
+				if diff := got - a.want; diff < -time.Microsecond || diff > time.Microsecond {
+					t.Errorf("at(%d) = %v, want %v", a.n, got, a.want)
+				}
+			}
+		})
+	}
+}
+
+func TestArrivalScheduleIsMonotonic(t *testing.T) {
+	s, err := parseArrival("ramping", 5, 0, "10s:100,5s:100,10s:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last time.Duration
+	var n int64
+	for ; ; n++ {
+		at, ok := s.at(n)
+		if !ok {
+			break
+		}
+		if at < last {
+			t.Fatalf("at(%d) = %v is before at(%d) = %v", n, at, n-1, last)
+		}
+		last = at
+	}
+	// (5+100)/2*10 + 100*5 + 100/2*10 iterations in all.
+	if want := int64(525 + 500 + 500); n != want {
+		t.Errorf("got %d iterations, want %d", n, want)
+	}
+}
+
+func TestParseArrivalErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		rate     float64
+		duration time.Duration
+		stages   string
+	}{
+		{name: "constant without rate", kind: "constant", duration: time.Second},
+		{name: "constant without duration", kind: "constant", rate: 1},
+		{name: "ramping without stages", kind: "ramping"},
+		{name: "negative rate", kind: "ramping", rate: -1, stages: "1s:1"},
+		{name: "stage without rate", kind: "stepped", stages: "1s"},
+		{name: "bad stage duration", kind: "stepped", stages: "0s:1"},
+		{name: "bad stage rate", kind: "stepped", stages: "1s:-1"},
+		{name: "unknown kind", kind: "poisson", rate: 1, duration: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseArrival(tt.kind, tt.rate, tt.duration, tt.stages); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
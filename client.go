@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// GraphQLError is a single entry of a response's `errors` array.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLErrors []GraphQLError
+
+func (errs GraphQLErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Message
+	}
+	return "GraphQL errors: " + strings.Join(msgs, "; ")
+}
+
+var ErrClientClosed = errors.New("client closed")
+
+// Client multiplexes operations over one websocket connection. A single read
+// loop routes `next`, `error` and `complete` messages to the operation with
+// the matching ID, queueing them so one slow operation doesn't hold up the
+// others. Messages are handled in graphql-transport-ws terms and
+// translated by the Protocol on the way in and out.
+type Client struct {
+	conn    *websocket.Conn
+	proto   Protocol
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	ops  map[string]*queue[GraphQLMessage]
+	err  error
+	done chan struct{}
+	// stopped is closed when the read loop returns.
+	stopped chan struct{}
+
+	log         *slog.Logger
+	onUnhandled func(message []byte)
+	onFrame     atomic.Pointer[func(direction string, data []byte)]
+	// pingSent is when the unanswered ping was sent, in Unix nanoseconds.
+	pingSent atomic.Int64
+	pongs    chan time.Duration
+
+	// PersistedQueries, if set before the first operation, sends operations
+	// as persisted queries.
+	PersistedQueries *PersistedQueries
+}
+
+// NewClient takes over a connection that has completed connection_init.
+// onUnhandled, if not nil, is called from the read loop for messages that
+// don't belong to an active operation, other than pings and keepalives.
+func NewClient(conn *websocket.Conn, proto Protocol, onUnhandled func(message []byte)) *Client {
+	c := &Client{
+		conn:        conn,
+		proto:       proto,
+		ops:         make(map[string]*queue[GraphQLMessage]),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		pongs:       make(chan time.Duration, 1),
+		log:         slog.With("conn", shortID(uuid.NewString())),
+		onUnhandled: onUnhandled,
+	}
+	runStats.ConnectionOpened()
+	metrics.ConnectionOpened()
+	go c.readLoop()
+	return c
+}
+
+func (c *Client) readLoop() {
+	defer close(c.stopped)
+	for {
+		opcode, message, err := c.conn.ReadMessage()
+		if err != nil {
+			c.fail(err)
+			// Nothing can be read from the connection any more, so it is
+			// closed even if Close is never called.
+			c.conn.Close()
+			traffic.Closed(c.conn)
+			return
+		}
+		traffic.Frame(c.conn, "in", opcode, message)
+		c.frame("in", message)
+		var msg GraphQLMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			c.log.Warn("Error unmarshalling message", "err", err)
+			continue
+		}
+		c.log.Debug("Received message", "type", msg.Type, "id", msg.ID)
+		metrics.Message("received", msg.Type)
+		msg = c.proto.Incoming(msg)
+		switch msg.Type {
+		case "pong":
+			var rtt time.Duration
+			if sent := c.pingSent.Swap(0); sent != 0 {
+				rtt = time.Since(time.Unix(0, sent))
+			}
+			metrics.Pong(rtt)
+			if rtt > 0 {
+				select {
+				case c.pongs <- rtt:
+				default:
+				}
+			}
+		case "ka":
+			// The legacy protocol's keepalive needs no answer.
+		case "ping":
+			if err := c.write(GraphQLMessage{Type: "pong"}); err != nil {
+				c.log.Warn("Failed to send pong", "err", err)
+			}
+		case "next", "error", "complete":
+			c.mu.Lock()
+			q, ok := c.ops[msg.ID]
+			c.mu.Unlock()
+			if ok {
+				q.put(msg)
+				continue
+			}
+			fallthrough
+		default:
+			if c.onUnhandled != nil {
+				c.onUnhandled(message)
+			}
+		}
+	}
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.log.Debug("Connection closed", "reason", closeReason(err))
+	runStats.ConnectionClosed(err)
+	metrics.ConnectionClosed(err)
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the read loop, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) Close() error {
+	c.fail(ErrClientClosed)
+	c.write(GraphQLMessage{Type: "connection_terminate"})
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.writeMu.Lock()
+	if c.conn.WriteMessage(websocket.CloseMessage, closeMsg) == nil {
+		traffic.Frame(c.conn, "out", websocket.CloseMessage, closeMsg)
+	}
+	c.writeMu.Unlock()
+	err := c.conn.Close()
+	traffic.Closed(c.conn)
+	<-c.stopped
+	return err
+}
+
+func (c *Client) write(msg GraphQLMessage) error {
+	out, ok := c.proto.Outgoing(msg)
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	traffic.Frame(c.conn, "out", websocket.TextMessage, data)
+	c.frame("out", data)
+	metrics.Message("sent", out.Type)
+	if msg.Type == "ping" {
+		c.pingSent.Store(time.Now().UnixNano())
+		metrics.Ping()
+	}
+	return nil
+}
+
+// Ping sends a ping and returns the round trip time to its pong.
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	if !c.proto.SupportsPing() {
+		return 0, fmt.Errorf("%s has no ping", c.proto.Subprotocol())
+	}
+	select {
+	case <-c.pongs:
+	default:
+	}
+	if err := c.write(GraphQLMessage{Type: "ping"}); err != nil {
+		return 0, err
+	}
+	select {
+	case rtt := <-c.pongs:
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.done:
+		return 0, c.Err()
+	}
+}
+
+// OnFrame sets a function called with every message sent ("out") or
+// received ("in") on the connection, or removes it if fn is nil.
+func (c *Client) OnFrame(fn func(direction string, data []byte)) {
+	if fn == nil {
+		c.onFrame.Store(nil)
+		return
+	}
+	c.onFrame.Store(&fn)
+}
+
+func (c *Client) frame(direction string, data []byte) {
+	if fn := c.onFrame.Load(); fn != nil {
+		(*fn)(direction, data)
+	}
+}
+
+// operation is an in-flight subscribe message and where its responses go.
+type operation struct {
+	id     string
+	ch     <-chan GraphQLMessage
+	opType OperationType
+	prefix string
+
+	query     string
+	variables string
+	// ctx carries the operation's span, whose traceparent is sent in the
+	// payload extensions.
+	ctx  context.Context
+	span *Span
+	// hash is set when the operation is sent as a persisted query; hashOnly
+	// while the query text has not been sent with it.
+	hash     string
+	hashOnly bool
+}
+
+// start registers a new operation and sends its subscribe message. The
+// caller ends op.span when the operation finishes.
+func (c *Client) start(ctx context.Context, opType OperationType, prefix, query, variables string) (*operation, error) {
+	if err := checkOperation(opType, prefix, query, variables); err != nil {
+		return nil, err
+	}
+
+	op := &operation{opType: opType, prefix: prefix, query: query, variables: variables}
+	op.ctx, op.span = startOperationSpan(ctx, opType, prefix)
+	op.span.SetAttr("graphql.transport", "ws")
+	if c.PersistedQueries.enabled() {
+		hash, err := c.PersistedQueries.lookup(query)
+		if err != nil {
+			err = fmt.Errorf("%s %s: %w", prefix, opType, err)
+			op.span.End(err)
+			return nil, err
+		}
+		op.hash, op.hashOnly = hash, true
+	}
+	if err := c.send(op); err != nil {
+		op.span.End(err)
+		return nil, err
+	}
+	return op, nil
+}
+
+func (c *Client) send(op *operation) error {
+	op.id = uuid.New().String()
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	q := newQueue[GraphQLMessage]()
+	c.ops[op.id] = q
+	op.ch = q.out
+	c.mu.Unlock()
+
+	query := op.query
+	var extensions map[string]interface{}
+	if op.hash != "" {
+		extensions = persistedQueryExtension(op.hash)
+		if op.hashOnly {
+			query = ""
+		}
+	}
+	op.span.SetAttr("graphql.operation.id", op.id)
+	msg := GraphQLMessage{
+		ID:      op.id,
+		Type:    "subscribe",
+		Payload: marshalPayload(query, op.variables, traceExtensions(op.ctx, extensions)),
+	}
+	if err := c.write(msg); err != nil {
+		c.remove(op.id)
+		return fmt.Errorf("error sending %s: %w", op.opType, err)
+	}
+	logSent(c.log.With("id", op.id, "transport", "ws"), op.opType, op.prefix, query, op.variables, op.hash)
+	return nil
+}
+
+// retryWithQuery re-sends a hash-only operation with its query text if msg
+// says the server doesn't know the hash, or without the hash if it doesn't
+// support persisted queries. It reports whether it did so.
+func (c *Client) retryWithQuery(op *operation, msg GraphQLMessage) (bool, error) {
+	if !op.hashOnly || c.PersistedQueries.manifest != nil {
+		return false, nil
+	}
+	switch {
+	case isPersistedQueryNotFound(msg):
+	case isPersistedQueryNotSupported(msg):
+		c.PersistedQueries.unsupported.Store(true)
+		op.hash = ""
+	default:
+		return false, nil
+	}
+	c.remove(op.id)
+	op.hashOnly = false
+	return true, c.send(op)
+}
+
+func (c *Client) remove(id string) {
+	c.mu.Lock()
+	q, ok := c.ops[id]
+	delete(c.ops, id)
+	c.mu.Unlock()
+	if ok {
+		q.close()
+	}
+}
+
+// queue is an unbounded buffer in front of a channel: put never blocks, and
+// a goroutine feeds out until the queue is closed.
+type queue[T any] struct {
+	out chan T
+
+	mu     sync.Mutex
+	items  []T
+	wake   chan struct{}
+	closed chan struct{}
+}
+
+func newQueue[T any]() *queue[T] {
+	q := &queue[T]{
+		out:    make(chan T),
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	go q.feed()
+	return q
+}
+
+func (q *queue[T]) put(item T) {
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue[T]) feed() {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+				continue
+			case <-q.closed:
+				return
+			}
+		}
+		item := q.items[0]
+		var zero T
+		q.items[0] = zero
+		q.items = q.items[1:]
+		q.mu.Unlock()
+		select {
+		case q.out <- item:
+		case <-q.closed:
+			return
+		}
+	}
+}
+
+// close stops feeding out and drops what is still queued. It must be called
+// once.
+func (q *queue[T]) close() {
+	close(q.closed)
+}
+
+// Execute runs a single-result operation and returns the payload of its last
+// `next` message, or the merged result if it was delivered incrementally. An
+// `error` message is returned as GraphQLErrors.
+func (c *Client) Execute(ctx context.Context, opType OperationType, prefix, query, variables string) (json.RawMessage, error) {
+	result, err := c.ExecuteIncremental(ctx, opType, prefix, query, variables)
+	if err != nil {
+		return nil, err
+	}
+	return result.Payload(), nil
+}
+
+// ExecuteIncremental runs an operation that may use @defer or @stream,
+// merging the `next` payloads that follow the initial result.
+func (c *Client) ExecuteIncremental(ctx context.Context, opType OperationType, prefix, query, variables string) (result *IncrementalResult, err error) {
+	op, err := c.start(ctx, opType, prefix, query, variables)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.remove(op.id) }()
+	defer func() { endOperationSpan(op.span, result, err) }()
+
+	result = &IncrementalResult{}
+	for {
+		select {
+		case msg := <-op.ch:
+			if retried, err := c.retryWithQuery(op, msg); retried || err != nil {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			switch msg.Type {
+			case "next":
+				if err := result.Apply(msg.Payload); err != nil {
+					return nil, err
+				}
+			case "error":
+				return nil, decodeErrorPayload(msg.Payload)
+			case "complete":
+				return result, nil
+			}
+		case <-ctx.Done():
+			c.write(GraphQLMessage{ID: op.id, Type: "complete"})
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, c.Err()
+		}
+	}
+}
+
+// Subscriber starts subscriptions over some transport.
+type Subscriber interface {
+	Subscribe(ctx context.Context, prefix, query, variables string) (*Subscription, error)
+}
+
+// Subscription is a streaming operation started by a Subscriber.
+type Subscription struct {
+	Events <-chan json.RawMessage
+
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	id     string
+	err    error
+}
+
+// Subscribe starts a subscription. Events is closed when the server completes
+// the operation, the context is cancelled, Close is called or the connection
+// is lost; Err reports why.
+func (c *Client) Subscribe(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	op, err := c.start(ctx, OperationSubscription, prefix, query, variables)
+	if err != nil {
+		return nil, err
+	}
+	ctx, sub, events := newSubscription(ctx, op.id)
+
+	metrics.SubscriptionStarted("ws")
+	go func() {
+		defer metrics.SubscriptionEnded("ws")
+		defer close(events)
+		defer func() { c.remove(op.id) }()
+		defer func() { op.span.End(sub.Err()) }()
+		received := 0
+		for {
+			select {
+			case msg := <-op.ch:
+				if retried, err := c.retryWithQuery(op, msg); retried || err != nil {
+					if err != nil {
+						sub.setErr(err)
+						return
+					}
+					sub.mu.Lock()
+					sub.id = op.id
+					sub.mu.Unlock()
+					continue
+				}
+				switch msg.Type {
+				case "next":
+					received++
+					_, span := tracer.Start(op.ctx, "subscription event "+prefix, spanKindInternal)
+					span.SetAttr("graphql.operation.id", op.id)
+					span.SetAttr("graphql.subscription.event", received)
+					select {
+					case events <- msg.Payload:
+					case <-ctx.Done():
+					}
+					span.End(nil)
+				case "error":
+					sub.setErr(decodeErrorPayload(msg.Payload))
+					return
+				case "complete":
+					return
+				}
+			case <-ctx.Done():
+				c.write(GraphQLMessage{ID: op.id, Type: "complete"})
+				sub.setErr(ctx.Err())
+				return
+			case <-c.done:
+				sub.setErr(c.Err())
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// newSubscription returns a Subscription whose Close cancels the returned
+// context, and the channel its transport delivers events on.
+func newSubscription(ctx context.Context, id string) (context.Context, *Subscription, chan json.RawMessage) {
+	events := make(chan json.RawMessage, 16)
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &Subscription{Events: events, cancel: cancel, id: id}, events
+}
+
+// ID is the operation ID the subscription currently runs under.
+func (s *Subscription) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+func (s *Subscription) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Err returns why the subscription ended, or nil if the server completed it.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errors.Is(s.err, context.Canceled) {
+		return nil
+	}
+	return s.err
+}
+
+// Close stops the subscription, telling the server with a `complete` message.
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+func decodeErrorPayload(payload json.RawMessage) error {
+	var errs GraphQLErrors
+	if err := json.Unmarshal(payload, &errs); err != nil || len(errs) == 0 {
+		return fmt.Errorf("operation failed: %s", string(payload))
+	}
+	return errs
+}
+
+// decodeResult unmarshals the `data` of a result payload into out and returns
+// any GraphQL errors alongside it.
+func decodeResult(payload json.RawMessage, out interface{}) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("payload is nil or empty")
+	}
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors GraphQLErrors   `json:"errors"`
+	}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return fmt.Errorf("error unmarshalling payload: %w", err)
+	}
+	if len(result.Data) > 0 && string(result.Data) != "null" {
+		if err := json.Unmarshal(result.Data, out); err != nil {
+			return fmt.Errorf("error unmarshalling data: %w", err)
+		}
+	}
+	if len(result.Errors) > 0 {
+		return result.Errors
+	}
+	return nil
+}
+
+// Event is a decoded subscription event. Data is nil if the event carried
+// only errors or could not be decoded.
+type Event[T any] struct {
+	Data *T
+	Err  error
+}
+
+// decodeEvents converts a subscription's raw payloads into typed events. The
+// returned channel is closed when sub.Events is.
+func decodeEvents[T any](sub *Subscription) <-chan Event[T] {
+	out := make(chan Event[T], 16)
+	go func() {
+		defer close(out)
+		for payload := range sub.Events {
+			var data *T
+			err := decodeResult(payload, &data)
+			var gqlErrs GraphQLErrors
+			if err != nil && !errors.As(err, &gqlErrs) {
+				data = nil
+			}
+			out <- Event[T]{Data: data, Err: err}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startMockServer serves the mock GraphQL server for the length of the test
+// and returns its websocket URL.
+func startMockServer(t *testing.T, plan FaultPlan) string {
+	t.Helper()
+	return serveMock(t, newTestMockServer(t, plan))
+}
+
+func newTestMockServer(t *testing.T, plan FaultPlan) *mockServer {
+	t.Helper()
+	server, err := newMockServer(time.Second, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// serveMock serves server for the length of the test and returns its
+// websocket URL.
+func serveMock(t *testing.T, server *mockServer) string {
+	t.Helper()
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func dialMock(t *testing.T, url string) *Client {
+	t.Helper()
+	conn, proto, err := dial(url, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn, proto, nil)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestQueue(t *testing.T) {
+	q := newQueue[int]()
+	defer q.close()
+	for i := 0; i < 1000; i++ {
+		q.put(i)
+	}
+	for i := 0; i < 1000; i++ {
+		select {
+		case got := <-q.out:
+			if got != i {
+				t.Fatalf("got %d, want %d", got, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for item %d", i)
+		}
+	}
+}
+
+// A subscription nobody reads must not hold up other operations on the
+// connection.
+func TestClientSlowSubscription(t *testing.T) {
+	client := dialMock(t, startMockServer(t, FaultPlan{}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.Subscribe(ctx, "stalled", sessionUpdatesSubscription, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := client.Execute(ctx, OperationMutation, "createSession", gqlCreateSessionDocument, `{"input": [{"name": "a"}]}`); err != nil {
+			t.Fatalf("mutation %d: %v", i, err)
+		}
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	type session struct {
+		ID string `json:"id"`
+	}
+	tests := []struct {
+		payload  string
+		wantData bool
+		wantErr  bool
+	}{
+		{payload: `{"data": {"id": "1"}}`, wantData: true},
+		{payload: `{"data": {"id": "1"}, "errors": [{"message": "partial"}]}`, wantData: true, wantErr: true},
+		{payload: `{"data": null, "errors": [{"message": "failed"}]}`, wantErr: true},
+		{payload: `{"errors": [{"message": "failed"}]}`, wantErr: true},
+		{payload: `{"data": {"id": 1}}`, wantErr: true},
+		{payload: `not json`, wantErr: true},
+	}
+	sub := &Subscription{}
+	raw := make(chan json.RawMessage, len(tests))
+	sub.Events = raw
+	for _, tt := range tests {
+		raw <- json.RawMessage(tt.payload)
+	}
+	close(raw)
+	decoded := decodeEvents[session](sub)
+	for _, tt := range tests {
+		ev := <-decoded
+		if (ev.Data != nil) != tt.wantData || (ev.Err != nil) != tt.wantErr {
+			t.Errorf("%s: got data %v and error %v", tt.payload, ev.Data, ev.Err)
+		}
+		if ev.Data != nil && ev.Data.ID != "1" {
+			t.Errorf("%s: got id %q", tt.payload, ev.Data.ID)
+		}
+	}
+}
+
+func TestClientKeepsPongsToItself(t *testing.T) {
+	conn, proto, err := dial(startMockServer(t, FaultPlan{}), "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unhandled := make(chan []byte, 10)
+	client := NewClient(conn, proto, func(message []byte) { unhandled <- message })
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Execute(ctx, OperationQuery, "sessions", `{ sessions { id } }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-unhandled:
+		t.Errorf("pong was passed on as unhandled: %s", msg)
+	default:
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadReport reads a JSON run report written with -report.
+func loadReport(path string) (*RunReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON run report: %w", path, err)
+	}
+	return &report, nil
+}
+
+// runCompare prints per-operation differences between two run reports and
+// exits with status 1 if any operation regressed.
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 5, "smallest change, in percent, that counts as a regression or improvement")
+	alpha := fs.Float64("alpha", 0.01, "significance level: changes whose p-value is above it are treated as noise")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: compare [flags] BEFORE.json AFTER.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	before, err := loadReport(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error loading report: %v", err)
+	}
+	after, err := loadReport(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("Error loading report: %v", err)
+	}
+
+	lines, regressions := compareReports(before, after, *threshold, *alpha)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if regressions > 0 {
+		fmt.Printf("\n%d regressions\n", regressions)
+		os.Exit(1)
+	}
+	fmt.Println("\nNo regressions")
+}
+
+// comparison is one compared metric of an operation.
+type comparison struct {
+	metric        string
+	before, after string
+	change        float64
+	// pValue is NaN when there is nothing to test the change with.
+	pValue float64
+	// worse is the sign of a change for the worse: 1 when higher is worse.
+	worse float64
+}
+
+// verdict flags changes larger than threshold percent whose p-value is
+// below alpha. Without a p-value, the threshold alone decides.
+func (c comparison) verdict(threshold, alpha float64) string {
+	if math.IsNaN(c.change) || math.Abs(c.change) < threshold || c.pValue >= alpha {
+		return ""
+	}
+	if c.change*c.worse > 0 {
+		return "REGRESSION"
+	}
+	return "improved"
+}
+
+func compareReports(before, after *RunReport, threshold, alpha float64) ([]string, int) {
+	beforeOps := make(map[string]OperationReport)
+	afterOps := make(map[string]OperationReport)
+	var names []string
+	for _, op := range before.Operations {
+		beforeOps[op.Name] = op
+		names = append(names, op.Name)
+	}
+	for _, op := range after.Operations {
+		afterOps[op.Name] = op
+		if _, ok := beforeOps[op.Name]; !ok {
+			names = append(names, op.Name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := []string{
+		fmt.Sprintf("Before: %s (%.0fs)    After: %s (%.0fs)", before.Started.Format(time.RFC3339), before.DurationSeconds, after.Started.Format(time.RFC3339), after.DurationSeconds),
+		"",
+		fmt.Sprintf("%-20s %-11s %10s %10s %9s %9s", "Operation", "Metric", "Before", "After", "Change", "p-value"),
+	}
+	regressions := 0
+	for _, name := range names {
+		b, inBefore := beforeOps[name]
+		a, inAfter := afterOps[name]
+		// An operation that stopped running, e.g. because an earlier step now
+		// fails, is a regression; a new one is not.
+		if !inAfter {
+			lines = append(lines, fmt.Sprintf("%-20s missing from the after run  REGRESSION", name))
+			regressions++
+			continue
+		}
+		if !inBefore {
+			lines = append(lines, fmt.Sprintf("%-20s only in the after run", name))
+			continue
+		}
+		for _, c := range compareOperations(b, a, before.DurationSeconds, after.DurationSeconds) {
+			verdict := c.verdict(threshold, alpha)
+			if verdict == "REGRESSION" {
+				regressions++
+			}
+			p := "-"
+			if !math.IsNaN(c.pValue) {
+				p = fmt.Sprintf("%.4f", c.pValue)
+			}
+			line := fmt.Sprintf("%-20s %-11s %10s %10s %9s %9s  %s", name, c.metric, c.before, c.after, formatChange(c.change), p, verdict)
+			lines = append(lines, strings.TrimRight(line, " "))
+			name = ""
+		}
+	}
+	return lines, regressions
+}
+
+// compareOperations compares the latencies, throughput and error rate of one
+// operation. Latencies are tested with a Mann-Whitney U test on the
+// histograms, throughput as two Poisson rates and error rates as two
+// proportions.
+func compareOperations(b, a OperationReport, beforeSecs, afterSecs float64) []comparison {
+	latencyP := mannWhitney(b.Histogram, a.Histogram)
+	latency := func(metric string, before, after float64) comparison {
+		return comparison{
+			metric: metric,
+			before: formatLatency(msDuration(before)),
+			after:  formatLatency(msDuration(after)),
+			change: percentChange(before, after),
+			pValue: latencyP,
+			worse:  1,
+		}
+	}
+	return []comparison{
+		latency("p50", b.P50Ms, a.P50Ms),
+		latency("p90", b.P90Ms, a.P90Ms),
+		latency("p95", b.P95Ms, a.P95Ms),
+		latency("p99", b.P99Ms, a.P99Ms),
+		latency("mean", b.MeanMs, a.MeanMs),
+		{
+			metric: "throughput",
+			before: fmt.Sprintf("%.1f/s", b.Throughput),
+			after:  fmt.Sprintf("%.1f/s", a.Throughput),
+			change: percentChange(b.Throughput, a.Throughput),
+			pValue: poissonRatesTest(b.Count, beforeSecs, a.Count, afterSecs),
+			worse:  -1,
+		},
+		{
+			metric: "error rate",
+			before: fmt.Sprintf("%.2f%%", 100*b.ErrorRate),
+			after:  fmt.Sprintf("%.2f%%", 100*a.ErrorRate),
+			change: percentChange(b.ErrorRate, a.ErrorRate),
+			pValue: proportionsTest(b.Errors, b.Count, a.Errors, a.Count),
+			worse:  1,
+		},
+	}
+}
+
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// percentChange is the change from before to after in percent: infinite
+// when before is zero and after is not, and zero when both are.
+func percentChange(before, after float64) float64 {
+	if before == 0 {
+		if after == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (after - before) / before * 100
+}
+
+func formatChange(change float64) string {
+	if math.IsInf(change, 1) {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", change)
+}
+
+// mannWhitney returns the two-sided p-value of a Mann-Whitney U test that
+// the latencies of two histograms come from the same distribution, using
+// the normal approximation with a correction for ties. Samples in the same
+// bucket are ties. It is NaN if either histogram is empty.
+func mannWhitney(before, after []HistogramBucket) float64 {
+	counts := make(map[float64][2]float64)
+	var n1, n2 float64
+	for _, b := range before {
+		c := counts[b.UpperMs]
+		c[0] += float64(b.Count)
+		counts[b.UpperMs] = c
+		n1 += float64(b.Count)
+	}
+	for _, b := range after {
+		c := counts[b.UpperMs]
+		c[1] += float64(b.Count)
+		counts[b.UpperMs] = c
+		n2 += float64(b.Count)
+	}
+	if n1 == 0 || n2 == 0 {
+		return math.NaN()
+	}
+	uppers := make([]float64, 0, len(counts))
+	for upper := range counts {
+		uppers = append(uppers, upper)
+	}
+	sort.Float64s(uppers)
+
+	// Sum the ranks of the before samples, giving tied samples the mean of
+	// the ranks they span.
+	var rank, rankSum, ties float64
+	for _, upper := range uppers {
+		c := counts[upper]
+		t := c[0] + c[1]
+		rankSum += c[0] * (rank + (t+1)/2)
+		rank += t
+		ties += t*t*t - t
+	}
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (u - n1*n2/2) / math.Sqrt(variance)
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// poissonRatesTest returns the two-sided p-value that c1 events in t1
+// seconds and c2 events in t2 seconds happened at the same rate.
+func poissonRatesTest(c1 uint64, t1 float64, c2 uint64, t2 float64) float64 {
+	if t1 <= 0 || t2 <= 0 || c1+c2 == 0 {
+		return math.NaN()
+	}
+	r1, r2 := float64(c1)/t1, float64(c2)/t2
+	se := math.Sqrt(float64(c1)/(t1*t1) + float64(c2)/(t2*t2))
+	return math.Erfc(math.Abs(r2-r1) / se / math.Sqrt2)
+}
+
+// proportionsTest returns the two-sided p-value of a two-proportion z-test
+// that x1 of n1 and x2 of n2 happened at the same rate.
+func proportionsTest(x1, n1, x2, n2 uint64) float64 {
+	if n1 == 0 || n2 == 0 {
+		return math.NaN()
+	}
+	p := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(p * (1 - p) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(x2)/float64(n2) - float64(x1)/float64(n1)) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
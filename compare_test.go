@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// closeTo reports whether got is within a relative 1e-6 of want, treating
+// two NaNs as equal.
+func closeTo(got, want float64) bool {
+	if math.IsNaN(want) {
+		return math.IsNaN(got)
+	}
+	return math.Abs(got-want) <= 1e-6*math.Max(math.Abs(want), 1e-300)
+}
+
+func TestMannWhitney(t *testing.T) {
+	buckets := func(uppers ...float64) []HistogramBucket {
+		var h []HistogramBucket
+		for _, upper := range uppers {
+			h = append(h, HistogramBucket{UpperMs: upper, Count: 1})
+		}
+		return h
+	}
+	tests := []struct {
+		name          string
+		before, after []HistogramBucket
+		want          float64
+	}{
+		{
+			// U = 3 against a mean of 4.5 and a variance of 5.25.
+			name:   "interleaved without ties",
+			before: buckets(1, 3, 5),
+			after:  buckets(2, 4, 6),
+			want:   0.5126907602619234,
+		},
+		{
+			// U = 0; ten ties in each bucket leave a variance of 131.58.
+			name:   "separated with ties",
+			before: []HistogramBucket{{UpperMs: 1, Count: 10}},
+			after:  []HistogramBucket{{UpperMs: 2, Count: 10}},
+			want:   1.3071845366763056e-05,
+		},
+		{
+			name:   "identical",
+			before: []HistogramBucket{{UpperMs: 1, Count: 5}, {UpperMs: 2, Count: 5}},
+			after:  []HistogramBucket{{UpperMs: 1, Count: 5}, {UpperMs: 2, Count: 5}},
+			want:   1,
+		},
+		{
+			name:   "all tied",
+			before: []HistogramBucket{{UpperMs: 1, Count: 5}},
+			after:  []HistogramBucket{{UpperMs: 1, Count: 7}},
+			want:   1,
+		},
+		{
+			name:   "empty",
+			before: buckets(1, 2),
+			want:   math.NaN(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mannWhitney(tt.before, tt.after); !closeTo(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			// The test is symmetric.
+			if got := mannWhitney(tt.after, tt.before); !closeTo(got, tt.want) {
+				t.Errorf("swapped: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoissonRatesTest(t *testing.T) {
+	tests := []struct {
+		name string
+		c1   uint64
+		t1   float64
+		c2   uint64
+		t2   float64
+		want float64
+	}{
+		// 10/s against 15/s: z = 5/√2.5.
+		{name: "different rates", c1: 100, t1: 10, c2: 150, t2: 10, want: 0.0015654022580025523},
+		{name: "same rate over different durations", c1: 100, t1: 10, c2: 200, t2: 20, want: 1},
+		{name: "no events", t1: 10, t2: 10, want: math.NaN()},
+		{name: "no duration", c1: 100, c2: 100, t2: 10, want: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := poissonRatesTest(tt.c1, tt.t1, tt.c2, tt.t2); !closeTo(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProportionsTest(t *testing.T) {
+	tests := []struct {
+		name           string
+		x1, n1, x2, n2 uint64
+		want           float64
+	}{
+		// 10% against 20%: the pooled rate is 15%, z = 0.1/√(0.15·0.85·0.02).
+		{name: "different rates", x1: 10, n1: 100, x2: 20, n2: 100, want: 0.04767038065616144},
+		{name: "same rate", x1: 5, n1: 100, x2: 10, n2: 200, want: 1},
+		{name: "no errors", n1: 100, n2: 100, want: 1},
+		{name: "no samples", n1: 100, want: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proportionsTest(tt.x1, tt.n1, tt.x2, tt.n2); !closeTo(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareReportsMissingOperations(t *testing.T) {
+	op := func(name string) OperationReport {
+		return OperationReport{Name: name, Count: 100, Throughput: 10, P50Ms: 5, Histogram: []HistogramBucket{{UpperMs: 5, Count: 100}}}
+	}
+	before := &RunReport{DurationSeconds: 10, Operations: []OperationReport{op("createSession"), op("deleteSession")}}
+	after := &RunReport{DurationSeconds: 10, Operations: []OperationReport{op("createSession"), op("listSessions")}}
+
+	lines, regressions := compareReports(before, after, 5, 0.01)
+	if regressions != 1 {
+		t.Errorf("got %d regressions, want 1", regressions)
+	}
+	out := strings.Join(lines, "\n")
+	for _, want := range []string{"deleteSession        missing from the after run  REGRESSION", "listSessions         only in the after run"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
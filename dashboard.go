@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dashboard redraws the run's stats every second on the terminal's
+// alternate screen. While it runs, output written to stdout and stderr,
+// including logs, goes to the event log instead of the screen.
+type dashboard struct {
+	stats    *Stats
+	title    func() string
+	term     *os.File
+	stderr   *os.File
+	captured *os.File
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func startDashboard(stats *Stats, title func() string) *dashboard {
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("Error starting dashboard: %v", err)
+	}
+	d := &dashboard{stats: stats, title: title, term: os.Stdout, stderr: os.Stderr, captured: w, done: make(chan struct{}), finished: make(chan struct{})}
+	os.Stdout, os.Stderr = w, w
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			stats.Event(scanner.Text())
+		}
+	}()
+	go func() {
+		defer close(d.finished)
+		// Switch to the alternate screen and hide the cursor.
+		fmt.Fprint(d.term, "\x1b[?1049h\x1b[?25l")
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			d.draw()
+			select {
+			case <-d.done:
+				fmt.Fprint(d.term, "\x1b[?25h\x1b[?1049l")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return d
+}
+
+// Stop restores the terminal and the captured outputs.
+func (d *dashboard) Stop() {
+	close(d.done)
+	<-d.finished
+	os.Stdout, os.Stderr = d.term, d.stderr
+	d.captured.Close()
+}
+
+func (d *dashboard) draw() {
+	width, height := terminalSize()
+	// The dashboard doesn't chart the timeline, and redraws every second.
+	snap := d.stats.snapshot(false)
+	lines := []string{d.title(), ""}
+	lines = append(lines, statsTable(snap)...)
+
+	lines = append(lines, "", "Events")
+	room := height - len(lines) - 1
+	events := snap.Events
+	if room < 0 {
+		room = 0
+	}
+	if len(events) > room {
+		events = events[len(events)-room:]
+	}
+	lines = append(lines, events...)
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		if r := []rune(line); len(r) > width {
+			line = string(r[:width])
+		}
+		sb.WriteString(line)
+		sb.WriteString("\r\n")
+	}
+	io.WriteString(d.term, sb.String())
+}
+
+// terminalSize reads the size from $COLUMNS and $LINES, as exported by most
+// shells, defaulting to 120x40.
+func terminalSize() (int, int) {
+	width, height := 120, 40
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		width = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		height = n
+	}
+	return width, height
+}
+
+// statsTable renders connections, per-operation throughput and latency
+// percentiles, and error counts.
+func statsTable(snap Snapshot) []string {
+	inFlight := 0
+	var total uint64
+	var rate float64
+	for _, op := range snap.Operations {
+		inFlight += op.InFlight
+		total += op.Count
+		rate += op.Rate
+	}
+	avg := float64(0)
+	if secs := snap.Elapsed.Seconds(); secs > 0 {
+		avg = float64(total) / secs
+	}
+
+	lines := []string{
+		fmt.Sprintf("Connections: %d active, %d opened    In-flight: %d    Throughput: %.1f ops/s (avg %.1f)", snap.ConnsOpen, snap.ConnsOpened, inFlight, rate, avg),
+		"",
+		fmt.Sprintf("%-20s %8s %7s %7s %9s %9s %9s %9s %9s", "Operation", "Count", "Errors", "Rate/s", "p50", "p90", "p95", "p99", "Max"),
+	}
+	for _, op := range snap.Operations {
+		h := &op.Latency
+		lines = append(lines, fmt.Sprintf("%-20s %8d %7d %7.1f %9s %9s %9s %9s %9s", op.Name, op.Count, op.Errors, op.Rate,
+			formatLatency(h.Percentile(50)), formatLatency(h.Percentile(90)), formatLatency(h.Percentile(95)), formatLatency(h.Percentile(99)), formatLatency(h.Max)))
+	}
+
+	if snap.Dropped > 0 {
+		lines = append(lines, "", fmt.Sprintf("Dropped iterations: %d", snap.Dropped))
+	}
+
+	lines = append(lines, "", fmt.Sprintf("%-40s %s", "Errors", "Close codes"))
+	errs, codes := sortedCounts(snap.Errors), sortedCounts(snap.CloseCodes)
+	for i := 0; i < len(errs) || i < len(codes); i++ {
+		var left, right string
+		if i < len(errs) {
+			left = errs[i]
+		}
+		if i < len(codes) {
+			right = codes[i]
+		}
+		lines = append(lines, fmt.Sprintf("%-40s %s", left, right))
+	}
+
+	if len(snap.Sequences) > 0 {
+		lines = append(lines, "", fmt.Sprintf("%-20s %8s %7s %8s %10s %12s %11s", "Subscription", "Events", "Gaps", "Missing", "Duplicates", "Out of order", "Unsequenced"))
+		for _, seq := range snap.Sequences {
+			lines = append(lines, fmt.Sprintf("%-20s %8d %7d %8d %10d %12d %11d", seq.Subscription, seq.Events, seq.Gaps, seq.Missing, seq.Duplicates, seq.OutOfOrder, seq.Unsequenced))
+		}
+	}
+	return lines
+}
+
+func sortedCounts(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = fmt.Sprintf("  %-30s %6d", k, counts[k])
+	}
+	return lines
+}
+
+func formatLatency(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	}
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+)
+
+// runExecute sends one query or mutation and prints its result. Operations
+// using @defer or @stream print each patch as well as the merged result.
+func runExecute(args []string) {
+	fs := flag.NewFlagSet("execute", flag.ExitOnError)
+	url := fs.String("url", defaultURL, "GraphQL websocket endpoint")
+	protocol := fs.String("protocol", "auto", "websocket subprotocol: graphql-transport-ws, graphql-ws, or auto")
+	transport := fs.String("transport", "ws", "transport: ws or http")
+	httpEndpoint := fs.String("http-url", "", "HTTP endpoint for the http transport (default: -url with http(s) scheme)")
+	httpMethod := fs.String("http-method", "POST", "HTTP method for the http transport: POST or GET (queries only)")
+	name := fs.String("name", "execute", "name used for the operation in output")
+	query := fs.String("query", "", "query or mutation document")
+	queryFile := fs.String("query-file", "", "read the document from a file")
+	variables := fs.String("vars", "", "variables as a JSON object")
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result used to validate the operation")
+	record := fs.String("record", "", "record websocket frames and handshake headers, with secrets redacted, to this JSONL file")
+	traceEndpoint := fs.String("trace-endpoint", "", "export OpenTelemetry spans as OTLP/JSON to this collector URL, e.g. http://localhost:4318/v1/traces")
+	traceFile := fs.String("trace-file", "", "append OpenTelemetry spans as OTLP/JSON lines to this file")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	logOpts.setup()
+
+	startRecording(*record)
+	defer traffic.Close()
+	startTracing(*traceEndpoint, *traceFile)
+	defer tracer.Close()
+
+	if *queryFile != "" {
+		src, err := os.ReadFile(*queryFile)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", *queryFile, err)
+		}
+		*query = string(src)
+	}
+	if *query == "" {
+		log.Fatalf("One of -query or -query-file is required")
+	}
+	doc, err := parseDocument(*query)
+	if err != nil {
+		log.Fatalf("Invalid operation: %v", err)
+	}
+	if len(doc.Operations) != 1 {
+		log.Fatalf("Document must contain exactly one operation, found %d", len(doc.Operations))
+	}
+	opType := doc.Operations[0].Type
+	if opType == OperationSubscription {
+		log.Fatalf("Use the subscribe command for subscriptions")
+	}
+	if schema, err := loadSchema(*schemaPath); err == nil {
+		cachedSchema = schema
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var result *IncrementalResult
+	switch *transport {
+	case "ws":
+		conn, proto := connect(*url, *protocol)
+		client := NewClient(conn, proto, nil)
+		defer client.Close()
+		result, err = client.ExecuteIncremental(ctx, opType, *name, *query, *variables)
+	case "http":
+		if *httpEndpoint == "" {
+			*httpEndpoint = httpURL(*url)
+		}
+		result, err = NewHTTPClient(*httpEndpoint, *httpMethod).ExecuteIncremental(ctx, opType, *name, *query, *variables)
+	default:
+		log.Fatalf("Invalid -transport %q, expected ws or http", *transport)
+	}
+	if err != nil {
+		log.Fatalf("Error executing %s: %v", *name, err)
+	}
+
+	for i, patch := range result.Patches {
+		b, _ := json.Marshal(patch)
+		fmt.Printf("Patch %d: %s\n", i+1, string(b))
+	}
+	fmt.Printf("Result: %s\n", string(result.Payload()))
+}
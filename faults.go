@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Faults are the failures the mock server injects into an operation's
+// responses. Rates are probabilities between 0 and 1.
+type Faults struct {
+	// Latency delays every message sent for the operation, plus a random
+	// amount up to Jitter.
+	Latency Duration `json:"latency,omitempty"`
+	Jitter  Duration `json:"jitter,omitempty"`
+	// DropRate and DuplicateRate apply to each `next` message.
+	DropRate      float64 `json:"dropRate,omitempty"`
+	DuplicateRate float64 `json:"duplicateRate,omitempty"`
+	// Reorder sends `complete` before the result of a query or mutation.
+	Reorder bool `json:"reorder,omitempty"`
+	// ErrorRate replaces results with a GraphQL error.
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// CloseCode closes the connection instead of answering, with probability
+	// CloseRate, or always if CloseRate is 0.
+	CloseCode int     `json:"closeCode,omitempty"`
+	CloseRate float64 `json:"closeRate,omitempty"`
+	// StallPings leaves pings unanswered. It is only read from the default
+	// faults, since pings don't belong to an operation.
+	StallPings bool `json:"stallPings,omitempty"`
+}
+
+// FaultPlan holds the default faults and overrides keyed by operation name
+// or root field name.
+type FaultPlan struct {
+	Default    Faults            `json:"default"`
+	Operations map[string]Faults `json:"operations,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "250ms" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"250ms\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func loadFaultPlan(path string) (FaultPlan, error) {
+	var plan FaultPlan
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return plan, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := plan.validate(); err != nil {
+		return plan, fmt.Errorf("%s: %w", path, err)
+	}
+	return plan, nil
+}
+
+func (p FaultPlan) validate() error {
+	if err := p.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for name, faults := range p.Operations {
+		if err := faults.validate(); err != nil {
+			return fmt.Errorf("operation %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that rates are probabilities, durations aren't negative
+// and the close code is one a server may send.
+func (f Faults) validate() error {
+	rates := []struct {
+		name string
+		rate float64
+	}{{"dropRate", f.DropRate}, {"duplicateRate", f.DuplicateRate}, {"errorRate", f.ErrorRate}, {"closeRate", f.CloseRate}}
+	for _, r := range rates {
+		if r.rate < 0 || r.rate > 1 {
+			return fmt.Errorf("%s %v is not between 0 and 1", r.name, r.rate)
+		}
+	}
+	if f.Latency < 0 || f.Jitter < 0 {
+		return fmt.Errorf("latency and jitter must not be negative")
+	}
+	if f.CloseCode != 0 && !sendableCloseCode(f.CloseCode) {
+		return fmt.Errorf("closeCode %d cannot be sent (expected 1000-1003, 1007-1014 or 3000-4999)", f.CloseCode)
+	}
+	if f.CloseRate != 0 && f.CloseCode == 0 {
+		return fmt.Errorf("closeRate needs a closeCode")
+	}
+	return nil
+}
+
+// sendableCloseCode reports whether code may be sent in a close frame.
+// 1004 is reserved, and 1005, 1006 and 1015 only describe a close locally.
+func sendableCloseCode(code int) bool {
+	return (code >= 1000 && code <= 1003) || (code >= 1007 && code <= 1014) || (code >= 3000 && code <= 4999)
+}
+
+var closeReasons = map[int]string{
+	4400: "Bad request",
+	4401: "Unauthorized",
+	4403: "Forbidden",
+	4408: "Connection initialisation timeout",
+	4500: "Internal server error",
+}
+
+// faultInjector holds the fault plan, which the admin endpoint can change
+// while the server runs.
+type faultInjector struct {
+	mu   sync.RWMutex
+	plan FaultPlan
+}
+
+// For returns the faults for the first of names with an override, or the
+// default faults.
+func (f *faultInjector) For(names ...string) Faults {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, name := range names {
+		if faults, ok := f.plan.Operations[name]; ok {
+			return faults
+		}
+	}
+	return f.plan.Default
+}
+
+// ServeHTTP is the admin endpoint: GET returns the plan, PUT replaces it and
+// DELETE clears it. With an operation name appended to the path, PUT and
+// DELETE change only that operation's override.
+func (f *faultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op := r.PathValue("operation")
+	f.mu.Lock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if op == "" {
+			var plan FaultPlan
+			err := json.NewDecoder(r.Body).Decode(&plan)
+			if err == nil {
+				err = plan.validate()
+			}
+			if err != nil {
+				f.mu.Unlock()
+				http.Error(w, "invalid fault plan: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.plan = plan
+			break
+		}
+		var faults Faults
+		err := json.NewDecoder(r.Body).Decode(&faults)
+		if err == nil {
+			err = faults.validate()
+		}
+		if err != nil {
+			f.mu.Unlock()
+			http.Error(w, "invalid faults: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.plan.Operations == nil {
+			f.plan.Operations = make(map[string]Faults)
+		}
+		f.plan.Operations[op] = faults
+	case http.MethodDelete:
+		if op == "" {
+			f.plan = FaultPlan{}
+		} else {
+			delete(f.plan.Operations, op)
+		}
+	default:
+		f.mu.Unlock()
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	plan := f.plan
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// delay waits for the configured latency, or until ctx is done.
+func (f Faults) delay(ctx context.Context) error {
+	d := time.Duration(f.Latency)
+	if f.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(f.Jitter)))
+	}
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// shouldClose reports whether to close the connection instead of answering.
+func (f Faults) shouldClose() bool {
+	return f.CloseCode != 0 && (f.CloseRate == 0 || chance(f.CloseRate))
+}
+
+// injectedError is the result sent in place of real data at ErrorRate.
+func injectedError() map[string]interface{} {
+	return map[string]interface{}{
+		"data":   nil,
+		"errors": GraphQLErrors{{Message: "injected fault", Extensions: map[string]interface{}{"code": "INJECTED_FAULT"}}},
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFaultPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    string
+		want    FaultPlan
+		wantErr string
+	}{
+		{
+			name: "default and operations",
+			plan: `{"default": {"latency": "250ms", "jitter": "50ms", "stallPings": true}, "operations": {"createSessions": {"dropRate": 0.5, "closeCode": 4500, "closeRate": 1}}}`,
+			want: FaultPlan{
+				Default:    Faults{Latency: Duration(250 * time.Millisecond), Jitter: Duration(50 * time.Millisecond), StallPings: true},
+				Operations: map[string]Faults{"createSessions": {DropRate: 0.5, CloseCode: 4500, CloseRate: 1}},
+			},
+		},
+		{name: "empty", plan: `{}`},
+		{name: "normal closure", plan: `{"default": {"closeCode": 1000}}`, want: FaultPlan{Default: Faults{CloseCode: 1000}}},
+		{name: "rate above 1", plan: `{"default": {"errorRate": 1.5}}`, wantErr: "errorRate 1.5 is not between 0 and 1"},
+		{name: "negative rate", plan: `{"operations": {"sessions": {"duplicateRate": -0.1}}}`, wantErr: "operation sessions: duplicateRate"},
+		{name: "close code 1005", plan: `{"default": {"closeCode": 1005}}`, wantErr: "closeCode 1005 cannot be sent"},
+		{name: "close code 1006", plan: `{"default": {"closeCode": 1006}}`, wantErr: "closeCode 1006 cannot be sent"},
+		{name: "close code out of range", plan: `{"default": {"closeCode": 5000}}`, wantErr: "closeCode 5000 cannot be sent"},
+		{name: "close rate without code", plan: `{"default": {"closeRate": 0.5}}`, wantErr: "closeRate needs a closeCode"},
+		{name: "negative latency", plan: `{"default": {"latency": "-1s"}}`, wantErr: "must not be negative"},
+		{name: "duration as number", plan: `{"default": {"latency": 250}}`, wantErr: "duration must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "faults.json")
+			if err := os.WriteFile(path, []byte(tt.plan), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			plan, err := loadFaultPlan(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.Default != tt.want.Default || len(plan.Operations) != len(tt.want.Operations) {
+				t.Fatalf("got %+v, want %+v", plan, tt.want)
+			}
+			for name, want := range tt.want.Operations {
+				if plan.Operations[name] != want {
+					t.Errorf("operation %s: got %+v, want %+v", name, plan.Operations[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	url := startMockServer(t, FaultPlan{Operations: map[string]Faults{
+		"dropped": {DropRate: 1},
+		"failed":  {ErrorRate: 1},
+	}})
+	client := dialMock(t, url)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A dropped next leaves only the complete message.
+	payload, err := client.Execute(ctx, OperationQuery, "dropped", `query dropped { sessions { id } }`, "")
+	if err != nil || payload != nil {
+		t.Errorf("dropped: got %s and error %v, want no result", payload, err)
+	}
+
+	payload, err = client.Execute(ctx, OperationQuery, "failed", `query failed { sessions { id } }`, "")
+	if err == nil {
+		err = decodeResult(payload, &struct{}{})
+	}
+	var errs GraphQLErrors
+	if !errors.As(err, &errs) || errs[0].Extensions["code"] != "INJECTED_FAULT" {
+		t.Errorf("failed: got error %v, want an injected fault", err)
+	}
+
+	// Other operations are unaffected.
+	payload, err = client.Execute(ctx, OperationQuery, "sessions", `query sessions { sessions { id } }`, "")
+	if err != nil || payload == nil {
+		t.Errorf("sessions: got %s and error %v", payload, err)
+	}
+}
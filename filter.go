@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Paths select values in a payload: dot-separated keys with an optional
+// leading "$.", "[n]" for array elements, and "*" or "[*]" for every key or
+// element, e.g. data.sessionUpdates.session.id or $.data.items[*].name.
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parsePath(s string) ([]pathSegment, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if s == "" {
+		return nil, nil
+	}
+	var segments []pathSegment
+	for _, part := range strings.Split(s, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key == "*" {
+			segments = append(segments, pathSegment{wildcard: true})
+		} else if key != "" {
+			segments = append(segments, pathSegment{key: key})
+		} else if rest == "" {
+			return nil, fmt.Errorf("empty segment in path %q", s)
+		}
+		for rest != "" {
+			index, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("unclosed [ in path %q", s)
+			}
+			if index == "*" {
+				segments = append(segments, pathSegment{wildcard: true})
+			} else {
+				n, err := strconv.Atoi(index)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index [%s] in path %q", index, s)
+				}
+				segments = append(segments, pathSegment{index: n, isIndex: true})
+			}
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("unexpected %q after ] in path %q", after, s)
+			}
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return segments, nil
+}
+
+// selectPath returns the values at path in v; missing keys select nothing.
+func selectPath(v interface{}, path []pathSegment) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	seg, rest := path[0], path[1:]
+	var out []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if seg.wildcard {
+			for _, k := range sortedKeys(v) {
+				out = append(out, selectPath(v[k], rest)...)
+			}
+		} else if field, ok := v[seg.key]; ok && !seg.isIndex {
+			out = selectPath(field, rest)
+		}
+	case []interface{}:
+		if seg.wildcard {
+			for _, elem := range v {
+				out = append(out, selectPath(elem, rest)...)
+			}
+		} else if seg.isIndex && seg.index < len(v) {
+			out = selectPath(v[seg.index], rest)
+		}
+	}
+	return out
+}
+
+func hasWildcard(path []pathSegment) bool {
+	for _, seg := range path {
+		if seg.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// filterExpr is a parsed -filter expression.
+type filterExpr interface {
+	match(v interface{}) bool
+}
+
+type orExpr []filterExpr
+
+func (e orExpr) match(v interface{}) bool {
+	for _, sub := range e {
+		if sub.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+type andExpr []filterExpr
+
+func (e andExpr) match(v interface{}) bool {
+	for _, sub := range e {
+		if !sub.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+type notExpr struct{ expr filterExpr }
+
+func (e notExpr) match(v interface{}) bool { return !e.expr.match(v) }
+
+// compareExpr compares the values at a path with a literal. With no
+// operator it matches if the path selects a value other than null or
+// false. It matches if any selected value does.
+type compareExpr struct {
+	path    []pathSegment
+	op      string
+	literal interface{}
+	re      *regexp.Regexp
+}
+
+func (e compareExpr) match(v interface{}) bool {
+	values := selectPath(v, e.path)
+	if e.op == "!=" {
+		return !compareExpr{path: e.path, op: "==", literal: e.literal}.match(v)
+	}
+	for _, value := range values {
+		if e.compare(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e compareExpr) compare(value interface{}) bool {
+	switch e.op {
+	case "":
+		return value != nil && value != false
+	case "==":
+		return reflect.DeepEqual(value, e.literal)
+	case "=~":
+		s, ok := value.(string)
+		return ok && e.re.MatchString(s)
+	}
+	var cmp int
+	switch a := value.(type) {
+	case float64:
+		b, ok := e.literal.(float64)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(a, b)
+	case string:
+		b, ok := e.literal.(string)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(a, b)
+	default:
+		return false
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareOrdered[T float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// parseFilter parses an expression such as
+//
+//	data.sessionUpdates.type == "CREATED" && data.sessionUpdates.session.name =~ "^load-"
+//
+// Comparisons are ==, !=, <, <=, >, >= and =~ (regular expression) against a
+// JSON literal; a path on its own tests that it is set. Combine them with
+// &&, || and !, and group them with parentheses.
+func parseFilter(src string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")"}
+
+func tokenizeFilter(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != c {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, src[i:end+1])
+			i = end + 1
+			continue
+		}
+		matched := false
+		for _, op := range filterOperators {
+			if strings.HasPrefix(src[i:], op) {
+				tokens = append(tokens, op)
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		end := i
+		for end < len(src) && !strings.ContainsRune(" \t\"'&|=!<>()", rune(src[end])) {
+			end++
+		}
+		if end == i {
+			return nil, fmt.Errorf("unexpected %q at %d", src[i], i)
+		}
+		tokens = append(tokens, src[i:end])
+		i = end
+	}
+	return tokens, nil
+}
+
+func isFilterOperator(tok string) bool {
+	for _, op := range filterOperators {
+		if tok == op {
+			return true
+		}
+	}
+	return false
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) or() (filterExpr, error) {
+	var exprs orExpr
+	for {
+		expr, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.peek() != "||" {
+			break
+		}
+		p.next()
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+func (p *filterParser) and() (filterExpr, error) {
+	var exprs andExpr
+	for {
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.peek() != "&&" {
+			break
+		}
+		p.next()
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+func (p *filterParser) unary() (filterExpr, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case "(":
+		p.next()
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	// Anything else must be a path, not an operator or a value.
+	if tok := p.peek(); isFilterOperator(tok) || strings.HasPrefix(tok, `"`) || strings.HasPrefix(tok, "'") {
+		return nil, fmt.Errorf("unexpected %q, expected a path", tok)
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterExpr, error) {
+	tok := p.next()
+	path, err := parsePath(tok)
+	if err != nil {
+		return nil, err
+	}
+	expr := compareExpr{path: path}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~":
+		p.next()
+		expr.op = op
+		lit := p.next()
+		if lit == "" {
+			return nil, fmt.Errorf("missing value after %s", op)
+		}
+		if strings.HasPrefix(lit, "'") {
+			lit = strconv.Quote(strings.Trim(lit, "'"))
+		}
+		if err := json.Unmarshal([]byte(lit), &expr.literal); err != nil {
+			return nil, fmt.Errorf("invalid value %s: expected a JSON string, number, true, false or null", lit)
+		}
+		if op == "=~" {
+			s, ok := expr.literal.(string)
+			if !ok {
+				return nil, fmt.Errorf("=~ needs a string pattern")
+			}
+			if expr.re, err = regexp.Compile(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return expr, nil
+}
+
+// projection is one field of a -project list.
+type projection struct {
+	name string
+	path []pathSegment
+}
+
+// parseProjection parses comma-separated [name=]path fields. A field without
+// a name is named after the last key of its path.
+func parseProjection(spec string) ([]projection, error) {
+	var fields []projection
+	for _, item := range splitList(spec) {
+		name, src, ok := strings.Cut(item, "=")
+		if !ok {
+			src = item
+		}
+		path, err := parsePath(src)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			name = src
+			for i := len(path) - 1; i >= 0; i-- {
+				if path[i].key != "" {
+					name = path[i].key
+					break
+				}
+			}
+		}
+		fields = append(fields, projection{name: strings.TrimSpace(name), path: path})
+	}
+	return fields, nil
+}
+
+// flatten turns nested objects and arrays into one object with dotted keys,
+// e.g. {"a":{"b":[1]}} into {"a.b.0":1}.
+func flatten(v interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		join := func(key string) string {
+			if prefix == "" {
+				return key
+			}
+			return prefix + "." + key
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, field := range v {
+				walk(join(k), field)
+			}
+		case []interface{}:
+			for i, elem := range v {
+				walk(join(strconv.Itoa(i)), elem)
+			}
+		default:
+			out[prefix] = v
+		}
+	}
+	walk("", v)
+	return out
+}
+
+// EventView filters subscription events and reshapes the ones it keeps.
+// The zero value passes every event through unchanged.
+type EventView struct {
+	Filter  filterExpr
+	Fields  []projection
+	Flatten bool
+}
+
+// newEventView parses the -filter and -project flags.
+func newEventView(filter, project string, flat bool) (*EventView, error) {
+	view := &EventView{Flatten: flat}
+	if filter != "" {
+		expr, err := parseFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		view.Filter = expr
+	}
+	if project != "" {
+		fields, err := parseProjection(project)
+		if err != nil {
+			return nil, fmt.Errorf("invalid projection: %w", err)
+		}
+		view.Fields = fields
+	}
+	return view, nil
+}
+
+// Apply returns the payload to deliver, or false if the event is filtered
+// out. Payloads that aren't JSON objects are passed through.
+func (view *EventView) Apply(payload json.RawMessage) (json.RawMessage, bool) {
+	if view == nil || (view.Filter == nil && view.Fields == nil && !view.Flatten) {
+		return payload, true
+	}
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return payload, true
+	}
+	if view.Filter != nil && !view.Filter.match(v) {
+		return nil, false
+	}
+	if view.Fields != nil {
+		projected := make(map[string]interface{}, len(view.Fields))
+		for _, field := range view.Fields {
+			values := selectPath(v, field.path)
+			switch {
+			case hasWildcard(field.path):
+				projected[field.name] = values
+			case len(values) == 1:
+				projected[field.name] = values[0]
+			default:
+				projected[field.name] = nil
+			}
+		}
+		v = projected
+	}
+	if view.Flatten {
+		v = flatten(v)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return payload, true
+	}
+	return out, true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []pathSegment
+		err  string
+	}{
+		{src: "", want: nil},
+		{src: "$", want: nil},
+		{src: "data.sessionUpdates.type", want: []pathSegment{{key: "data"}, {key: "sessionUpdates"}, {key: "type"}}},
+		{src: "$.data.items[2].name", want: []pathSegment{{key: "data"}, {key: "items"}, {index: 2, isIndex: true}, {key: "name"}}},
+		{src: "items[*]", want: []pathSegment{{key: "items"}, {wildcard: true}}},
+		{src: "data.*.id", want: []pathSegment{{key: "data"}, {wildcard: true}, {key: "id"}}},
+		{src: "matrix[0][1]", want: []pathSegment{{key: "matrix"}, {index: 0, isIndex: true}, {index: 1, isIndex: true}}},
+		{src: "a..b", err: "empty segment"},
+		{src: "items[0", err: "unclosed ["},
+		{src: "items[-1]", err: "invalid index"},
+		{src: "items[x]", err: "invalid index"},
+		{src: "items[0]x", err: "unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := parsePath(tt.src)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	const event = `{"data": {"sessionUpdates": {"type": "CREATED", "sequence": 7, "session": {"id": "s1", "name": "load-3", "tags": ["a", "b"]}, "closed": false}}}`
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`data.sessionUpdates.type == "CREATED"`, true},
+		{`data.sessionUpdates.type == 'CREATED'`, true},
+		{`data.sessionUpdates.type != "CREATED"`, false},
+		{`data.sessionUpdates.sequence > 5`, true},
+		{`data.sessionUpdates.sequence <= 6`, false},
+		{`data.sessionUpdates.session.name =~ "^load-"`, true},
+		{`data.sessionUpdates.session.tags[*] == "b"`, true},
+		{`data.sessionUpdates.session.tags[5] == "b"`, false},
+		{`data.sessionUpdates.session.id`, true},
+		{`data.sessionUpdates.closed`, false},
+		{`data.sessionUpdates.missing`, false},
+		{`!data.sessionUpdates.missing`, true},
+		// && binds tighter than ||.
+		{`data.sessionUpdates.closed && data.sessionUpdates.closed || data.sessionUpdates.session.id`, true},
+		{`data.sessionUpdates.session.id || data.sessionUpdates.closed && data.sessionUpdates.closed`, true},
+		{`(data.sessionUpdates.session.id || data.sessionUpdates.closed) && data.sessionUpdates.closed`, false},
+		// ! applies to the operand that follows it only.
+		{`!data.sessionUpdates.closed && data.sessionUpdates.missing`, false},
+		{`!(data.sessionUpdates.closed && data.sessionUpdates.missing)`, true},
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(event), &v); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := parseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.match(v); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{``, "unexpected end of filter"},
+		{`a ==`, "missing value after =="},
+		{`a == b`, "invalid value"},
+		{`a =~ 1`, "needs a string pattern"},
+		{`a =~ "("`, "missing closing )"},
+		{`(a`, "missing )"},
+		{`a)`, `unexpected ")"`},
+		{`a "x"`, `unexpected "\"x\""`},
+		{`"x"`, "expected a path"},
+		{`&& a`, `unexpected "&&", expected a path`},
+		{`a && || b`, `unexpected "||", expected a path`},
+		{`)`, `unexpected ")", expected a path`},
+		{`a && == 1`, `unexpected "==", expected a path`},
+		{`a == "x`, "unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := parseFilter(tt.filter)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultOperationsDir = "operations"
+
+// runGenerate reads .graphql operation files and writes Go types for their
+// variables and responses, plus typed functions that run them on any
+// Executor or Subscriber.
+func runGenerate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result")
+	out := fs.String("o", "operations_gen.go", "output Go file")
+	pkg := fs.String("package", "main", "package name of the generated file")
+	prefix := fs.String("prefix", "GQL", "prefix of every generated type and function, so they don't clash with the package's own")
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{defaultOperationsDir}
+	}
+	files, err := operationFiles(paths)
+	if err != nil {
+		log.Fatalf("Error finding operation files: %v", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("No .graphql files found in %s", strings.Join(paths, ", "))
+	}
+
+	schema, err := loadSchema(*schemaPath)
+	if err != nil {
+		log.Fatalf("Error loading schema (run introspect first): %v", err)
+	}
+
+	g := newGenerator(schema, *pkg, *prefix)
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		doc, err := parseDocument(string(src))
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if errs := validateDocument(schema, doc); len(errs) > 0 {
+			log.Fatalf("%s:\n%s", path, indent(errs.Error()))
+		}
+		if err := g.addDocument(doc); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	code, err := g.generate()
+	if err != nil {
+		log.Fatalf("Error generating code: %v", err)
+	}
+	if clashes, err := clashingNames(code, *out); err != nil {
+		log.Fatalf("Error checking generated names: %v", err)
+	} else if len(clashes) > 0 {
+		log.Fatalf("Generated names already declared in %s: %s (pick another -prefix)", filepath.Dir(*out), strings.Join(clashes, ", "))
+	}
+	if err := os.WriteFile(*out, code, 0o644); err != nil {
+		log.Fatalf("Error writing %s: %v", *out, err)
+	}
+	fmt.Printf("Generated %d operations from %d files into %s\n", len(g.ops), len(files), *out)
+}
+
+// operationFiles expands directories to the .graphql files they contain.
+func operationFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.graphql"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+type generatedOp struct {
+	def       *OperationDef
+	goName    string
+	document  string
+	fragments map[string]*FragmentDef
+}
+
+type generator struct {
+	schema *Schema
+	pkg    string
+	prefix string
+	ops    []*generatedOp
+	names  map[string]bool
+
+	types     bytes.Buffer
+	inputs    map[string]bool
+	inputList []string
+	usesJSON  bool
+}
+
+func newGenerator(schema *Schema, pkg, prefix string) *generator {
+	return &generator{
+		schema: schema,
+		pkg:    pkg,
+		prefix: prefix,
+		names:  make(map[string]bool),
+		inputs: make(map[string]bool),
+	}
+}
+
+func (g *generator) addDocument(doc *Document) error {
+	fragments := make(map[string]*FragmentDef)
+	for _, frag := range doc.Fragments {
+		fragments[frag.Name] = frag
+	}
+	for _, op := range doc.Operations {
+		if op.Name == "" {
+			return fmt.Errorf("%s: operations must be named to generate code for them", op.Loc)
+		}
+		goName := g.prefix + exportedName(op.Name)
+		if g.names[goName] {
+			return fmt.Errorf("%s: operation %q is defined more than once", op.Loc, op.Name)
+		}
+		g.names[goName] = true
+
+		// The document sent for the operation includes the fragments it uses.
+		used := make(map[string]bool)
+		collectSpreads(op.SelectionSet, fragments, used)
+		names := make([]string, 0, len(used))
+		for name := range used {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		document := op.Source
+		for _, name := range names {
+			document += "\n" + fragments[name].Source
+		}
+		g.ops = append(g.ops, &generatedOp{def: op, goName: goName, document: document, fragments: fragments})
+	}
+	return nil
+}
+
+func collectSpreads(sels []Selection, fragments map[string]*FragmentDef, used map[string]bool) {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *FieldNode:
+			collectSpreads(sel.SelectionSet, fragments, used)
+		case *InlineFragment:
+			collectSpreads(sel.SelectionSet, fragments, used)
+		case *FragmentSpread:
+			if !used[sel.Name] {
+				used[sel.Name] = true
+				collectSpreads(fragments[sel.Name].SelectionSet, fragments, used)
+			}
+		}
+	}
+}
+
+func (g *generator) generate() ([]byte, error) {
+	var body bytes.Buffer
+	for _, op := range g.ops {
+		g.operation(&body, op)
+	}
+	for i := 0; i < len(g.inputList); i++ {
+		g.inputType(g.inputList[i])
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"go run . generate\"; DO NOT EDIT.\n\npackage %s\n\n", g.pkg)
+	if g.usesJSON {
+		buf.WriteString("import (\n\t\"context\"\n\t\"encoding/json\"\n)\n\n")
+	} else {
+		buf.WriteString("import \"context\"\n\n")
+	}
+	buf.Write(body.Bytes())
+	buf.Write(g.types.Bytes())
+
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return buf.Bytes(), fmt.Errorf("formatting generated code: %w", err)
+	}
+	return code, nil
+}
+
+func (g *generator) operation(w *bytes.Buffer, op *generatedOp) {
+	def := op.def
+	docName := unexportedName(op.goName) + "Document"
+	if g.prefix != "" {
+		docName = strings.ToLower(g.prefix) + strings.TrimPrefix(op.goName, g.prefix) + "Document"
+	}
+	fmt.Fprintf(w, "const %s = %s\n\n", docName, goStringLiteral(op.document))
+
+	varsType := op.goName + "Variables"
+	if len(def.VarDefs) > 0 {
+		fmt.Fprintf(w, "type %s struct {\n", varsType)
+		for _, v := range def.VarDefs {
+			ref := typeNodeRef(v.Type)
+			tag := v.Name
+			if ref.Kind != "NON_NULL" {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(w, "\t%s %s `json:%q`\n", exportedName(v.Name), g.inputGoType(ref, false), tag)
+		}
+		w.WriteString("}\n\n")
+	}
+
+	respType := op.goName + "Response"
+	root := g.schema.RootType(def.Type)
+	g.objectType(respType, root, def.SelectionSet, op.fragments)
+
+	params := "ctx context.Context, client Executor"
+	if def.Type == OperationSubscription {
+		params = "ctx context.Context, client Subscriber"
+	}
+	variables := `""`
+	marshal := ""
+	if len(def.VarDefs) > 0 {
+		params += ", vars " + varsType
+		variables = "string(variables)"
+		g.usesJSON = true
+		marshal = "\tvariables, err := json.Marshal(vars)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n"
+	}
+
+	if def.Type == OperationSubscription {
+		fmt.Fprintf(w, "// %s starts the %s subscription. Events are decoded into %s.\n", op.goName, def.Name, respType)
+		fmt.Fprintf(w, "func %s(%s) (<-chan Event[%s], *Subscription, error) {\n", op.goName, params, respType)
+		w.WriteString(strings.ReplaceAll(marshal, "return nil, err", "return nil, nil, err"))
+		fmt.Fprintf(w, "\tsub, err := client.Subscribe(ctx, %q, %s, %s)\n", def.Name, docName, variables)
+		w.WriteString("\tif err != nil {\n\t\treturn nil, nil, err\n\t}\n")
+		fmt.Fprintf(w, "\treturn decodeEvents[%s](sub), sub, nil\n}\n\n", respType)
+		return
+	}
+
+	fmt.Fprintf(w, "// %s executes the %s %s.\n", op.goName, def.Name, def.Type)
+	fmt.Fprintf(w, "func %s(%s) (*%s, error) {\n", op.goName, params, respType)
+	w.WriteString(marshal)
+	fmt.Fprintf(w, "\tpayload, err := client.Execute(ctx, %s, %q, %s, %s)\n", operationConst(def.Type), def.Name, docName, variables)
+	w.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(w, "\tvar resp %s\n\treturn &resp, decodeResult(payload, &resp)\n}\n\n", respType)
+}
+
+func operationConst(t OperationType) string {
+	switch t {
+	case OperationMutation:
+		return "OperationMutation"
+	case OperationSubscription:
+		return "OperationSubscription"
+	}
+	return "OperationQuery"
+}
+
+// selectedField is a response field after fragments have been flattened into
+// their parent selection set.
+type selectedField struct {
+	key      string
+	def      *FieldDef
+	sels     []Selection
+	optional bool
+}
+
+func (g *generator) flatten(parent *FullType, sels []Selection, fragments map[string]*FragmentDef, optional bool, fields *[]*selectedField, byKey map[string]*selectedField) {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *FieldNode:
+			key := sel.ResponseKey()
+			if f, ok := byKey[key]; ok {
+				f.sels = append(f.sels, sel.SelectionSet...)
+				f.optional = f.optional && optional
+				continue
+			}
+			var def *FieldDef
+			if sel.Name == "__typename" {
+				def = &FieldDef{Name: "__typename", Type: &TypeRef{Kind: "NON_NULL", OfType: &TypeRef{Kind: "SCALAR", Name: "String"}}}
+			} else {
+				def = parent.Field(sel.Name)
+			}
+			if def == nil {
+				continue
+			}
+			f := &selectedField{key: key, def: def, sels: sel.SelectionSet, optional: optional}
+			byKey[key] = f
+			*fields = append(*fields, f)
+		case *InlineFragment:
+			t := parent
+			if sel.TypeCondition != "" {
+				t = g.schema.Type(sel.TypeCondition)
+			}
+			g.flatten(t, sel.SelectionSet, fragments, optional || t.Name != parent.Name, fields, byKey)
+		case *FragmentSpread:
+			frag := fragments[sel.Name]
+			t := g.schema.Type(frag.TypeCondition)
+			g.flatten(t, frag.SelectionSet, fragments, optional || t.Name != parent.Name, fields, byKey)
+		}
+	}
+}
+
+// objectType writes a struct for a selection set on parent, recursing into
+// nested selections with names derived from the response path.
+func (g *generator) objectType(name string, parent *FullType, sels []Selection, fragments map[string]*FragmentDef) {
+	var fields []*selectedField
+	g.flatten(parent, sels, fragments, false, &fields, make(map[string]*selectedField))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "type %s struct {\n", name)
+	for _, f := range fields {
+		ref := f.def.Type
+		if f.optional && ref.Kind == "NON_NULL" {
+			ref = ref.OfType
+		}
+		goType := g.outputGoType(ref, name+exportedName(f.key), f.sels, fragments, false)
+		tag := f.key
+		if f.optional {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&buf, "\t%s %s `json:%q`\n", exportedName(f.key), goType, tag)
+	}
+	buf.WriteString("}\n\n")
+	g.types.Write(buf.Bytes())
+}
+
+func (g *generator) outputGoType(ref *TypeRef, nestedName string, sels []Selection, fragments map[string]*FragmentDef, nonNull bool) string {
+	switch ref.Kind {
+	case "NON_NULL":
+		return g.outputGoType(ref.OfType, nestedName, sels, fragments, true)
+	case "LIST":
+		return "[]" + g.outputGoType(ref.OfType, nestedName, sels, fragments, false)
+	}
+	t := g.schema.Type(ref.Name)
+	if t != nil && t.IsComposite() {
+		g.objectType(nestedName, t, sels, fragments)
+		return pointerUnless(nonNull, nestedName)
+	}
+	return g.leafGoType(ref.Name, nonNull)
+}
+
+func (g *generator) inputGoType(ref *TypeRef, nonNull bool) string {
+	switch ref.Kind {
+	case "NON_NULL":
+		return g.inputGoType(ref.OfType, true)
+	case "LIST":
+		return "[]" + g.inputGoType(ref.OfType, false)
+	}
+	if t := g.schema.Type(ref.Name); t != nil && t.Kind == "INPUT_OBJECT" {
+		g.requireInput(t.Name)
+		return pointerUnless(nonNull, g.prefix+t.Name)
+	}
+	return g.leafGoType(ref.Name, nonNull)
+}
+
+func (g *generator) leafGoType(name string, nonNull bool) string {
+	switch name {
+	case "ID", "String":
+		return pointerUnless(nonNull, "string")
+	case "Int":
+		return pointerUnless(nonNull, "int")
+	case "Float":
+		return pointerUnless(nonNull, "float64")
+	case "Boolean":
+		return pointerUnless(nonNull, "bool")
+	}
+	if t := g.schema.Type(name); t != nil && t.Kind == "ENUM" {
+		g.requireInput(name)
+		return pointerUnless(nonNull, g.prefix+name)
+	}
+	// Custom scalars are passed through undecoded.
+	g.usesJSON = true
+	return "json.RawMessage"
+}
+
+// requireInput queues a schema input object or enum type for generation.
+func (g *generator) requireInput(name string) {
+	if !g.inputs[name] {
+		g.inputs[name] = true
+		g.inputList = append(g.inputList, name)
+	}
+}
+
+func (g *generator) inputType(name string) {
+	t := g.schema.Type(name)
+	goName := g.prefix + name
+	var buf bytes.Buffer
+	switch t.Kind {
+	case "ENUM":
+		fmt.Fprintf(&buf, "type %s string\n\nconst (\n", goName)
+		for _, v := range t.EnumValues {
+			fmt.Fprintf(&buf, "\t%s%s %s = %q\n", goName, enumConstName(v.Name), goName, v.Name)
+		}
+		buf.WriteString(")\n\n")
+	case "INPUT_OBJECT":
+		fmt.Fprintf(&buf, "type %s struct {\n", goName)
+		for _, f := range t.InputFields {
+			tag := f.Name
+			if f.Type.Kind != "NON_NULL" {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&buf, "\t%s %s `json:%q`\n", exportedName(f.Name), g.inputGoType(f.Type, false), tag)
+		}
+		buf.WriteString("}\n\n")
+	}
+	g.types.Write(buf.Bytes())
+}
+
+// clashingNames returns the top-level names declared by code that other Go
+// files of the same package, in the directory out is written to, declare too.
+func clashingNames(code []byte, out string) ([]string, error) {
+	fset := gotoken.NewFileSet()
+	gen, err := goparser.ParseFile(fset, out, code, goparser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	generated := make(map[string]bool)
+	for _, decl := range topLevelNames(gen) {
+		generated[decl] = true
+	}
+
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(out), "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var clashes []string
+	for _, path := range paths {
+		if same, _ := sameFile(path, out); same {
+			continue
+		}
+		f, err := goparser.ParseFile(fset, path, nil, goparser.SkipObjectResolution)
+		if err != nil || f.Name.Name != gen.Name.Name {
+			continue
+		}
+		for _, name := range topLevelNames(f) {
+			if generated[name] {
+				clashes = append(clashes, name)
+			}
+		}
+	}
+	sort.Strings(clashes)
+	return clashes, nil
+}
+
+// topLevelNames lists the functions, types, constants and variables a file
+// declares, leaving out methods.
+func topLevelNames(f *ast.File) []string {
+	var names []string
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, spec.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						names = append(names, n.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+func sameFile(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(ia, ib), nil
+}
+
+func pointerUnless(nonNull bool, goType string) string {
+	if nonNull {
+		return goType
+	}
+	return "*" + goType
+}
+
+var initialisms = map[string]string{"id": "ID", "url": "URL", "uri": "URI", "api": "API", "http": "HTTP", "json": "JSON"}
+
+// exportedName converts a GraphQL name such as `sessionId` or `__typename`
+// into an exported Go identifier (`SessionID`, `Typename`).
+func exportedName(name string) string {
+	name = strings.TrimLeft(name, "_")
+	var words []string
+	start := 0
+	for i := 1; i <= len(name); i++ {
+		if i == len(name) || name[i] == '_' || (name[i] >= 'A' && name[i] <= 'Z' && name[i-1] >= 'a' && name[i-1] <= 'z') {
+			if start < i {
+				words = append(words, name[start:i])
+			}
+			if i < len(name) && name[i] == '_' {
+				start = i + 1
+			} else {
+				start = i
+			}
+		}
+	}
+	var sb strings.Builder
+	for _, w := range words {
+		if up, ok := initialisms[strings.ToLower(w)]; ok {
+			sb.WriteString(up)
+			continue
+		}
+		sb.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return sb.String()
+}
+
+func unexportedName(goName string) string {
+	if goName == "" {
+		return goName
+	}
+	return strings.ToLower(goName[:1]) + goName[1:]
+}
+
+// enumConstName turns an enum value such as IN_PROGRESS into InProgress.
+func enumConstName(value string) string {
+	return exportedName(strings.ToLower(value))
+}
+
+func goStringLiteral(s string) string {
+	if strings.Contains(s, "`") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportedName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"sessionId", "SessionID"},
+		{"__typename", "Typename"},
+		{"created_at", "CreatedAt"},
+		{"apiUrl", "APIURL"},
+		{"IN_PROGRESS", "INPROGRESS"},
+		{"x", "X"},
+	}
+	for _, tt := range tests {
+		if got := exportedName(tt.in); got != tt.want {
+			t.Errorf("exportedName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGeneratePrefix(t *testing.T) {
+	doc, err := parseDocument(`subscription updates($id: ID) { sessionUpdates(id: $id) { type session { id } } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{
+			prefix: "GQL",
+			want: []string{
+				"const gqlUpdatesDocument =",
+				"func GQLUpdates(ctx context.Context, client Subscriber, vars GQLUpdatesVariables) (<-chan Event[GQLUpdatesResponse], *Subscription, error)",
+				"type GQLUpdatesResponseSessionUpdates struct",
+				"Type    GQLSessionUpdateType",
+				"GQLSessionUpdateTypeCreated GQLSessionUpdateType = \"CREATED\"",
+			},
+		},
+		{
+			prefix: "",
+			want: []string{
+				"const updatesDocument =",
+				"func Updates(ctx context.Context, client Subscriber, vars UpdatesVariables)",
+				"SessionUpdateTypeDeleted SessionUpdateType = \"DELETED\"",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			g := newGenerator(mockSchema(t), "main", tt.prefix)
+			if err := g.addDocument(doc); err != nil {
+				t.Fatal(err)
+			}
+			code, err := g.generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(code), want) {
+					t.Errorf("generated code lacks %q:\n%s", want, code)
+				}
+			}
+		})
+	}
+}
+
+func TestClashingNames(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":        "package main\n\ntype Session struct{}\n\nfunc (Session) Event() {}\n\nconst one, Two = 1, 2\n",
+		"b.go":        "package main\n\nfunc Location() {}\n",
+		"other.go":    "package other\n\ntype Event struct{}\n",
+		"ops_gen.go":  "package main\n\ntype Stale struct{}\n",
+		"ignored.txt": "type Event struct{}",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code := []byte("package main\n\ntype Session struct{}\n\ntype Event struct{}\n\ntype Stale struct{}\n\nvar Two = 2\n\nfunc Location() {}\n")
+	clashes, err := clashingNames(code, filepath.Join(dir, "ops_gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(clashes, ","), "Location,Session,Two"; got != want {
+		t.Errorf("got clashes %s, want %s", got, want)
+	}
+}
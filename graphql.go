@@ -3,10 +3,6 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
-	"log"
 	"time"
 )
 
@@ -19,24 +15,19 @@ type GraphQLMessage struct {
 type OperationType string
 
 const (
+	OperationQuery        OperationType = "query"
 	OperationSubscription OperationType = "subscription"
 	OperationMutation     OperationType = "mutation"
 )
 
-func send(conn *websocket.Conn, opType OperationType, prefix, query string, variables string) {
-	msg := GraphQLMessage{
-		ID:      uuid.New().String(),
-		Type:    "subscribe",
-		Payload: marshalPayload(query, variables),
+func marshalPayload(query, variables string, extensions map[string]interface{}) json.RawMessage {
+	payload := map[string]interface{}{}
+	if query != "" {
+		payload["query"] = query
 	}
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Fatalf("Error sending %s: %v", opType, err)
+	if extensions != nil {
+		payload["extensions"] = extensions
 	}
-	fmt.Printf("Sent [%s] %s, query: %s, vars: %s \n", prefix, opType, query, variables)
-}
-
-func marshalPayload(query, variables string) json.RawMessage {
-	payload := map[string]interface{}{"query": query}
 	if variables != "" {
 		var vars map[string]interface{}
 		if err := json.Unmarshal([]byte(variables), &vars); err == nil {
@@ -47,12 +38,10 @@ func marshalPayload(query, variables string) json.RawMessage {
 	return b
 }
 
-func readResponse(conn *websocket.Conn) ([]byte, error) {
-	_, message, err := conn.ReadMessage()
-	return message, err
-}
-
-func pingRoutine(ctx context.Context, conn *websocket.Conn) {
+func pingRoutine(ctx context.Context, client *Client) {
+	if !client.proto.SupportsPing() {
+		return
+	}
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -62,11 +51,11 @@ func pingRoutine(ctx context.Context, conn *websocket.Conn) {
 			return
 		case <-ticker.C:
 			pingMsg := GraphQLMessage{Type: "ping"}
-			if err := conn.WriteJSON(pingMsg); err != nil {
-				log.Println("Failed to send ping:", err)
+			if err := client.write(pingMsg); err != nil {
+				client.log.Warn("Failed to send ping", "err", err)
 				return
 			}
-			fmt.Println("Ping sent")
+			client.log.Debug("Ping sent")
 		}
 	}
 }
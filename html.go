@@ -0,0 +1,428 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chartSeries is one named line or stack of bars. NaN values are gaps.
+type chartSeries struct {
+	Name   string
+	Values []float64
+}
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// Chart geometry: the plot area and the margins around it for axes and the
+// legend.
+const (
+	chartWidth  = 900
+	chartHeight = 240
+	chartLeft   = 70
+	chartRight  = 170
+	chartTop    = 10
+	chartBottom = 30
+)
+
+// svgChart draws the axes, grid and legend shared by line and bar charts,
+// calling plot to draw the data in the plot area.
+func svgChart(xLabels func(i int) string, n int, yMax float64, yFormat func(float64) string, series []chartSeries, plot func(b *strings.Builder, x func(float64) float64, y func(float64) float64)) template.HTML {
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	yMax = niceCeil(yMax)
+	x := func(i float64) float64 { return chartLeft + i*plotW/math.Max(float64(n), 1) }
+	y := func(v float64) float64 { return chartTop + plotH - v/yMax*plotH }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" width="%d" height="%d" font-size="11">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	for i := 0; i <= 4; i++ {
+		v := yMax * float64(i) / 4
+		fmt.Fprintf(&b, `<line x1="%d" x2="%.1f" y1="%.1f" y2="%.1f" stroke="#e5e5e5"/>`, chartLeft, chartLeft+plotW, y(v), y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, chartLeft-6, y(v), html.EscapeString(yFormat(v)))
+	}
+	ticks := min(n, 8)
+	for t := 0; t < ticks; t++ {
+		i := t * n / ticks
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, x(float64(i)+0.5), chartHeight-10, html.EscapeString(xLabels(i)))
+	}
+	plot(&b, x, y)
+	fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%d" y2="%.1f" stroke="#999"/>`, chartLeft, chartLeft, chartTop, chartTop+plotH)
+	fmt.Fprintf(&b, `<line x1="%d" x2="%.1f" y1="%.1f" y2="%.1f" stroke="#999"/>`, chartLeft, chartLeft+plotW, chartTop+plotH, chartTop+plotH)
+	for i, s := range series {
+		ly := chartTop + 6 + i*16
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="10" height="10" fill="%s"/>`, chartLeft+plotW+12, ly-5, chartColors[i%len(chartColors)])
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" dominant-baseline="middle">%s</text>`, chartLeft+plotW+26, ly, html.EscapeString(s.Name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// lineChart draws one line per series over the seconds of a run, each value
+// covering step seconds.
+func lineChart(series []chartSeries, step int, yFormat func(float64) string) template.HTML {
+	n, yMax := 0, 0.0
+	for _, s := range series {
+		n = max(n, len(s.Values))
+		for _, v := range s.Values {
+			if !math.IsNaN(v) {
+				yMax = max(yMax, v)
+			}
+		}
+	}
+	xLabel := func(i int) string { return formatSecond(i * step) }
+	return svgChart(xLabel, n, yMax, yFormat, series, func(b *strings.Builder, x, y func(float64) float64) {
+		for i, s := range series {
+			var points []string
+			flush := func() {
+				if len(points) > 0 {
+					fmt.Fprintf(b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, chartColors[i%len(chartColors)], strings.Join(points, " "))
+					points = nil
+				}
+			}
+			for j, v := range s.Values {
+				if math.IsNaN(v) {
+					flush()
+					continue
+				}
+				points = append(points, fmt.Sprintf("%.1f,%.1f", x(float64(j)+0.5), y(v)))
+			}
+			flush()
+		}
+	})
+}
+
+// barChart draws stacked bars, one per label.
+func barChart(labels []string, series []chartSeries, yFormat func(float64) string) template.HTML {
+	yMax := 0.0
+	for i := range labels {
+		total := 0.0
+		for _, s := range series {
+			total += s.Values[i]
+		}
+		yMax = max(yMax, total)
+	}
+	return svgChart(func(i int) string { return labels[i] }, len(labels), yMax, yFormat, series, func(b *strings.Builder, x, y func(float64) float64) {
+		width := x(1) - x(0)
+		for i := range labels {
+			base := 0.0
+			for j, s := range series {
+				v := s.Values[i]
+				if v == 0 {
+					continue
+				}
+				fmt.Fprintf(b, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"><title>%s %s: %s</title></rect>`,
+					x(float64(i)), y(base+v), math.Max(width-1, 0.5), y(base)-y(base+v), chartColors[j%len(chartColors)],
+					html.EscapeString(labels[i]), html.EscapeString(s.Name), html.EscapeString(yFormat(v)))
+				base += v
+			}
+		}
+	})
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+func formatSecond(i int) string {
+	return (time.Duration(i) * time.Second).String()
+}
+
+func formatCount(v float64) string {
+	return fmt.Sprintf("%g", math.Round(v*100)/100)
+}
+
+func formatMs(v float64) string {
+	return formatLatency(msDuration(v))
+}
+
+// histogramBins regroups latency buckets into at most n bins of equal width
+// on a log scale, labelled by their upper bounds.
+func histogramBins(buckets []HistogramBucket, n int) ([]string, []float64) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+	lo, hi := math.Log(buckets[0].UpperMs), math.Log(buckets[len(buckets)-1].UpperMs)
+	bins := min(n, len(buckets))
+	width := (hi - lo) / float64(bins)
+	labels := make([]string, bins)
+	counts := make([]float64, bins)
+	for i := range labels {
+		labels[i] = formatMs(math.Exp(lo + width*float64(i+1)))
+	}
+	for _, b := range buckets {
+		i := bins - 1
+		if width > 0 {
+			i = min(int((math.Log(b.UpperMs)-lo)/width), bins-1)
+		}
+		counts[i] += float64(b.Count)
+	}
+	return labels, counts
+}
+
+// downsampleTimeline merges runs of seconds so that at most n points are
+// left, one per pixel of a chart, and returns them with the seconds each
+// covers. Counts are summed and the open connections are those at the end
+// of each run. Percentiles are averaged, weighted by count, which only
+// approximates the percentiles of the merged seconds.
+func downsampleTimeline(points []TimelinePoint, n int) ([]TimelinePoint, int) {
+	step := (len(points) + n - 1) / max(n, 1)
+	if step <= 1 {
+		return points, 1
+	}
+	merged := make([]TimelinePoint, 0, (len(points)+step-1)/step)
+	for start := 0; start < len(points); start += step {
+		m := TimelinePoint{Second: points[start].Second}
+		for _, p := range points[start:min(start+step, len(points))] {
+			m.ConnsOpen = p.ConnsOpen
+			m.Opened += p.Opened
+			m.Dropped += p.Dropped
+			for name, op := range p.Operations {
+				if m.Operations == nil {
+					m.Operations = make(map[string]TimelineOperation)
+				}
+				sum := m.Operations[name]
+				if total := sum.Count + op.Count; total > 0 {
+					weighted := func(a, b float64) float64 {
+						return (a*float64(sum.Count) + b*float64(op.Count)) / float64(total)
+					}
+					sum.P50Ms, sum.P95Ms, sum.P99Ms = weighted(sum.P50Ms, op.P50Ms), weighted(sum.P95Ms, op.P95Ms), weighted(sum.P99Ms, op.P99Ms)
+				}
+				sum.Count += op.Count
+				sum.Errors += op.Errors
+				sum.MaxMs = max(sum.MaxMs, op.MaxMs)
+				m.Operations[name] = sum
+			}
+			m.Errors = addCounts(m.Errors, p.Errors)
+			m.CloseCodes = addCounts(m.CloseCodes, p.CloseCodes)
+		}
+		merged = append(merged, m)
+	}
+	return merged, step
+}
+
+func addCounts(dst, src map[string]uint64) map[string]uint64 {
+	if len(src) > 0 && dst == nil {
+		dst = make(map[string]uint64, len(src))
+	}
+	for k, v := range src {
+		dst[k] += v
+	}
+	return dst
+}
+
+// htmlOperation is the charts of one operation.
+type htmlOperation struct {
+	OperationReport
+	Latency   template.HTML
+	Histogram template.HTML
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms":      formatMs,
+	"percent": func(v float64) string { return fmt.Sprintf("%.2f%%", 100*v) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Run report {{.Report.Started.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+h2 { margin-top: 2em; border-bottom: 2px solid #222; }
+.error { color: #d62728; }
+</style>
+</head>
+<body>
+<h1>Run report</h1>
+<p>Started {{.Report.Started.Format "2006-01-02 15:04:05 MST"}}, ran {{printf "%.1f" .Report.DurationSeconds}}s.
+{{.Report.Connections.Opened}} connections opened.
+{{if .Report.Dropped}}<span class="error">{{.Report.Dropped}} iterations dropped.</span>{{end}}</p>
+
+<h2>Steps</h2>
+<table>
+<tr><th>Operation</th><th>Count</th><th>Errors</th><th>Error rate</th><th>Throughput</th><th>Min</th><th>Mean</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>Max</th></tr>
+{{range .Report.Operations}}<tr><td>{{.Name}}</td><td>{{.Count}}</td><td{{if .Errors}} class="error"{{end}}>{{.Errors}}</td><td>{{percent .ErrorRate}}</td><td>{{printf "%.1f/s" .Throughput}}</td><td>{{ms .MinMs}}</td><td>{{ms .MeanMs}}</td><td>{{ms .P50Ms}}</td><td>{{ms .P90Ms}}</td><td>{{ms .P95Ms}}</td><td>{{ms .P99Ms}}</td><td>{{ms .MaxMs}}</td></tr>
+{{end}}</table>
+{{if .Report.Soak}}{{with .Report.Soak}}
+<p>Soak of {{.Target}} connections: peak {{.PeakOpen}} open, {{.DialFailures}} dial failures, {{.Lost}} lost, {{.SubscriptionErrors}} subscription errors, {{.Events}} events.
+Client peak {{.PeakGoroutines}} goroutines, {{printf "%.1f" .PeakHeapMB}} MB heap.</p>
+{{end}}{{end}}
+{{if .Report.Sequences}}
+<table>
+<tr><th>Subscription</th><th>Events</th><th>Gaps</th><th>Missing</th><th>Duplicates</th><th>Out of order</th><th>Unsequenced</th></tr>
+{{range .Report.Sequences}}<tr><td>{{.Subscription}}</td><td>{{.Events}}</td><td>{{.Gaps}}</td><td>{{.Missing}}</td><td>{{.Duplicates}}</td><td>{{.OutOfOrder}}</td><td>{{.Unsequenced}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Throughput}}<h2>Throughput</h2>
+{{.Throughput}}
+{{end}}
+
+{{range .Operations}}
+<h2>{{.Name}}</h2>
+{{if .Latency}}<h3>Latency over time</h3>
+{{.Latency}}{{end}}
+{{if .Histogram}}<h3>Latency distribution</h3>
+{{.Histogram}}{{end}}
+{{end}}
+
+<h2>Errors and close codes</h2>
+{{if .ErrorTimeline}}{{.ErrorTimeline}}{{else}}<p>No errors and no connections closed.</p>{{end}}
+<table>
+<tr><th>Error</th><th>Count</th></tr>
+{{range $kind, $n := .Report.Errors}}<tr><td>{{$kind}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Close code</th><th>Count</th></tr>
+{{range $code, $n := .Report.Connections.CloseCodes}}<tr><td>{{$code}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+
+{{if .Connections}}<h2>Connections</h2>
+{{.Connections}}
+{{end}}
+</body>
+</html>
+`))
+
+// writeReportHTML writes the report as a self-contained HTML page with SVG
+// charts, viewable offline.
+func writeReportHTML(path string, report *RunReport) error {
+	timeline, step := downsampleTimeline(report.Timeline, chartWidth-chartLeft-chartRight)
+	data := struct {
+		Report        *RunReport
+		Operations    []htmlOperation
+		Throughput    template.HTML
+		ErrorTimeline template.HTML
+		Connections   template.HTML
+	}{Report: report}
+
+	var throughput []chartSeries
+	for _, op := range report.Operations {
+		h := htmlOperation{OperationReport: op}
+		if len(timeline) > 0 {
+			series := []chartSeries{{Name: "p50"}, {Name: "p95"}, {Name: "p99"}}
+			rate := chartSeries{Name: op.Name}
+			for _, p := range timeline {
+				o, ok := p.Operations[op.Name]
+				for i, v := range []float64{o.P50Ms, o.P95Ms, o.P99Ms} {
+					if !ok {
+						v = math.NaN()
+					}
+					series[i].Values = append(series[i].Values, v)
+				}
+				rate.Values = append(rate.Values, float64(o.Count)/float64(step))
+			}
+			h.Latency = lineChart(series, step, formatMs)
+			throughput = append(throughput, rate)
+		}
+		if labels, counts := histogramBins(op.Histogram, 40); len(labels) > 0 {
+			h.Histogram = barChart(labels, []chartSeries{{Name: "count", Values: counts}}, formatCount)
+		}
+		data.Operations = append(data.Operations, h)
+	}
+	if len(throughput) > 0 {
+		data.Throughput = lineChart(throughput, step, func(v float64) string { return formatCount(v) + "/s" })
+	}
+
+	// Errors are stacked by kind, followed by connections closed by code.
+	var kinds []string
+	seen := make(map[string]bool)
+	for _, p := range timeline {
+		for kind := range p.Errors {
+			if !seen[kind] {
+				seen[kind] = true
+				kinds = append(kinds, kind)
+			}
+		}
+		for code := range p.CloseCodes {
+			if !seen["closed "+code] {
+				seen["closed "+code] = true
+				kinds = append(kinds, "closed "+code)
+			}
+		}
+	}
+	sort.Strings(kinds)
+	if len(kinds) > 0 {
+		labels := make([]string, len(timeline))
+		series := make([]chartSeries, len(kinds))
+		for i, kind := range kinds {
+			series[i].Name = kind
+			for _, p := range timeline {
+				v := p.Errors[kind]
+				if code, ok := strings.CutPrefix(kind, "closed "); ok {
+					v = p.CloseCodes[code]
+				}
+				series[i].Values = append(series[i].Values, float64(v))
+			}
+		}
+		for i, p := range timeline {
+			labels[i] = formatSecond(p.Second)
+		}
+		data.ErrorTimeline = barChart(labels, series, formatCount)
+	}
+
+	if len(timeline) > 0 {
+		open := chartSeries{Name: "open"}
+		for _, p := range timeline {
+			open.Values = append(open.Values, float64(p.ConnsOpen))
+		}
+		data.Connections = lineChart([]chartSeries{open}, step, formatCount)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := reportTemplate.Execute(f, data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// runReport renders a JSON run report as HTML.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	out := fs.String("o", "", "HTML file to write (default: the report's name with .html)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: report [-o run.html] run.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, err := loadReport(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error loading report: %v", err)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(fs.Arg(0), filepath.Ext(fs.Arg(0))) + ".html"
+	}
+	if err := writeReportHTML(*out, report); err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+	fmt.Printf("Wrote HTML report to %s\n", *out)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownsampleTimeline(t *testing.T) {
+	tests := []struct {
+		name      string
+		seconds   int
+		n         int
+		wantStep  int
+		wantLen   int
+		wantFirst int
+	}{
+		{name: "short run", seconds: 100, n: 660, wantStep: 1, wantLen: 100},
+		{name: "exactly the width", seconds: 660, n: 660, wantStep: 1, wantLen: 660},
+		{name: "just over", seconds: 661, n: 660, wantStep: 2, wantLen: 331},
+		{name: "an hour", seconds: 3600, n: 660, wantStep: 6, wantLen: 600},
+		{name: "empty", seconds: 0, n: 660, wantStep: 1, wantLen: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make([]TimelinePoint, tt.seconds)
+			for i := range points {
+				points[i] = TimelinePoint{Second: i, ConnsOpen: i, Opened: 1}
+			}
+			got, step := downsampleTimeline(points, tt.n)
+			if step != tt.wantStep || len(got) != tt.wantLen {
+				t.Fatalf("got %d points of %d seconds, want %d of %d", len(got), step, tt.wantLen, tt.wantStep)
+			}
+			var opened uint64
+			for i, p := range got {
+				if p.Second != i*step {
+					t.Errorf("point %d starts at second %d, want %d", i, p.Second, i*step)
+				}
+				if last := min((i+1)*step, tt.seconds) - 1; p.ConnsOpen != last {
+					t.Errorf("point %d has %d open connections, want %d from its last second", i, p.ConnsOpen, last)
+				}
+				opened += p.Opened
+			}
+			if opened != uint64(tt.seconds) {
+				t.Errorf("got %d connections opened, want %d", opened, tt.seconds)
+			}
+		})
+	}
+}
+
+func TestDownsampleTimelineMergesOperations(t *testing.T) {
+	points := []TimelinePoint{
+		{Second: 0, Operations: map[string]TimelineOperation{"op": {Count: 1, P50Ms: 10, P95Ms: 10, P99Ms: 10, MaxMs: 10}}, Errors: map[string]uint64{"timeout": 1}},
+		{Second: 1, Operations: map[string]TimelineOperation{"op": {Count: 3, Errors: 2, P50Ms: 2, P95Ms: 6, P99Ms: 30, MaxMs: 40}}, CloseCodes: map[string]uint64{"1006": 1}},
+		{Second: 2},
+		{Second: 3, Errors: map[string]uint64{"timeout": 2}},
+	}
+	got, step := downsampleTimeline(points, 2)
+	if step != 2 || len(got) != 2 {
+		t.Fatalf("got %d points of %d seconds, want 2 of 2", len(got), step)
+	}
+	want := TimelineOperation{Count: 4, Errors: 2, P50Ms: 4, P95Ms: 7, P99Ms: 25, MaxMs: 40}
+	if op := got[0].Operations["op"]; op != want {
+		t.Errorf("got %+v, want %+v", op, want)
+	}
+	if got[0].Errors["timeout"] != 1 || got[0].CloseCodes["1006"] != 1 || got[1].Errors["timeout"] != 2 {
+		t.Errorf("got errors %v and %v, close codes %v", got[0].Errors, got[1].Errors, got[0].CloseCodes)
+	}
+	if got[1].Operations != nil {
+		t.Errorf("got operations %v in a quiet point", got[1].Operations)
+	}
+}
+
+// A day-long run is charted with no more points than the plot is wide.
+func TestWriteReportHTMLLongTimeline(t *testing.T) {
+	report := &RunReport{
+		Started:         time.Now(),
+		DurationSeconds: 86400,
+		Operations:      []OperationReport{{Name: "op", Count: 86400}},
+		Timeline:        make([]TimelinePoint, 86400),
+	}
+	for i := range report.Timeline {
+		report.Timeline[i] = TimelinePoint{Second: i, ConnsOpen: 1, Operations: map[string]TimelineOperation{"op": {Count: 1, P50Ms: 1, P95Ms: 2, P99Ms: 3, MaxMs: 4}}}
+	}
+	path := filepath.Join(t.TempDir(), "run.html")
+	if err := writeReportHTML(path, report); err != nil {
+		t.Fatal(err)
+	}
+	page, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	polylines := regexp.MustCompile(`points="([^"]*)"`).FindAllStringSubmatch(string(page), -1)
+	if len(polylines) == 0 {
+		t.Fatal("report has no line charts")
+	}
+	for _, m := range polylines {
+		if n := len(strings.Fields(m[1])); n > chartWidth-chartLeft-chartRight {
+			t.Errorf("line chart has %d points, more than the plot's %d pixels", n, chartWidth-chartLeft-chartRight)
+		}
+	}
+	// One iteration a second is still charted as a rate of 1/s.
+	if !strings.Contains(string(page), ">1/s<") {
+		t.Error("throughput axis does not show 1/s")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Executor runs single-result operations over some transport.
+type Executor interface {
+	Execute(ctx context.Context, opType OperationType, prefix, query, variables string) (json.RawMessage, error)
+	Transport() string
+}
+
+func (c *Client) Transport() string { return "ws" }
+
+// authHeaders are sent with the websocket handshake and every HTTP request.
+func authHeaders() http.Header {
+	headers := http.Header{}
+	headers.Add("Cookie", "access_token=YOUR_ACCESS_TOKEN_HERE")
+	return headers
+}
+
+// HTTPClient sends queries and mutations as HTTP requests with a JSON body
+// (POST) or URL parameters (GET, queries only).
+type HTTPClient struct {
+	URL    string
+	Method string
+	client *http.Client
+
+	PersistedQueries *PersistedQueries
+}
+
+func NewHTTPClient(endpoint, method string) *HTTPClient {
+	return &HTTPClient{
+		URL:    endpoint,
+		Method: strings.ToUpper(method),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (h *HTTPClient) Transport() string { return "http" }
+
+// httpURL maps a ws:// or wss:// endpoint onto the same path over HTTP.
+func httpURL(wsURL string) string {
+	switch {
+	case strings.HasPrefix(wsURL, "wss://"):
+		return "https://" + strings.TrimPrefix(wsURL, "wss://")
+	case strings.HasPrefix(wsURL, "ws://"):
+		return "http://" + strings.TrimPrefix(wsURL, "ws://")
+	}
+	return wsURL
+}
+
+func (h *HTTPClient) Execute(ctx context.Context, opType OperationType, prefix, query, variables string) (json.RawMessage, error) {
+	result, err := h.ExecuteIncremental(ctx, opType, prefix, query, variables)
+	if err != nil {
+		return nil, err
+	}
+	return result.Payload(), nil
+}
+
+// ExecuteIncremental runs an operation that may use @defer or @stream, whose
+// parts arrive as a multipart/mixed response.
+func (h *HTTPClient) ExecuteIncremental(ctx context.Context, opType OperationType, prefix, query, variables string) (result *IncrementalResult, err error) {
+	if err := checkOperation(opType, prefix, query, variables); err != nil {
+		return nil, err
+	}
+	if h.Method == http.MethodGet && opType == OperationMutation {
+		return nil, fmt.Errorf("%s: mutations cannot be sent with GET", prefix)
+	}
+	ctx, span := startOperationSpan(ctx, opType, prefix)
+	span.SetAttr("graphql.transport", "http")
+	defer func() { endOperationSpan(span, result, err) }()
+
+	var hash string
+	if h.PersistedQueries.enabled() {
+		var err error
+		if hash, err = h.PersistedQueries.lookup(query); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", prefix, opType, err)
+		}
+		result, err := h.do(ctx, opType, prefix, "", variables, hash)
+		if err != nil || h.PersistedQueries.manifest != nil {
+			return result, err
+		}
+		msg := GraphQLMessage{Type: "next", Payload: result.Payload()}
+		switch {
+		case isPersistedQueryNotFound(msg):
+		case isPersistedQueryNotSupported(msg):
+			h.PersistedQueries.unsupported.Store(true)
+			hash = ""
+		default:
+			return result, nil
+		}
+	}
+	return h.do(ctx, opType, prefix, query, variables, hash)
+}
+
+func (h *HTTPClient) do(ctx context.Context, opType OperationType, prefix, query, variables, hash string) (*IncrementalResult, error) {
+	var extensions map[string]interface{}
+	if hash != "" {
+		extensions = persistedQueryExtension(hash)
+	}
+	payload := marshalPayload(query, variables, traceExtensions(ctx, extensions))
+
+	var req *http.Request
+	var err error
+	if h.Method == http.MethodGet {
+		var fields map[string]json.RawMessage
+		json.Unmarshal(payload, &fields)
+		params := url.Values{}
+		for k, v := range fields {
+			if k == "query" {
+				var s string
+				json.Unmarshal(v, &s)
+				params.Set(k, s)
+				continue
+			}
+			params.Set(k, string(v))
+		}
+		var target string
+		if target, err = withQuery(h.URL, params); err == nil {
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range authHeaders() {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "multipart/mixed;deferSpec=20220824, application/graphql-response+json, application/json")
+	if tp := spanFromContext(ctx).Traceparent(); tp != "" {
+		req.Header.Set("traceparent", tp)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s: %w", opType, err)
+	}
+	defer resp.Body.Close()
+	logSent(slog.With("transport", "http", "method", h.Method), opType, prefix, query, variables, hash)
+
+	result := &IncrementalResult{}
+	if ct := resp.Header.Get("Content-Type"); isMultipartMixed(ct) {
+		if err := readMultipartMixed(ct, resp.Body, result); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", prefix, opType, err)
+		}
+		return result, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s response: %w", opType, err)
+	}
+	// A GraphQL response body is returned as is, even with a non-2xx status,
+	// so errors are reported the same way as over the websocket.
+	var body struct {
+		Data   json.RawMessage `json:"data"`
+		Errors GraphQLErrors   `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil || (body.Data == nil && body.Errors == nil) {
+		return nil, fmt.Errorf("%s %s: HTTP %s: %s", prefix, opType, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return result, result.Apply(data)
+}
+
+// TransportRouter sends each operation over the transport configured for
+// its name, falling back to Default, and reports how long it took.
+type TransportRouter struct {
+	Default     Executor
+	ByOperation map[string]Executor
+}
+
+func (r *TransportRouter) Transport() string { return r.Default.Transport() }
+
+func (r *TransportRouter) Execute(ctx context.Context, opType OperationType, prefix, query, variables string) (json.RawMessage, error) {
+	exec, ok := r.ByOperation[prefix]
+	if !ok {
+		exec = r.Default
+	}
+	runStats.OperationStarted(prefix)
+	start := time.Now()
+	payload, err := exec.Execute(ctx, opType, prefix, query, variables)
+	elapsed := time.Since(start)
+	runStats.OperationFinished(prefix, elapsed, payload, err)
+	metrics.Operation(prefix, exec.Transport(), elapsed, payload, err)
+	logger := slog.With("op", prefix, "type", opType, "transport", exec.Transport(), "duration", elapsed.Round(time.Microsecond))
+	if err != nil {
+		logger.Warn("Operation failed", "err", err)
+	} else {
+		logger.Info("Operation finished")
+	}
+	return payload, err
+}
+
+// parseTransportOverrides parses "name=transport,..." into a map from
+// operation name to transport name.
+func parseTransportOverrides(s string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	if s == "" {
+		return overrides, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, transport, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || (transport != "ws" && transport != "http") {
+			return nil, fmt.Errorf("invalid override %q, expected operation=ws or operation=http", pair)
+		}
+		overrides[name] = transport
+	}
+	return overrides, nil
+}
+
+// withQuery adds params to the query string of rawURL, keeping any it
+// already has.
+func withQuery(rawURL string, params url.Values) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, vs := range params {
+		q[k] = append(q[k], vs...)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
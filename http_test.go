@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWithQuery(t *testing.T) {
+	tests := []struct {
+		url    string
+		params url.Values
+		want   string
+	}{
+		{url: "http://h/graphql", params: url.Values{"query": {"{ a }"}}, want: "http://h/graphql?query=%7B+a+%7D"},
+		{url: "http://h/graphql?token=x", params: url.Values{"query": {"q"}}, want: "http://h/graphql?query=q&token=x"},
+		{url: "http://h/graphql?a=1#frag", params: url.Values{"a": {"2"}}, want: "http://h/graphql?a=1&a=2#frag"},
+	}
+	for _, tt := range tests {
+		got, err := withQuery(tt.url, tt.params)
+		if err != nil {
+			t.Fatalf("withQuery(%q): %v", tt.url, err)
+		}
+		if got != tt.want {
+			t.Errorf("withQuery(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPClientGetWithQuery(t *testing.T) {
+	client := NewHTTPClient(httpURL(startMockServer(t, FaultPlan{}))+"?tenant=a", "GET")
+	result, err := client.Execute(context.Background(), OperationQuery, "sessions", `{ sessions { id } }`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(result)) != `{"data":{"sessions":[]}}` {
+		t.Errorf("got %s", result)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+// Patch is one entry of an `incremental` array: deferred fields (Data) or
+// streamed list items (Items) to apply at Path.
+type Patch struct {
+	Path   []interface{}          `json:"path"`
+	Label  string                 `json:"label,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Items  []interface{}          `json:"items,omitempty"`
+	Errors GraphQLErrors          `json:"errors,omitempty"`
+
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// IncrementalResult merges an initial result and the incremental payloads
+// that follow it for operations using @defer or @stream.
+type IncrementalResult struct {
+	Data       map[string]interface{}
+	Errors     GraphQLErrors
+	Extensions map[string]interface{}
+	Patches    []Patch
+	HasNext    bool
+
+	raw         json.RawMessage
+	incremental bool
+}
+
+type incrementalPayload struct {
+	Data        map[string]interface{} `json:"data"`
+	Errors      GraphQLErrors          `json:"errors"`
+	Extensions  map[string]interface{} `json:"extensions"`
+	HasNext     *bool                  `json:"hasNext"`
+	Incremental []Patch                `json:"incremental"`
+	// Payloads from servers predating the `incremental` array carry a single
+	// patch at the top level.
+	Path  []interface{} `json:"path"`
+	Label string        `json:"label"`
+	Items []interface{} `json:"items"`
+}
+
+// Apply merges one payload. The first payload is the initial result.
+func (r *IncrementalResult) Apply(payload json.RawMessage) error {
+	r.raw = payload
+	var p incrementalPayload
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return fmt.Errorf("error unmarshalling payload: %w", err)
+	}
+	if p.HasNext != nil {
+		r.incremental = true
+		r.HasNext = *p.HasNext
+	}
+
+	r.mergeExtensions(p.Extensions)
+
+	patches := p.Incremental
+	if p.Path != nil {
+		patches = append(patches, Patch{Path: p.Path, Label: p.Label, Data: p.Data, Items: p.Items, Errors: p.Errors})
+	} else {
+		if p.Data != nil {
+			if r.Data == nil {
+				r.Data = p.Data
+			} else {
+				mergeObjects(r.Data, p.Data)
+			}
+		}
+		r.Errors = append(r.Errors, p.Errors...)
+	}
+
+	for _, patch := range patches {
+		r.Patches = append(r.Patches, patch)
+		r.Errors = append(r.Errors, patch.Errors...)
+		r.mergeExtensions(patch.Extensions)
+		if err := r.applyPatch(patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *IncrementalResult) mergeExtensions(ext map[string]interface{}) {
+	if len(ext) == 0 {
+		return
+	}
+	if r.Extensions == nil {
+		r.Extensions = make(map[string]interface{})
+	}
+	mergeObjects(r.Extensions, ext)
+}
+
+func (r *IncrementalResult) applyPatch(patch Patch) error {
+	if r.Data == nil {
+		r.Data = make(map[string]interface{})
+	}
+	if patch.Items != nil {
+		// Streamed items: the path is the list's path followed by the index
+		// of the first item.
+		n := len(patch.Path)
+		if n < 2 || pathIndex(patch.Path[n-1]) < 0 {
+			return fmt.Errorf("stream patch path %v does not end at a list index", patch.Path)
+		}
+		key, isKey := patch.Path[n-2].(string)
+		parent, err := walkPath(r.Data, patch.Path[:n-2])
+		if err != nil {
+			return err
+		}
+		container, ok := parent.(map[string]interface{})
+		if !isKey || !ok {
+			return fmt.Errorf("stream patch path %v does not end at a list", patch.Path)
+		}
+		// Items go at their index, whatever arrived before them; gaps left by
+		// items still to come are null until then.
+		list, _ := container[key].([]interface{})
+		start := pathIndex(patch.Path[n-1])
+		for len(list) < start+len(patch.Items) {
+			list = append(list, nil)
+		}
+		copy(list[start:], patch.Items)
+		container[key] = list
+		return nil
+	}
+	target, err := walkPath(r.Data, patch.Path)
+	if err != nil {
+		return err
+	}
+	obj, ok := target.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("defer patch path %v is not an object", patch.Path)
+	}
+	mergeObjects(obj, patch.Data)
+	return nil
+}
+
+func walkPath(data interface{}, path []interface{}) (interface{}, error) {
+	cur := data
+	for i, seg := range path {
+		switch seg := seg.(type) {
+		case string:
+			obj, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("path %v: %v is not an object", path, path[:i])
+			}
+			cur = obj[seg]
+		case json.Number, float64:
+			list, ok := cur.([]interface{})
+			idx := pathIndex(seg)
+			if !ok || idx < 0 || idx >= len(list) {
+				return nil, fmt.Errorf("path %v: index %v out of range", path, seg)
+			}
+			cur = list[idx]
+		default:
+			return nil, fmt.Errorf("path %v: invalid segment %v", path, seg)
+		}
+	}
+	return cur, nil
+}
+
+func pathIndex(seg interface{}) int {
+	switch n := seg.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			return -1
+		}
+		return int(i)
+	case float64:
+		return int(n)
+	}
+	return -1
+}
+
+// mergeObjects deep-merges src into dst.
+func mergeObjects(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if srcObj, ok := v.(map[string]interface{}); ok {
+			if dstObj, ok := dst[k].(map[string]interface{}); ok {
+				mergeObjects(dstObj, srcObj)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// Payload returns the merged result. For operations that weren't delivered
+// incrementally it is the last payload as received.
+func (r *IncrementalResult) Payload() json.RawMessage {
+	if !r.incremental {
+		return r.raw
+	}
+	result := map[string]interface{}{"data": r.Data}
+	if len(r.Errors) > 0 {
+		result["errors"] = r.Errors
+	}
+	if len(r.Extensions) > 0 {
+		result["extensions"] = r.Extensions
+	}
+	b, _ := json.Marshal(result)
+	return b
+}
+
+// readMultipartMixed applies each part of a multipart/mixed response body.
+func readMultipartMixed(contentType string, body io.Reader, result *IncrementalResult) error {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid multipart content type %q: %w", contentType, err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		boundary = "-"
+	}
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading multipart response: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return fmt.Errorf("error reading multipart response: %w", err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		if err := result.Apply(data); err != nil {
+			return err
+		}
+		if !result.HasNext {
+			return nil
+		}
+	}
+}
+
+func isMultipartMixed(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "multipart/mixed")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIncrementalResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads []string
+		want     string
+	}{
+		{
+			name:     "not incremental",
+			payloads: []string{`{"data": {"a": 1}, "extensions": {"cost": 3}}`},
+			want:     `{"data": {"a": 1}, "extensions": {"cost": 3}}`,
+		},
+		{
+			name: "defer",
+			payloads: []string{
+				`{"data": {"session": {"id": "1"}}, "hasNext": true}`,
+				`{"incremental": [{"path": ["session"], "label": "more", "data": {"name": "a", "owner": {"id": "2"}}}], "hasNext": true}`,
+				`{"incremental": [{"path": ["session", "owner"], "data": {"name": "b"}}], "hasNext": false}`,
+			},
+			want: `{"data": {"session": {"id": "1", "name": "a", "owner": {"id": "2", "name": "b"}}}}`,
+		},
+		{
+			name: "stream in order",
+			payloads: []string{
+				`{"data": {"sessions": [{"id": "1"}]}, "hasNext": true}`,
+				`{"incremental": [{"path": ["sessions", 1], "items": [{"id": "2"}, {"id": "3"}]}], "hasNext": true}`,
+				`{"incremental": [{"path": ["sessions", 3], "items": [{"id": "4"}]}], "hasNext": false}`,
+			},
+			want: `{"data": {"sessions": [{"id": "1"}, {"id": "2"}, {"id": "3"}, {"id": "4"}]}}`,
+		},
+		{
+			name: "stream out of order",
+			payloads: []string{
+				`{"data": {"sessions": []}, "hasNext": true}`,
+				`{"incremental": [{"path": ["sessions", 2], "items": [{"id": "3"}]}], "hasNext": true}`,
+				`{"incremental": [{"path": ["sessions", 0], "items": [{"id": "1"}, {"id": "2"}]}], "hasNext": false}`,
+			},
+			want: `{"data": {"sessions": [{"id": "1"}, {"id": "2"}, {"id": "3"}]}}`,
+		},
+		{
+			name: "stream nested in a list",
+			payloads: []string{
+				`{"data": {"users": [{"sessions": [1]}, {"sessions": []}]}, "hasNext": true}`,
+				`{"incremental": [{"path": ["users", 1, "sessions", 0], "items": [2]}], "hasNext": false}`,
+			},
+			want: `{"data": {"users": [{"sessions": [1]}, {"sessions": [2]}]}}`,
+		},
+		{
+			name: "legacy top-level patches",
+			payloads: []string{
+				`{"data": {"session": {"id": "1"}, "sessions": [1]}, "hasNext": true}`,
+				`{"path": ["session"], "data": {"name": "a"}, "hasNext": true}`,
+				`{"path": ["sessions", 1], "items": [2], "hasNext": false}`,
+			},
+			want: `{"data": {"session": {"id": "1", "name": "a"}, "sessions": [1, 2]}}`,
+		},
+		{
+			name: "errors and extensions",
+			payloads: []string{
+				`{"data": {"session": {"id": "1"}}, "extensions": {"trace": {"start": 1}}, "hasNext": true}`,
+				`{"incremental": [{"path": ["session"], "data": {"name": null}, "errors": [{"message": "no name"}], "extensions": {"cost": 2}}], "extensions": {"trace": {"end": 2}}, "hasNext": false}`,
+			},
+			want: `{"data": {"session": {"id": "1", "name": null}}, "errors": [{"message": "no name"}], "extensions": {"cost": 2, "trace": {"start": 1, "end": 2}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r IncrementalResult
+			for _, p := range tt.payloads {
+				if err := r.Apply(json.RawMessage(p)); err != nil {
+					t.Fatalf("Apply(%s): %v", p, err)
+				}
+			}
+			assertJSONEqual(t, r.Payload(), tt.want)
+		})
+	}
+}
+
+func TestIncrementalResultErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads []string
+	}{
+		{
+			name:     "stream path without an index",
+			payloads: []string{`{"data": {"sessions": []}, "hasNext": true}`, `{"incremental": [{"path": ["sessions"], "items": [1]}], "hasNext": false}`},
+		},
+		{
+			name:     "defer path into a missing list item",
+			payloads: []string{`{"data": {"sessions": []}, "hasNext": true}`, `{"incremental": [{"path": ["sessions", 0], "data": {"id": "1"}}], "hasNext": false}`},
+		},
+		{
+			name:     "not JSON",
+			payloads: []string{`{"data":`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r IncrementalResult
+			var err error
+			for _, p := range tt.payloads {
+				if err = r.Apply(json.RawMessage(p)); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Errorf("got %s, want an error", r.Payload())
+			}
+		})
+	}
+}
+
+func TestReadMultipartMixed(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "defer",
+			contentType: `multipart/mixed; boundary="-"; deferSpec=20220824`,
+			body: "\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" +
+				`{"data": {"session": {"id": "1"}}, "hasNext": true}` +
+				"\r\n---\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" +
+				`{"incremental": [{"path": ["session"], "data": {"name": "a"}}], "hasNext": false}` +
+				"\r\n-----\r\n",
+			want: `{"data": {"session": {"id": "1", "name": "a"}}}`,
+		},
+		{
+			name:        "custom boundary and empty parts",
+			contentType: `multipart/mixed; boundary=graphql`,
+			body: "--graphql\r\n\r\n" +
+				`{"data": {"sessions": [1]}, "hasNext": true}` +
+				"\r\n--graphql\r\n\r\n\r\n--graphql\r\n\r\n" +
+				`{"incremental": [{"path": ["sessions", 1], "items": [2]}], "hasNext": true}` +
+				"\r\n--graphql\r\n\r\n" +
+				`{"hasNext": false}` +
+				"\r\n--graphql--\r\n",
+			want: `{"data": {"sessions": [1, 2]}}`,
+		},
+		{
+			name:        "stops at hasNext false",
+			contentType: `multipart/mixed`,
+			body: "---\r\n\r\n" +
+				`{"data": {"a": 1}, "hasNext": false}` +
+				"\r\n---\r\n\r\n" +
+				`not JSON` +
+				"\r\n-----\r\n",
+			want: `{"data": {"a": 1}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r IncrementalResult
+			if err := readMultipartMixed(tt.contentType, strings.NewReader(tt.body), &r); err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, r.Payload(), tt.want)
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, got json.RawMessage, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("got invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid expected JSON %s: %v", want, err)
+	}
+	gb, _ := json.Marshal(g)
+	wb, _ := json.Marshal(w)
+	if string(gb) != string(wb) {
+		t.Errorf("got %s, want %s", gb, wb)
+	}
+}
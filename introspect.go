@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+)
+
+const defaultSchemaPath = "schema.json"
+
+// cachedSchema is loaded from the schema cache at startup, if present, and
+// every operation is validated against it before being sent.
+var cachedSchema *Schema
+
+// checkOperation validates an operation against cachedSchema, if loaded.
+func checkOperation(opType OperationType, prefix, query, variables string) error {
+	if cachedSchema == nil {
+		return nil
+	}
+	if err := validateOperation(cachedSchema, query, variables); err != nil {
+		return fmt.Errorf("invalid %s %s:\n%w", prefix, opType, err)
+	}
+	return nil
+}
+
+// runIntrospect fetches the schema over the websocket, or HTTP, and caches it
+// as JSON and SDL.
+func runIntrospect(args []string) {
+	fs := flag.NewFlagSet("introspect", flag.ExitOnError)
+	url := fs.String("url", defaultURL, "GraphQL websocket endpoint")
+	protocol := fs.String("protocol", "auto", "websocket subprotocol: graphql-transport-ws, graphql-ws, or auto")
+	transport := fs.String("transport", "ws", "send the introspection query over ws or http")
+	out := fs.String("o", defaultSchemaPath, "where to write the introspection result; SDL is written alongside as .graphql")
+	record := fs.String("record", "", "record websocket frames and handshake headers, with secrets redacted, to this JSONL file")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	logOpts.setup()
+
+	startRecording(*record)
+	defer traffic.Close()
+
+	var exec Executor
+	if *transport == "http" {
+		exec = NewHTTPClient(httpURL(*url), "POST")
+	} else {
+		conn, proto := connect(*url, *protocol)
+		client := NewClient(conn, proto, nil)
+		defer client.Close()
+		exec = client
+	}
+
+	result, err := exec.Execute(context.Background(), OperationQuery, "introspect", introspectionQuery, "")
+	if err != nil {
+		log.Fatalf("Introspection query failed: %v", err)
+	}
+
+	schema, err := parseIntrospection(result)
+	if err != nil {
+		log.Fatalf("Error parsing introspection result: %v", err)
+	}
+	sdlPath, err := saveSchema(schema, *out)
+	if err != nil {
+		log.Fatalf("Error writing schema cache: %v", err)
+	}
+	fmt.Printf("Cached schema with %d types to %s and %s\n", len(schema.Types), *out, sdlPath)
+}
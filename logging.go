@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// logOptions are the logging flags shared by the commands that talk to a
+// server.
+type logOptions struct {
+	level         *string
+	format        *string
+	redactVars    *string
+	redactHeaders *string
+}
+
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	return &logOptions{
+		level:         fs.String("log-level", "info", "log level: debug (also logs documents, variables and messages), info, warn or error"),
+		format:        fs.String("log-format", "text", "log format: text or json"),
+		redactVars:    fs.String("redact-vars", "", "comma-separated variable paths to redact in logs, e.g. input.name; * matches any key and arrays match element-wise"),
+		redactHeaders: fs.String("redact-headers", "", "comma-separated headers to redact in logs and traffic files, in addition to Authorization, Cookie and X-Api-Key"),
+	}
+}
+
+// redactedVariablePaths are the -redact-vars paths, split on dots.
+var redactedVariablePaths [][]string
+
+// setup installs the default slog logger, which the log package also writes
+// through.
+func (o *logOptions) setup() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*o.level)); err != nil {
+		log.Fatalf("Invalid -log-level %q, expected debug, info, warn or error", *o.level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch *o.format {
+	case "text":
+		handler = slog.NewTextHandler(stderrWriter{}, opts)
+	case "json":
+		handler = slog.NewJSONHandler(stderrWriter{}, opts)
+	default:
+		log.Fatalf("Invalid -log-format %q, expected text or json", *o.format)
+	}
+	slog.SetDefault(slog.New(handler))
+	// What is still logged through the log package are errors.
+	slog.SetLogLoggerLevel(slog.LevelError)
+
+	for _, path := range splitList(*o.redactVars) {
+		redactedVariablePaths = append(redactedVariablePaths, strings.Split(path, "."))
+	}
+	for _, name := range splitList(*o.redactHeaders) {
+		redactedHeaders = append(redactedHeaders, http.CanonicalHeaderKey(name))
+	}
+}
+
+// stderrWriter writes to whatever os.Stderr is at the time, so the
+// dashboard can capture logs.
+type stderrWriter struct{}
+
+func (stderrWriter) Write(p []byte) (int, error) { return os.Stderr.Write(p) }
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// logSent logs an operation that was sent. The document and variables are
+// only logged at debug level, with variables redacted.
+func logSent(logger *slog.Logger, opType OperationType, prefix, query, variables, hash string) {
+	logger = logger.With("op", prefix, "type", opType)
+	if hash != "" {
+		logger = logger.With("persistedQuery", hash)
+	}
+	logger.Info("Sent operation")
+	logger.Debug("Operation document", "query", query, "variables", redactVariables(variables))
+}
+
+// unparseable replaces variables that aren't valid JSON in logs.
+const unparseable = "[unparseable]"
+
+// redactVariables returns variables with secret-looking keys and the
+// -redact-vars paths replaced, for logging.
+func redactVariables(variables string) string {
+	if variables == "" {
+		return ""
+	}
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(variables))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		// Secrets can't be found in what doesn't parse.
+		return unparseable
+	}
+	changed := redactValue(v)
+	for _, path := range redactedVariablePaths {
+		if redactPath(v, path) {
+			changed = true
+		}
+	}
+	if !changed {
+		return variables
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return variables
+	}
+	return string(b)
+}
+
+// redactPath replaces the values at path in v and reports whether any were
+// found.
+func redactPath(v interface{}, path []string) bool {
+	changed := false
+	switch v := v.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if redactPath(elem, path) {
+				changed = true
+			}
+		}
+	case map[string]interface{}:
+		for k, field := range v {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				v[k] = redacted
+				changed = true
+			} else if redactPath(field, path[1:]) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
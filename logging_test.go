@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRedactVariables(t *testing.T) {
+	redactedVariablePaths = [][]string{{"input", "name"}}
+	defer func() { redactedVariablePaths = nil }()
+	tests := []struct {
+		variables, want string
+	}{
+		{"", ""},
+		{`{"input": [{"id": 1}]}`, `{"input": [{"id": 1}]}`},
+		{`{"input": [{"name": "a"}, {"name": "b"}]}`, `{"input":[{"name":"[REDACTED]"},{"name":"[REDACTED]"}]}`},
+		{`{"password": "hunter2", "n": 1.50}`, `{"n":1.50,"password":"[REDACTED]"}`},
+		{`{"password": "hunter2"`, unparseable},
+	}
+	for _, tt := range tests {
+		if got := redactVariables(tt.variables); got != tt.want {
+			t.Errorf("redactVariables(%s) = %s, want %s", tt.variables, got, tt.want)
+		}
+	}
+}
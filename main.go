@@ -2,61 +2,398 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const defaultURL = "wss://api.wiv.ew1.tc.development.catapult.com/federation/api/graphql"
+
 func main() {
-	url := "wss://api.wiv.ew1.tc.development.catapult.com/federation/api/graphql"
-	fmt.Printf("Connected to %v \n", url)
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	headers := http.Header{}
-	headers.Add("Cookie", "access_token=YOUR_ACCESS_TOKEN_HERE")
-	headers.Add("Sec-WebSocket-Protocol", "graphql-transport-ws")
+	switch cmd {
+	case "run":
+		runWorkflow(args)
+	case "introspect":
+		runIntrospect(args)
+	case "validate":
+		runValidate(args)
+	case "generate":
+		runGenerate(args)
+	case "execute":
+		runExecute(args)
+	case "subscribe":
+		runSubscribe(args)
+	case "mock-server":
+		runMockServer(args)
+	case "replay":
+		runReplay(args)
+	case "repl":
+		runREPL(args)
+	case "soak":
+		runSoak(args)
+	case "compare":
+		runCompare(args)
+	case "report":
+		runReport(args)
+	default:
+		log.Fatalf("Unknown command %q (expected run, introspect, validate, generate, execute, subscribe, mock-server, replay, repl, soak, compare or report)", cmd)
+	}
+}
 
-	conn, _, err := websocket.DefaultDialer.Dial(url, headers)
-	if err != nil {
-		log.Fatalf("Error connecting to WebSocket: %v", err)
+func runWorkflow(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	url := fs.String("url", defaultURL, "GraphQL websocket endpoint")
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result used to validate operations before sending")
+	protocol := fs.String("protocol", "auto", "websocket subprotocol: graphql-transport-ws, graphql-ws, or auto to let the server choose")
+	transport := fs.String("transport", "ws", "transport for queries and mutations: ws or http; subscriptions always use ws")
+	transportOps := fs.String("transport-op", "", "per-operation transport overrides, e.g. createSession=http,deleteSession=ws")
+	httpEndpoint := fs.String("http-url", "", "HTTP endpoint for the http transport (default: -url with http(s) scheme)")
+	httpMethod := fs.String("http-method", "POST", "HTTP method for the http transport: POST or GET (queries only)")
+	apq := fs.Bool("apq", false, "send automatic persisted queries: hash first, full query on PersistedQueryNotFound")
+	apqManifest := fs.String("apq-manifest", "", "only send hashes of operations registered in this persisted query manifest")
+	record := fs.String("record", "", "record websocket frames and handshake headers, with secrets redacted, to this JSONL file")
+	vus := fs.Int("vus", 1, "virtual users running iterations concurrently on the shared connection; with -arrival, the ones started up front")
+	arrivalKind := fs.String("arrival", "", "start iterations at a rate rather than back to back: constant, ramping or stepped")
+	rate := fs.Float64("rate", 0, "iterations per second with -arrival constant, and the starting rate with -arrival ramping")
+	duration := fs.Duration("duration", time.Minute, "how long to run -arrival constant")
+	stages := fs.String("stages", "", "duration:rate stages for -arrival ramping or stepped, e.g. 30s:10,1m:100,30s:0")
+	maxVUs := fs.Int("max-vus", 100, "most virtual users -arrival may start to keep up with the rate; iterations are dropped beyond that")
+	poolSize := fs.Int("pool", 0, "spread websocket operations over a pool of this many connections instead of one")
+	poolMaxInFlight := fs.Int("pool-max-inflight", 0, "most operations in flight on one pooled connection, 0 for no limit")
+	poolMaxRTT := fs.Duration("pool-max-rtt", 2*time.Second, "ping round trip time above which a pooled connection is unhealthy")
+	poolHealthInterval := fs.Duration("pool-health-interval", 10*time.Second, "how often to ping pooled connections")
+	poolMaxFailedPings := fs.Int("pool-max-failed-pings", 3, "failed pings in a row after which a pooled connection is closed and replaced")
+	iterations := fs.Int("iterations", 10, "create/delete iterations to run across all virtual users")
+	pause := fs.Duration("pause", 2*time.Second, "pause between a virtual user's iterations")
+	dashboardFlag := fs.Bool("dashboard", false, "show a live terminal dashboard instead of printing every message")
+	exit := fs.Bool("exit", false, "return once the summary is printed instead of staying connected and printing received messages until the connection is lost")
+	reportPath := fs.String("report", "", "write a run report to this file: CSV if it ends in .csv, an HTML page with charts if it ends in .html, JSON otherwise")
+	verify := fs.Bool("verify", false, "subscribe to sessionUpdates and check that every created and deleted session is announced, measuring propagation latency")
+	verifyDeadline := fs.Duration("verify-deadline", 5*time.Second, "how long after its mutation a session update may arrive with -verify")
+	var sinkSpecs stringsFlag
+	fs.Var(&sinkSpecs, "sink", "send unhandled messages (as \"unhandled\") and -verify events (as \"sessionUpdates\") to a sink instead of logging them; repeatable, see subscribe")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address at /metrics, e.g. :9090")
+	traceEndpoint := fs.String("trace-endpoint", "", "export OpenTelemetry spans as OTLP/JSON to this collector URL, e.g. http://localhost:4318/v1/traces")
+	traceFile := fs.String("trace-file", "", "append OpenTelemetry spans as OTLP/JSON lines to this file")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+
+	logOpts.setup()
+	var sinks *SinkRouter
+	if len(sinkSpecs) > 0 {
+		var err error
+		if sinks, err = parseSinks(sinkSpecs); err != nil {
+			log.Fatalf("Invalid -sink: %v", err)
+		}
+		defer sinks.Close()
+	}
+	startRecording(*record)
+	serveMetrics(*metricsAddr)
+	startTracing(*traceEndpoint, *traceFile)
+	defer tracer.Close()
+	defer traffic.Close()
+	if *vus < 1 {
+		log.Fatalf("Invalid -vus %d, expected at least 1", *vus)
 	}
-	defer conn.Close()
 
-	fmt.Println("Connected to WebSocket")
+	var schedule *arrivalSchedule
+	if *arrivalKind != "" {
+		s, err := parseArrival(*arrivalKind, *rate, *duration, *stages)
+		if err != nil {
+			log.Fatalf("Invalid -arrival: %v", err)
+		}
+		if *maxVUs < *vus {
+			log.Fatalf("Invalid -max-vus %d, expected at least -vus %d", *maxVUs, *vus)
+		}
+		schedule = s
+	}
 
-	initMsg := GraphQLMessage{Type: "connection_init"}
-	if err := conn.WriteJSON(initMsg); err != nil {
-		log.Fatalf("Error sending connection_init: %v", err)
+	var persisted *PersistedQueries
+	if *apqManifest != "" {
+		pq, err := loadPersistedQueryManifest(*apqManifest)
+		if err != nil {
+			log.Fatalf("Error loading persisted query manifest: %v", err)
+		}
+		for _, op := range builtinOperations {
+			if _, err := pq.lookup(op.Query); err != nil {
+				log.Fatalf("Operation %s: %v", op.Name, err)
+			}
+		}
+		persisted = pq
+	} else if *apq {
+		persisted = &PersistedQueries{}
 	}
 
-	for {
-		var msg GraphQLMessage
-		if err := conn.ReadJSON(&msg); err != nil {
-			log.Fatalf("Error reading WebSocket message: %v", err)
+	schema, err := loadSchema(*schemaPath)
+	switch {
+	case err == nil:
+		for _, op := range builtinOperations {
+			if err := validateOperation(schema, op.Query, op.Variables); err != nil {
+				log.Fatalf("Operation %s is invalid against %s:\n%v", op.Name, *schemaPath, err)
+			}
 		}
-		if msg.Type == "connection_ack" {
-			fmt.Println("Received connection_ack, proceeding with workflow")
-			break
+		if *verify {
+			if err := validateOperation(schema, sessionUpdatesSubscription, ""); err != nil {
+				log.Fatalf("Verification subscription is invalid against %s:\n%v", *schemaPath, err)
+			}
 		}
+		cachedSchema = schema
+	case errors.Is(err, os.ErrNotExist):
+		slog.Warn("No schema cache, operations will not be validated", "path", *schemaPath)
+	default:
+		log.Fatalf("Error loading schema: %v", err)
 	}
 
-	for i := 0; i < 10; i++ {
-		createAndDeleteSession(conn)
-		time.Sleep(2 * time.Second)
+	overrides, err := parseTransportOverrides(*transportOps)
+	if err != nil {
+		log.Fatalf("Invalid -transport-op: %v", err)
+	}
+	if *httpEndpoint == "" {
+		*httpEndpoint = httpURL(*url)
 	}
 
+	runStats = NewStats()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go pingRoutine(ctx, conn)
+	onUnhandled := func(message []byte) {
+		if sinks != nil {
+			sinks.Write(SinkEvent{Time: time.Now(), Subscription: "unhandled", Payload: message})
+			return
+		}
+		slog.Info("Received message", "payload", redactPayload(message))
+	}
+	var client *Client
+	var ws interface {
+		Executor
+		Subscriber
+	}
+	// closeWS ends the run's websocket connections, so that the summary
+	// doesn't count them as open.
+	var closeWS func()
+	if *poolSize > 0 {
+		opts := PoolOptions{
+			MaxInFlight:    *poolMaxInFlight,
+			MaxRTT:         *poolMaxRTT,
+			HealthInterval: *poolHealthInterval,
+			MaxFailedPings: *poolMaxFailedPings,
+		}
+		pool, err := NewPool(*url, *protocol, *poolSize, opts, persisted, onUnhandled)
+		if err != nil {
+			log.Fatalf("Error opening connection pool: %v", err)
+		}
+		defer pool.Close()
+		ws = pool
+		closeWS = pool.Close
+	} else {
+		conn, proto := connect(*url, *protocol)
+		client = NewClient(conn, proto, onUnhandled)
+		defer client.Close()
+		client.PersistedQueries = persisted
+		go pingRoutine(ctx, client)
+		ws = client
+		closeWS = func() { client.Close() }
+	}
+
+	httpClient := NewHTTPClient(*httpEndpoint, *httpMethod)
+	httpClient.PersistedQueries = persisted
+	transports := map[string]Executor{"ws": ws, "http": httpClient}
+	router := &TransportRouter{Default: transports[*transport], ByOperation: make(map[string]Executor)}
+	if router.Default == nil {
+		log.Fatalf("Invalid -transport %q, expected ws or http", *transport)
+	}
+	for name, t := range overrides {
+		router.ByOperation[name] = transports[t]
+	}
+
+	var verifier *sessionVerifier
+	if *verify {
+		if verifier, err = startSessionVerifier(ctx, ws, *verifyDeadline, sinks); err != nil {
+			log.Fatalf("Error subscribing to session updates: %v", err)
+		}
+	}
+
+	iterate := func(n int64) error {
+		ictx, span := tracer.Start(ctx, "iteration", spanKindInternal)
+		span.SetAttr("iteration", int(n))
+		err := createAndDeleteSession(ictx, router, verifier)
+		span.End(err)
+		if err != nil {
+			slog.Error("Iteration failed", "iteration", n, "err", err)
+		}
+		return err
+	}
+
+	var dash *dashboard
+	if schedule != nil {
+		// Iterations are timed from when they were due rather than when a
+		// virtual user got to them, so waiting for one counts as latency.
+		exec := &arrivalExecutor{schedule: schedule, preVUs: *vus, maxVUs: *maxVUs, iterate: func(n int64, scheduled time.Time) {
+			runStats.OperationStarted("iteration")
+			err := iterate(n)
+			runStats.OperationFinished("iteration", time.Since(scheduled), nil, err)
+		}}
+		if *dashboardFlag {
+			dash = startDashboard(runStats, func() string {
+				return fmt.Sprintf("%s  elapsed %v  %s arrivals (peak %.1f/s)  vus %d/%d  iterations %d", *url,
+					time.Since(runStats.started).Round(time.Second), *arrivalKind, schedule.peak(), exec.vus.Load(), *maxVUs, exec.started.Load())
+			})
+		}
+		exec.run(ctx)
+	} else {
+		var started atomic.Int64
+		if *dashboardFlag {
+			dash = startDashboard(runStats, func() string {
+				return fmt.Sprintf("%s  elapsed %v  vus %d  iterations %d/%d", *url,
+					time.Since(runStats.started).Round(time.Second), *vus, min(started.Load(), int64(*iterations)), *iterations)
+			})
+		}
+		var wg sync.WaitGroup
+		for vu := 1; vu <= *vus; vu++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					n := started.Add(1)
+					if n > int64(*iterations) {
+						return
+					}
+					iterate(n)
+					time.Sleep(*pause)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if dash != nil {
+		dash.Stop()
+	}
+
+	// A single connection stays open after the workflow, as it always has,
+	// unless -exit is given.
+	stay := client != nil && !*exit
+	if !stay {
+		cancel()
+		closeWS()
+	}
+	snap := runStats.Snapshot()
+	for _, line := range statsTable(snap) {
+		fmt.Println(line)
+	}
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, newRunReport(snap)); err != nil {
+			log.Fatalf("Error writing report: %v", err)
+		}
+		fmt.Printf("Wrote run report to %s\n", *reportPath)
+	}
+
+	if stay {
+		<-client.Done()
+		slog.Error("Connection lost", "err", client.Err())
+	}
+}
+
+// connect dials the endpoint, offering the subprotocols for protocolFlag,
+// and completes the connection_init handshake in the protocol the server
+// chose. It exits if any of that fails.
+func connect(url, protocolFlag string) (*websocket.Conn, Protocol) {
+	conn, proto, err := dial(url, protocolFlag)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return conn, proto
+}
+
+// dial is connect, returning errors instead of exiting.
+func dial(url, protocolFlag string) (conn *websocket.Conn, proto Protocol, err error) {
+	slog.Info("Connecting", "url", url)
+
+	offered, err := offeredSubprotocols(protocolFlag)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid -protocol: %w", err)
+	}
+	headers := authHeaders()
+	headers.Add("Sec-WebSocket-Protocol", offered)
+
+	ctx, connSpan := tracer.Start(context.Background(), "websocket connect", spanKindClient)
+	connSpan.SetAttr("url.full", url)
+	defer func() { connSpan.End(err) }()
+	_, dialSpan := tracer.Start(ctx, "dial", spanKindClient)
+	if tp := dialSpan.Traceparent(); tp != "" {
+		headers.Set("traceparent", tp)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, headers)
+	if err != nil {
+		dialSpan.End(err)
+		return nil, nil, fmt.Errorf("error connecting to WebSocket: %w", err)
+	}
+	dialSpan.SetAttr("websocket.subprotocol", conn.Subprotocol())
+	dialSpan.End(nil)
+	traffic.Open(conn, url, headers, resp)
+	slog.Debug("Handshake", "requestHeaders", redactHeaders(headers), "responseHeaders", redactHeaders(resp.Header))
+	if proto, err = negotiatedProtocol(protocolFlag, conn.Subprotocol()); err != nil {
+		conn.Close()
+		traffic.Closed(conn)
+		return nil, nil, err
+	}
+
+	slog.Info("Connected", "url", url, "subprotocol", proto.Subprotocol())
 
+	_, initSpan := tracer.Start(ctx, "connection_init", spanKindClient)
+	if err = handshake(conn, initSpan); err != nil {
+		conn.Close()
+		traffic.Closed(conn)
+	}
+	initSpan.End(err)
+	return conn, proto, err
+}
+
+// handshakeTimeout bounds the wait for connection_ack.
+const handshakeTimeout = 30 * time.Second
+
+// handshake sends connection_init and waits for connection_ack.
+func handshake(conn *websocket.Conn, span *Span) error {
+	initMsg, _ := json.Marshal(GraphQLMessage{Type: "connection_init"})
+	if err := conn.WriteMessage(websocket.TextMessage, initMsg); err != nil {
+		return fmt.Errorf("error sending connection_init: %w", err)
+	}
+	traffic.Frame(conn, "out", websocket.TextMessage, initMsg)
+	metrics.Message("sent", "connection_init")
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
 	for {
-		_, message, err := conn.ReadMessage()
+		opcode, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
-			break
+			return fmt.Errorf("error reading WebSocket message: %w", err)
+		}
+		traffic.Frame(conn, "in", opcode, data)
+		var msg GraphQLMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("error unmarshalling WebSocket message: %w", err)
+		}
+		metrics.Message("received", msg.Type)
+		if msg.Type == "connection_error" {
+			return fmt.Errorf("server rejected connection_init: %s", string(msg.Payload))
+		}
+		if msg.Type == "connection_ack" {
+			slog.Info("Received connection_ack")
+			return nil
 		}
-		fmt.Printf("%s: Received: %s\n", time.Now().Format(time.RFC3339), string(message))
+		span.AddEvent("message", map[string]interface{}{"type": msg.Type})
 	}
 }
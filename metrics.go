@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the exported latency
+// histograms.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// promHistogram is a histogram in Prometheus form: cumulative counts per
+// bucket, with a sum and count.
+type promHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *promHistogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	secs := d.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// Metrics counts connections, messages, operations, pings and subscriptions
+// for the Prometheus /metrics endpoint. A nil *Metrics records nothing.
+type Metrics struct {
+	mu            sync.Mutex
+	connsOpened   uint64
+	connsClosed   map[string]uint64
+	messages      map[[2]string]uint64
+	latency       map[[2]string]*promHistogram
+	graphqlErrors map[[2]string]uint64
+	pings         uint64
+	pongs         uint64
+	rtt           promHistogram
+	subscriptions map[string]int
+	sequence      map[[2]string]uint64
+	missing       map[string]uint64
+	dropped       uint64
+}
+
+// metrics is the collector served by -metrics-addr, if any.
+var metrics *Metrics
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		connsClosed:   make(map[string]uint64),
+		messages:      make(map[[2]string]uint64),
+		latency:       make(map[[2]string]*promHistogram),
+		graphqlErrors: make(map[[2]string]uint64),
+		subscriptions: make(map[string]int),
+		sequence:      make(map[[2]string]uint64),
+		missing:       make(map[string]uint64),
+	}
+}
+
+// serveMetrics starts collecting metrics and serves them on addr at /metrics.
+// It does nothing if addr is empty.
+func serveMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error serving metrics: %v", err)
+	}
+	metrics = NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics)
+	slog.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", ln.Addr()))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			slog.Error("Metrics server stopped", "err", err)
+		}
+	}()
+}
+
+func (m *Metrics) ConnectionOpened() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connsOpened++
+}
+
+// ConnectionClosed counts a closed connection by its close code, or by the
+// kind of error for connections lost without a close frame.
+func (m *Metrics) ConnectionClosed(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connsClosed[closeReason(err)]++
+}
+
+// Message counts a websocket message by direction ("sent" or "received")
+// and its protocol message type.
+func (m *Metrics) Message(direction, msgType string) {
+	if m == nil {
+		return
+	}
+	if msgType == "" {
+		msgType = "unknown"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[[2]string{direction, msgType}]++
+}
+
+func (m *Metrics) Ping() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pings++
+}
+
+// IterationDropped counts an iteration an arrival-rate run had no virtual
+// user for.
+func (m *Metrics) IterationDropped() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped++
+}
+
+// Pong counts a pong and, if it answers a ping we sent, its round trip time.
+func (m *Metrics) Pong(rtt time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pongs++
+	if rtt > 0 {
+		m.rtt.observe(rtt)
+	}
+}
+
+// Operation records an operation's latency and the GraphQL errors in its
+// result, by extensions.code.
+func (m *Metrics) Operation(name, transport string, latency time.Duration, payload json.RawMessage, err error) {
+	if m == nil {
+		return
+	}
+	errs := resultErrors(payload, err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{name, transport}
+	h, ok := m.latency[key]
+	if !ok {
+		h = &promHistogram{}
+		m.latency[key] = h
+	}
+	h.observe(latency)
+	for _, e := range errs {
+		code, _ := e.Extensions["code"].(string)
+		if code == "" {
+			code = "UNKNOWN"
+		}
+		m.graphqlErrors[[2]string{name, code}]++
+	}
+}
+
+// SubscriptionStarted and SubscriptionEnded track the active subscriptions
+// per transport.
+func (m *Metrics) SubscriptionStarted(transport string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptions[transport]++
+}
+
+func (m *Metrics) SubscriptionEnded(transport string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptions[transport]--
+}
+
+// Sequence counts a checked subscription event by its result (ok, gap,
+// duplicate, out_of_order or unsequenced) and sets how many sequence
+// numbers are still missing.
+func (m *Metrics) Sequence(subscription, result string, missing uint64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sequence[[2]string{subscription, result}]++
+	m.missing[subscription] = missing
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family(w, "graphql_client_connections_opened_total", "counter", "Websocket connections opened.")
+	sample(w, "graphql_client_connections_opened_total", nil, float64(m.connsOpened))
+
+	family(w, "graphql_client_connections_closed_total", "counter", "Websocket connections closed, by close code or error kind.")
+	for _, code := range sortedKeys(m.connsClosed) {
+		sample(w, "graphql_client_connections_closed_total", []string{"code", code}, float64(m.connsClosed[code]))
+	}
+
+	family(w, "graphql_client_messages_total", "counter", "Websocket messages, by direction and message type.")
+	for _, key := range sortedPairs(m.messages) {
+		sample(w, "graphql_client_messages_total", []string{"direction", key[0], "type", key[1]}, float64(m.messages[key]))
+	}
+
+	family(w, "graphql_client_operation_duration_seconds", "histogram", "Query and mutation latency, by operation and transport.")
+	for _, key := range sortedPairs(m.latency) {
+		histogram(w, "graphql_client_operation_duration_seconds", []string{"operation", key[0], "transport", key[1]}, m.latency[key])
+	}
+
+	family(w, "graphql_client_graphql_errors_total", "counter", "GraphQL errors in operation results, by operation and extensions.code.")
+	for _, key := range sortedPairs(m.graphqlErrors) {
+		sample(w, "graphql_client_graphql_errors_total", []string{"operation", key[0], "code", key[1]}, float64(m.graphqlErrors[key]))
+	}
+
+	family(w, "graphql_client_pings_total", "counter", "Pings sent.")
+	sample(w, "graphql_client_pings_total", nil, float64(m.pings))
+	family(w, "graphql_client_pongs_total", "counter", "Pongs received.")
+	sample(w, "graphql_client_pongs_total", nil, float64(m.pongs))
+	family(w, "graphql_client_ping_rtt_seconds", "histogram", "Round trip time from ping to pong.")
+	histogram(w, "graphql_client_ping_rtt_seconds", nil, &m.rtt)
+
+	family(w, "graphql_client_dropped_iterations_total", "counter", "Scheduled iterations dropped because every virtual user was busy.")
+	sample(w, "graphql_client_dropped_iterations_total", nil, float64(m.dropped))
+
+	family(w, "graphql_client_subscriptions_active", "gauge", "Subscriptions currently running, by transport.")
+	for _, transport := range sortedKeys(m.subscriptions) {
+		sample(w, "graphql_client_subscriptions_active", []string{"transport", transport}, float64(m.subscriptions[transport]))
+	}
+
+	family(w, "graphql_client_subscription_events_checked_total", "counter", "Subscription events checked for sequence, by result: ok, gap, duplicate, out_of_order or unsequenced.")
+	for _, key := range sortedPairs(m.sequence) {
+		sample(w, "graphql_client_subscription_events_checked_total", []string{"subscription", key[0], "result", key[1]}, float64(m.sequence[key]))
+	}
+	family(w, "graphql_client_subscription_missing_events", "gauge", "Sequence numbers skipped by a subscription that haven't arrived since.")
+	for _, sub := range sortedKeys(m.missing) {
+		sample(w, "graphql_client_subscription_missing_events", []string{"subscription", sub}, float64(m.missing[sub]))
+	}
+}
+
+func family(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels alternate names and values.
+func sample(w io.Writer, name string, labels []string, value float64) {
+	fmt.Fprintf(w, "%s%s %v\n", name, formatLabels(labels), value)
+}
+
+func histogram(w io.Writer, name string, labels []string, h *promHistogram) {
+	for i, le := range latencyBuckets {
+		var n uint64
+		if h.counts != nil {
+			n = h.counts[i]
+		}
+		sample(w, name+"_bucket", append(labels[:len(labels):len(labels)], "le", fmt.Sprint(le)), float64(n))
+	}
+	sample(w, name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(h.count))
+	sample(w, name+"_sum", labels, h.sum)
+	sample(w, name+"_count", labels, float64(h.count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs[V any](m map[[2]string]V) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
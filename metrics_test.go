@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the lines m serves on /metrics.
+func scrape(t *testing.T, m *Metrics) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	return strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+}
+
+func recordSampleMetrics(m *Metrics) {
+	m.ConnectionOpened()
+	m.ConnectionClosed(errors.New("boom"))
+	m.Message("out", "subscribe")
+	m.Ping()
+	m.Pong(3 * time.Millisecond)
+	for _, d := range []time.Duration{500 * time.Microsecond, 20 * time.Millisecond, 20 * time.Millisecond, 3 * time.Second, time.Minute} {
+		m.Operation("sessions", "ws", d, nil, nil)
+	}
+	m.Operation("say \"hi\"\\\n", "http", time.Millisecond, []byte(`{"errors": [{"message": "no", "extensions": {"code": "BAD"}}]}`), nil)
+	m.SubscriptionStarted("ws")
+	m.Sequence("sessionUpdates", "gap", 2)
+}
+
+func TestMetricsFamilies(t *testing.T) {
+	m := NewMetrics()
+	recordSampleMetrics(m)
+
+	kinds := make(map[string]string)
+	help := make(map[string]bool)
+	for _, line := range scrape(t, m) {
+		fields := strings.SplitN(line, " ", 4)
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			if help[fields[2]] {
+				t.Errorf("second HELP line for %s", fields[2])
+			}
+			if len(fields) < 4 || fields[3] == "" {
+				t.Errorf("HELP line without text: %q", line)
+			}
+			help[fields[2]] = true
+		case strings.HasPrefix(line, "# TYPE "):
+			if !help[fields[2]] {
+				t.Errorf("TYPE line for %s before its HELP line", fields[2])
+			}
+			if _, ok := kinds[fields[2]]; ok {
+				t.Errorf("second TYPE line for %s", fields[2])
+			}
+			switch fields[3] {
+			case "counter", "gauge", "histogram":
+			default:
+				t.Errorf("%s has unknown type %q", fields[2], fields[3])
+			}
+			kinds[fields[2]] = fields[3]
+		default:
+			name := line[:strings.IndexAny(line, "{ ")]
+			family := name
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base, ok := strings.CutSuffix(name, suffix); ok && kinds[base] == "histogram" {
+					family = base
+				}
+			}
+			if _, ok := kinds[family]; !ok {
+				t.Errorf("sample before the TYPE line of its family: %q", line)
+			}
+			if strings.HasSuffix(family, "_total") && kinds[family] != "counter" {
+				t.Errorf("%s ends in _total but is a %s", family, kinds[family])
+			}
+			if _, err := strconv.ParseFloat(line[strings.LastIndex(line, " ")+1:], 64); err != nil {
+				t.Errorf("sample with an invalid value: %q", line)
+			}
+		}
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	m := NewMetrics()
+	recordSampleMetrics(m)
+	want := `graphql_client_graphql_errors_total{operation="say \"hi\"\\\n",code="BAD"} 1`
+	for _, line := range scrape(t, m) {
+		if line == want {
+			return
+		}
+	}
+	t.Errorf("no line %s", want)
+}
+
+var bucketSample = regexp.MustCompile(`^(\w+)_bucket\{(.*?),?le="([^"]+)"\} (\S+)$`)
+
+func TestMetricsHistogramBuckets(t *testing.T) {
+	m := NewMetrics()
+	recordSampleMetrics(m)
+	lines := scrape(t, m)
+
+	type series struct {
+		le     []float64
+		counts []float64
+	}
+	histograms := make(map[string]*series)
+	var order []string
+	for _, line := range lines {
+		match := bucketSample.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		key := match[1] + "{" + match[2] + "}"
+		s, ok := histograms[key]
+		if !ok {
+			s = &series{}
+			histograms[key] = s
+			order = append(order, key)
+		}
+		le, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			t.Fatalf("bad le in %q", line)
+		}
+		count, _ := strconv.ParseFloat(match[4], 64)
+		s.le = append(s.le, le)
+		s.counts = append(s.counts, count)
+	}
+	if len(histograms) == 0 {
+		t.Fatal("no histogram buckets")
+	}
+
+	counts := make(map[string]string)
+	for _, line := range lines {
+		i := strings.LastIndex(line, " ")
+		if name, labels, _ := strings.Cut(line[:i], "{"); strings.HasSuffix(name, "_count") {
+			counts[strings.TrimSuffix(name, "_count")+"{"+strings.TrimSuffix(labels, "}")+"}"] = line[i+1:]
+		}
+	}
+	for _, key := range order {
+		s := histograms[key]
+		for i := 1; i < len(s.le); i++ {
+			if s.le[i] <= s.le[i-1] {
+				t.Errorf("%s: bucket le=%v follows le=%v", key, s.le[i], s.le[i-1])
+			}
+			if s.counts[i] < s.counts[i-1] {
+				t.Errorf("%s: bucket le=%v has %v, less than %v in le=%v", key, s.le[i], s.counts[i], s.counts[i-1], s.le[i-1])
+			}
+		}
+		last := len(s.le) - 1
+		if !math.IsInf(s.le[last], 1) {
+			t.Errorf("%s: last bucket is le=%v, want +Inf", key, s.le[last])
+		}
+		if want := counts[key]; strconv.FormatFloat(s.counts[last], 'g', -1, 64) != want {
+			t.Errorf("%s: +Inf bucket has %v, want the count %s", key, s.counts[last], want)
+		}
+	}
+
+	// One of five operations was slower than every bucket but +Inf.
+	want := []string{
+		`graphql_client_operation_duration_seconds_bucket{operation="sessions",transport="ws",le="0.001"} 1`,
+		`graphql_client_operation_duration_seconds_bucket{operation="sessions",transport="ws",le="0.025"} 3`,
+		`graphql_client_operation_duration_seconds_bucket{operation="sessions",transport="ws",le="5"} 4`,
+		`graphql_client_operation_duration_seconds_bucket{operation="sessions",transport="ws",le="30"} 4`,
+		`graphql_client_operation_duration_seconds_bucket{operation="sessions",transport="ws",le="+Inf"} 5`,
+	}
+	got := strings.Join(lines, "\n")
+	for _, line := range want {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("no line %s", line)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// mockSchemaSDL is the subset of the gateway schema served by the mock server.
+const mockSchemaSDL = `
+"A session created through the sessions API."
+type Session {
+  id: ID!
+  name: String
+  createdAt: String!
+}
+
+input CreateSessionInput {
+  name: String
+}
+
+input DeleteSessionInput {
+  id: ID!
+}
+
+type CreateSessionsPayload {
+  sessions: [Session!]!
+}
+
+type DeleteSessionsPayload {
+  success: Boolean!
+  deletedIds: [ID!]!
+}
+
+enum SessionUpdateType {
+  CREATED
+  DELETED
+}
+
+type SessionUpdate {
+  type: SessionUpdateType!
+  session: Session!
+  "Increases by one for every update published by the server."
+  sequence: Int!
+}
+
+type Query {
+  sessions: [Session!]!
+  session(id: ID!): Session
+}
+
+type Mutation {
+  createSessions(input: [CreateSessionInput!]!): CreateSessionsPayload!
+  deleteSessions(input: [DeleteSessionInput!]!): DeleteSessionsPayload!
+}
+
+type Subscription {
+  "Created and deleted sessions, or only those of one session if id is given."
+  sessionUpdates(id: ID): SessionUpdate!
+}
+`
+
+// mockRequest is a GraphQL request as sent in a subscribe payload or an HTTP
+// request body.
+type mockRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// sessionStore holds the mock server's sessions and fans out updates to
+// active subscriptions.
+type sessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]map[string]interface{}
+	order     []string
+	sequence  int
+	listeners map[chan map[string]interface{}]bool
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions:  make(map[string]map[string]interface{}),
+		listeners: make(map[chan map[string]interface{}]bool),
+	}
+}
+
+func (s *sessionStore) create(name interface{}) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := map[string]interface{}{
+		"__typename": "Session",
+		"id":         uuid.NewString(),
+		"name":       name,
+		"createdAt":  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	s.sessions[session["id"].(string)] = session
+	s.order = append(s.order, session["id"].(string))
+	s.publish("CREATED", session)
+	return session
+}
+
+func (s *sessionStore) delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return false
+	}
+	delete(s.sessions, id)
+	for i, existing := range s.order {
+		if existing == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.publish("DELETED", session)
+	return true
+}
+
+func (s *sessionStore) get(id string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok {
+		return session
+	}
+	return nil
+}
+
+func (s *sessionStore) list() []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]interface{}, len(s.order))
+	for i, id := range s.order {
+		sessions[i] = s.sessions[id]
+	}
+	return sessions
+}
+
+// publish must be called with s.mu held so updates are delivered in sequence
+// order. Listeners that fall behind miss updates rather than block the store.
+func (s *sessionStore) publish(kind string, session map[string]interface{}) {
+	s.sequence++
+	update := map[string]interface{}{
+		"__typename": "SessionUpdate",
+		"type":       kind,
+		"session":    session,
+		"sequence":   s.sequence,
+	}
+	for ch := range s.listeners {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+func (s *sessionStore) listen() chan map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan map[string]interface{}, 256)
+	s.listeners[ch] = true
+	return ch
+}
+
+func (s *sessionStore) unlisten(ch chan map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, ch)
+}
+
+// mockExecution is a parsed and validated operation ready to run against the
+// mock server's store.
+type mockExecution struct {
+	schema    *Schema
+	op        *OperationDef
+	fragments map[string]*FragmentDef
+	variables map[string]interface{}
+}
+
+// prepareMock parses, selects and validates the operation of a request. Hash-only
+// persisted queries are resolved from, and full ones recorded in, persisted.
+// Without persisted, persisted queries are not supported.
+func prepareMock(schema *Schema, persisted *sync.Map, req mockRequest) (*mockExecution, GraphQLErrors) {
+	if pq, ok := req.Extensions["persistedQuery"].(map[string]interface{}); ok {
+		if persisted == nil {
+			return nil, GraphQLErrors{{Message: "PersistedQueryNotSupported", Extensions: map[string]interface{}{"code": "PERSISTED_QUERY_NOT_SUPPORTED"}}}
+		}
+		hash, _ := pq["sha256Hash"].(string)
+		if req.Query == "" {
+			query, ok := persisted.Load(hash)
+			if !ok {
+				return nil, GraphQLErrors{{Message: "PersistedQueryNotFound", Extensions: map[string]interface{}{"code": "PERSISTED_QUERY_NOT_FOUND"}}}
+			}
+			req.Query = query.(string)
+		} else if hash != queryHash(req.Query) {
+			return nil, GraphQLErrors{{Message: "provided sha does not match query", Extensions: map[string]interface{}{"code": "INTERNAL_SERVER_ERROR"}}}
+		} else {
+			persisted.Store(hash, req.Query)
+		}
+	}
+	if req.Query == "" {
+		return nil, GraphQLErrors{{Message: "request is missing a query"}}
+	}
+
+	doc, err := parseDocument(req.Query)
+	if err != nil {
+		return nil, GraphQLErrors{{Message: err.Error()}}
+	}
+	var op *OperationDef
+	for _, candidate := range doc.Operations {
+		if req.OperationName == "" || candidate.Name == req.OperationName {
+			if op != nil {
+				return nil, GraphQLErrors{{Message: "operationName is required when the document contains several operations"}}
+			}
+			op = candidate
+		}
+	}
+	if op == nil {
+		return nil, GraphQLErrors{{Message: fmt.Sprintf("unknown operation %q", req.OperationName)}}
+	}
+
+	errs := validateDocument(schema, doc)
+	vars, _ := json.Marshal(req.Variables)
+	errs = append(errs, validateVariables(schema, op, string(vars))...)
+	if len(errs) > 0 {
+		gqlErrs := make(GraphQLErrors, len(errs))
+		for i, err := range errs {
+			gqlErrs[i] = GraphQLError{Message: err.Error()}
+		}
+		return nil, gqlErrs
+	}
+
+	e := &mockExecution{schema: schema, op: op, fragments: make(map[string]*FragmentDef), variables: req.Variables}
+	if e.variables == nil {
+		e.variables = make(map[string]interface{})
+	}
+	for _, frag := range doc.Fragments {
+		e.fragments[frag.Name] = frag
+	}
+	for _, def := range op.VarDefs {
+		if _, ok := e.variables[def.Name]; !ok && def.Default != nil {
+			e.variables[def.Name] = e.value(def.Default)
+		}
+	}
+	return e, nil
+}
+
+// rootFields returns the operation's top-level fields, with fragments
+// flattened and @skip/@include applied.
+func (e *mockExecution) rootFields() []*FieldNode {
+	var fields []*FieldNode
+	var collect func(sels []Selection)
+	collect = func(sels []Selection) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *FieldNode:
+				if e.included(sel.Directives) {
+					fields = append(fields, sel)
+				}
+			case *FragmentSpread:
+				if frag, ok := e.fragments[sel.Name]; ok && e.included(sel.Directives) {
+					collect(frag.SelectionSet)
+				}
+			case *InlineFragment:
+				if e.included(sel.Directives) {
+					collect(sel.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(e.op.SelectionSet)
+	return fields
+}
+
+func (e *mockExecution) arguments(f *FieldNode) map[string]interface{} {
+	args := make(map[string]interface{}, len(f.Args))
+	for _, arg := range f.Args {
+		args[arg.Name] = e.value(arg.Value)
+	}
+	return args
+}
+
+func (e *mockExecution) value(v *Value) interface{} {
+	switch v.Kind {
+	case ValueVariable:
+		return e.variables[v.Raw]
+	case ValueInt:
+		n, _ := strconv.Atoi(v.Raw)
+		return n
+	case ValueFloat:
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case ValueBoolean:
+		return v.Raw == "true"
+	case ValueNull:
+		return nil
+	case ValueList:
+		list := make([]interface{}, len(v.List))
+		for i, item := range v.List {
+			list[i] = e.value(item)
+		}
+		return list
+	case ValueObject:
+		obj := make(map[string]interface{}, len(v.Fields))
+		for _, field := range v.Fields {
+			obj[field.Name] = e.value(field.Value)
+		}
+		return obj
+	}
+	return v.Raw
+}
+
+func (e *mockExecution) included(dirs []*Directive) bool {
+	for _, d := range dirs {
+		for _, arg := range d.Args {
+			if arg.Name != "if" {
+				continue
+			}
+			cond, _ := e.value(arg.Value).(bool)
+			if (d.Name == "skip" && cond) || (d.Name == "include" && !cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// project returns the parts of a resolved value selected by sels. Objects
+// are maps whose __typename decides which fragments apply.
+func (e *mockExecution) project(value interface{}, sels []Selection) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.project(item, sels)
+		}
+		return list
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		e.selectInto(out, v, sels)
+		return out
+	}
+	return value
+}
+
+func (e *mockExecution) selectInto(out, obj map[string]interface{}, sels []Selection) {
+	typename, _ := obj["__typename"].(string)
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *FieldNode:
+			if !e.included(sel.Directives) {
+				continue
+			}
+			val := obj[sel.Name]
+			if sel.SelectionSet != nil {
+				val = e.project(val, sel.SelectionSet)
+			}
+			existing, isObj := out[sel.ResponseKey()].(map[string]interface{})
+			if merged, ok := val.(map[string]interface{}); ok && isObj {
+				mergeObjects(existing, merged)
+				continue
+			}
+			out[sel.ResponseKey()] = val
+		case *FragmentSpread:
+			frag, ok := e.fragments[sel.Name]
+			if ok && e.included(sel.Directives) && e.applies(frag.TypeCondition, typename) {
+				e.selectInto(out, obj, frag.SelectionSet)
+			}
+		case *InlineFragment:
+			if e.included(sel.Directives) && e.applies(sel.TypeCondition, typename) {
+				e.selectInto(out, obj, sel.SelectionSet)
+			}
+		}
+	}
+}
+
+func (e *mockExecution) applies(condition, typename string) bool {
+	if condition == "" || typename == "" || condition == typename {
+		return true
+	}
+	t := e.schema.Type(condition)
+	if t == nil || t.Kind == "OBJECT" {
+		return false
+	}
+	for _, p := range t.PossibleTypes {
+		if p.Name == typename {
+			return true
+		}
+	}
+	return false
+}
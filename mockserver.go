@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// mockServer serves the sessions schema over graphql-transport-ws, legacy
+// graphql-ws and HTTP from in-memory state, for developing and testing
+// without the gateway.
+type mockServer struct {
+	schema        *Schema
+	introspection map[string]interface{}
+	store         *sessionStore
+	persisted     sync.Map
+	faults        *faultInjector
+	initTimeout   time.Duration
+	upgrader      websocket.Upgrader
+	// legacy makes every connection speak graphql-ws without selecting a
+	// subprotocol, like early subscriptions-transport-ws servers.
+	legacy bool
+	// noPersistedQueries answers persisted queries with
+	// PersistedQueryNotSupported.
+	noPersistedQueries bool
+}
+
+func newMockServer(initTimeout time.Duration, plan FaultPlan) (*mockServer, error) {
+	schema, err := parseSDL(mockSchemaSDL)
+	if err != nil {
+		return nil, fmt.Errorf("mock schema: %w", err)
+	}
+	// Introspection results are the schema's own JSON form, projected onto
+	// the selection like any other object.
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	var introspection map[string]interface{}
+	if err := json.Unmarshal(data, &introspection); err != nil {
+		return nil, err
+	}
+	return &mockServer{
+		schema:        schema,
+		introspection: introspection,
+		store:         newSessionStore(),
+		faults:        &faultInjector{plan: plan},
+		initTimeout:   initTimeout,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{subprotocolTransportWS, subprotocolGraphQLWS},
+			CheckOrigin:  func(r *http.Request) bool { return true },
+		},
+	}, nil
+}
+
+func runMockServer(args []string) {
+	fs := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	path := fs.String("path", "/graphql", "endpoint path for websocket and HTTP requests")
+	initTimeout := fs.Duration("init-timeout", 10*time.Second, "close connections that don't send connection_init in time (4408)")
+	faultsPath := fs.String("faults", "", "JSON fault plan with default and per-operation faults to inject")
+	adminPath := fs.String("admin-path", "/admin/faults", "admin endpoint to read (GET), replace (PUT) or clear (DELETE) the fault plan")
+	legacy := fs.Bool("legacy", false, "speak graphql-ws on every connection without selecting a subprotocol in the handshake")
+	persistedQueries := fs.Bool("persisted-queries", true, "support automatic persisted queries; false answers them with PersistedQueryNotSupported")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+
+	logOpts.setup()
+
+	var plan FaultPlan
+	if *faultsPath != "" {
+		var err error
+		if plan, err = loadFaultPlan(*faultsPath); err != nil {
+			log.Fatalf("Error loading fault plan: %v", err)
+		}
+	}
+	server, err := newMockServer(*initTimeout, plan)
+	if err != nil {
+		log.Fatalf("Error starting mock server: %v", err)
+	}
+	server.legacy = *legacy
+	server.noPersistedQueries = !*persistedQueries
+	mux := http.NewServeMux()
+	mux.Handle(*path, server)
+	mux.Handle(*adminPath, server.faults)
+	mux.Handle(*adminPath+"/{operation}", server.faults)
+	fmt.Printf("Mock server listening on ws://%s%s\n", *addr, *path)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebsocket(w, r)
+		return
+	}
+	s.serveRequest(w, r)
+}
+
+// persistedQueries returns the persisted query store, or nil if persisted
+// queries are not supported.
+func (s *mockServer) persistedQueries() *sync.Map {
+	if s.noPersistedQueries {
+		return nil
+	}
+	return &s.persisted
+}
+
+// faultsFor returns the faults for an operation, looked up by its name and
+// then by its first root field.
+func (s *mockServer) faultsFor(e *mockExecution) Faults {
+	names := []string{e.op.Name}
+	if fields := e.rootFields(); len(fields) > 0 {
+		names = append(names, fields[0].Name)
+	}
+	return s.faults.For(names...)
+}
+
+// execute runs a query or mutation and returns its result payload.
+func (s *mockServer) execute(e *mockExecution) map[string]interface{} {
+	root := e.schema.RootType(e.op.Type)
+	data := make(map[string]interface{})
+	var errs GraphQLErrors
+	for _, f := range e.rootFields() {
+		key := f.ResponseKey()
+		value, err := s.resolve(root, f.Name, e.arguments(f))
+		if err != nil {
+			errs = append(errs, GraphQLError{Message: err.Error(), Path: []interface{}{key}})
+			data[key] = nil
+			continue
+		}
+		if f.SelectionSet != nil {
+			value = e.project(value, f.SelectionSet)
+		}
+		if existing, ok := data[key].(map[string]interface{}); ok {
+			if merged, ok := value.(map[string]interface{}); ok {
+				mergeObjects(existing, merged)
+				continue
+			}
+		}
+		data[key] = value
+	}
+	result := map[string]interface{}{"data": data}
+	if len(errs) > 0 {
+		result["errors"] = errs
+	}
+	return result
+}
+
+func (s *mockServer) resolve(root *FullType, field string, args map[string]interface{}) (interface{}, error) {
+	switch field {
+	case "__typename":
+		return root.Name, nil
+	case "__schema":
+		return s.introspection, nil
+	case "__type":
+		types, _ := s.introspection["types"].([]interface{})
+		for _, t := range types {
+			if t.(map[string]interface{})["name"] == args["name"] {
+				return t, nil
+			}
+		}
+		return nil, nil
+	case "sessions":
+		return s.store.list(), nil
+	case "session":
+		id, _ := args["id"].(string)
+		return s.store.get(id), nil
+	case "createSessions":
+		inputs, _ := args["input"].([]interface{})
+		sessions := make([]interface{}, 0, len(inputs))
+		for _, input := range inputs {
+			fields, _ := input.(map[string]interface{})
+			sessions = append(sessions, s.store.create(fields["name"]))
+		}
+		return map[string]interface{}{"__typename": "CreateSessionsPayload", "sessions": sessions}, nil
+	case "deleteSessions":
+		inputs, _ := args["input"].([]interface{})
+		deleted := make([]interface{}, 0, len(inputs))
+		for _, input := range inputs {
+			fields, _ := input.(map[string]interface{})
+			if id, _ := fields["id"].(string); s.store.delete(id) {
+				deleted = append(deleted, id)
+			}
+		}
+		return map[string]interface{}{
+			"__typename": "DeleteSessionsPayload",
+			"success":    len(deleted) == len(inputs),
+			"deletedIds": deleted,
+		}, nil
+	}
+	return nil, fmt.Errorf("field %q is not implemented by the mock server", field)
+}
+
+// subscribe delivers sessionUpdates results to send until ctx is done,
+// calling listening once updates are being received. An update deleting the
+// watched session is the last one.
+func (s *mockServer) subscribe(ctx context.Context, e *mockExecution, send func(payload interface{}) error, listening func()) error {
+	fields := e.rootFields()
+	if len(fields) != 1 || fields[0].Name != "sessionUpdates" {
+		return fmt.Errorf("subscriptions must select exactly the sessionUpdates field")
+	}
+	f := fields[0]
+	watched, _ := e.arguments(f)["id"].(string)
+
+	updates := s.store.listen()
+	defer s.store.unlisten(updates)
+	listening()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update := <-updates:
+			session := update["session"].(map[string]interface{})
+			if watched != "" && session["id"] != watched {
+				continue
+			}
+			payload := map[string]interface{}{"data": map[string]interface{}{f.ResponseKey(): e.project(update, f.SelectionSet)}}
+			if err := send(payload); err != nil {
+				return err
+			}
+			if watched != "" && update["type"] == "DELETED" {
+				return nil
+			}
+		}
+	}
+}
+
+// serveRequest handles queries and mutations sent as HTTP GET or POST.
+func (s *mockServer) serveRequest(w http.ResponseWriter, r *http.Request) {
+	var req mockRequest
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodGet:
+		params := r.URL.Query()
+		req.Query = params.Get("query")
+		req.OperationName = params.Get("operationName")
+		for name, out := range map[string]*map[string]interface{}{"variables": &req.Variables, "extensions": &req.Extensions} {
+			if v := params.Get(name); v != "" {
+				if err := json.Unmarshal([]byte(v), out); err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	e, errs := prepareMock(s.schema, s.persistedQueries(), req)
+	switch {
+	case errs != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+		return
+	case e.op.Type == OperationSubscription:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": GraphQLErrors{{Message: "subscriptions are only served over the websocket"}}})
+		return
+	case e.op.Type == OperationMutation && r.Method == http.MethodGet:
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": GraphQLErrors{{Message: "mutations cannot be sent with GET"}}})
+		return
+	}
+	slog.Info("HTTP request", "method", r.Method, "type", e.op.Type)
+
+	// Only latency and errors apply over HTTP; the other faults concern
+	// websocket frames.
+	faults := s.faultsFor(e)
+	result := s.execute(e)
+	if chance(faults.ErrorRate) {
+		result = injectedError()
+	}
+	if faults.delay(r.Context()) != nil {
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// mockConn is one websocket connection to the mock server. Messages are
+// handled in graphql-transport-ws terms and translated by proto.
+type mockConn struct {
+	server  *mockServer
+	conn    *websocket.Conn
+	proto   Protocol
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	acked  bool
+	closed bool
+	ops    map[string]context.CancelFunc
+}
+
+func (s *mockServer) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := s.upgrader
+	if s.legacy {
+		upgrader.Subprotocols = nil
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("Websocket upgrade failed", "err", err)
+		return
+	}
+	c := &mockConn{server: s, conn: conn, ops: make(map[string]context.CancelFunc)}
+	slog.Info("Connection", "remote", r.RemoteAddr, "subprotocol", conn.Subprotocol())
+	switch {
+	case s.legacy:
+		c.proto = legacyGraphQLWS{}
+	case conn.Subprotocol() != "":
+		c.proto, _ = protocolFor(conn.Subprotocol())
+	default:
+		c.close(4406, "Subprotocol not acceptable")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	initTimer := time.AfterFunc(s.initTimeout, func() {
+		c.mu.Lock()
+		acked := c.acked
+		c.mu.Unlock()
+		if !acked {
+			c.close(4408, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			slog.Info("Connection closed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		var msg GraphQLMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			c.close(4400, "Invalid message received")
+			return
+		}
+		if !c.handle(ctx, c.proto.ServerIncoming(msg)) {
+			return
+		}
+	}
+}
+
+// handle processes one client message and reports whether the connection is
+// still open.
+func (c *mockConn) handle(ctx context.Context, msg GraphQLMessage) bool {
+	c.mu.Lock()
+	acked := c.acked
+	c.mu.Unlock()
+
+	switch msg.Type {
+	case "connection_init":
+		if acked {
+			c.close(4429, "Too many initialisation requests")
+			return false
+		}
+		c.mu.Lock()
+		c.acked = true
+		c.mu.Unlock()
+		c.write(GraphQLMessage{Type: "connection_ack"})
+		if !c.proto.SupportsPing() {
+			c.write(GraphQLMessage{Type: "ka"})
+		}
+	case "ping":
+		if c.server.faults.For().StallPings {
+			slog.Info("Stalling ping")
+			break
+		}
+		c.write(GraphQLMessage{Type: "pong", Payload: msg.Payload})
+	case "pong":
+	case "subscribe":
+		if !acked {
+			c.close(4401, "Unauthorized")
+			return false
+		}
+		if msg.ID == "" {
+			c.close(4400, "Invalid message received")
+			return false
+		}
+		c.mu.Lock()
+		_, exists := c.ops[msg.ID]
+		c.mu.Unlock()
+		if exists {
+			c.close(4409, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+			return false
+		}
+		opCtx, cancel := context.WithCancel(ctx)
+		c.mu.Lock()
+		c.ops[msg.ID] = cancel
+		c.mu.Unlock()
+		// Wait until the operation is under way, so that a subscription
+		// sees the updates of mutations sent after it.
+		ready := make(chan struct{})
+		go c.run(opCtx, msg, ready)
+		<-ready
+	case "complete":
+		c.mu.Lock()
+		cancel, ok := c.ops[msg.ID]
+		delete(c.ops, msg.ID)
+		c.mu.Unlock()
+		if ok {
+			cancel()
+		}
+	case "connection_terminate":
+		c.close(1000, "Normal closure")
+		return false
+	default:
+		c.close(4400, "Invalid message received")
+		return false
+	}
+	return true
+}
+
+// run executes one subscribe message and sends its results, closing ready
+// once a subscription listens for updates or another operation is prepared.
+// Operations the client completed end without a complete message of their
+// own.
+func (c *mockConn) run(ctx context.Context, msg GraphQLMessage, ready chan struct{}) {
+	var once sync.Once
+	started := func() { once.Do(func() { close(ready) }) }
+	defer started()
+	var req mockRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		c.sendErrors(msg.ID, GraphQLErrors{{Message: "invalid subscribe payload: " + err.Error()}})
+		return
+	}
+	e, errs := prepareMock(c.server.schema, c.server.persistedQueries(), req)
+	if errs != nil {
+		c.sendErrors(msg.ID, errs)
+		return
+	}
+	slog.Info("Operation", "id", msg.ID, "type", e.op.Type)
+
+	faults := c.server.faultsFor(e)
+	if faults.shouldClose() {
+		c.close(faults.CloseCode, closeReasons[faults.CloseCode])
+		return
+	}
+	send := func(payload interface{}) error {
+		if chance(faults.ErrorRate) {
+			payload = injectedError()
+		}
+		if err := faults.delay(ctx); err != nil {
+			return err
+		}
+		if chance(faults.DropRate) {
+			slog.Info("Dropping next", "id", msg.ID)
+			return nil
+		}
+		b, _ := json.Marshal(payload)
+		next := GraphQLMessage{ID: msg.ID, Type: "next", Payload: b}
+		if err := c.write(next); err != nil {
+			return err
+		}
+		if chance(faults.DuplicateRate) {
+			slog.Info("Duplicating next", "id", msg.ID)
+			return c.write(next)
+		}
+		return nil
+	}
+	complete := func() {
+		if faults.delay(ctx) == nil && c.finish(msg.ID) {
+			c.write(GraphQLMessage{ID: msg.ID, Type: "complete"})
+		}
+	}
+
+	if e.op.Type != OperationSubscription {
+		started()
+	}
+	switch {
+	case e.op.Type == OperationSubscription:
+		if err := c.server.subscribe(ctx, e, send, started); err != nil {
+			if ctx.Err() == nil {
+				c.sendErrors(msg.ID, GraphQLErrors{{Message: err.Error()}})
+			}
+			return
+		}
+		complete()
+	case faults.Reorder:
+		// The operation stays registered until its result is sent, since
+		// finishing it would cancel ctx.
+		result := c.server.execute(e)
+		slog.Info("Sending complete before next", "id", msg.ID)
+		if faults.delay(ctx) == nil {
+			c.write(GraphQLMessage{ID: msg.ID, Type: "complete"})
+			send(result)
+		}
+		c.finish(msg.ID)
+	default:
+		if err := send(c.server.execute(e)); err != nil {
+			return
+		}
+		complete()
+	}
+}
+
+func (c *mockConn) sendErrors(id string, errs GraphQLErrors) {
+	if c.finish(id) {
+		b, _ := json.Marshal(errs)
+		c.write(GraphQLMessage{ID: id, Type: "error", Payload: b})
+	}
+}
+
+// finish deregisters an operation and reports whether it was still active,
+// that is, not completed by the client.
+func (c *mockConn) finish(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancel, ok := c.ops[id]
+	if ok {
+		cancel()
+		delete(c.ops, id)
+	}
+	return ok
+}
+
+func (c *mockConn) write(msg GraphQLMessage) error {
+	msg, ok := c.proto.ServerOutgoing(msg)
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// close ends the connection with a close code.
+func (c *mockConn) close(code int, reason string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	slog.Info("Closing connection", "code", code, "reason", reason)
+	c.writeMu.Lock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	c.conn.Close()
+}
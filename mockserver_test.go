@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wireConn speaks one subprotocol to the mock at the message level.
+type wireConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func (c *wireConn) send(msg GraphQLMessage) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next message, skipping keep-alives.
+func (c *wireConn) read() GraphQLMessage {
+	c.t.Helper()
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg GraphQLMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			c.t.Fatal(err)
+		}
+		if msg.Type != "ka" {
+			return msg
+		}
+	}
+}
+
+// start sends a start message for query.
+func (c *wireConn) start(id, start, query string, variables interface{}) {
+	c.t.Helper()
+	payload, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	c.send(GraphQLMessage{ID: id, Type: start, Payload: payload})
+}
+
+// results reads messages until the operations ids all complete, returning
+// the result payloads each got.
+func (c *wireConn) results(next string, ids ...string) map[string][]string {
+	c.t.Helper()
+	results := make(map[string][]string)
+	running := make(map[string]bool)
+	for _, id := range ids {
+		running[id] = true
+	}
+	for len(running) > 0 {
+		msg := c.read()
+		switch {
+		case !running[msg.ID]:
+			c.t.Fatalf("got %s for operation %q, want one for %v", msg.Type, msg.ID, ids)
+		case msg.Type == next:
+			results[msg.ID] = append(results[msg.ID], string(msg.Payload))
+		case msg.Type == "complete":
+			delete(running, msg.ID)
+		default:
+			c.t.Fatalf("operation %s: got %s: %s", msg.ID, msg.Type, msg.Payload)
+		}
+	}
+	return results
+}
+
+func TestMockServerSubprotocols(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		start, next string
+	}{
+		{subprotocol: subprotocolTransportWS, start: "subscribe", next: "next"},
+		{subprotocol: subprotocolGraphQLWS, start: "start", next: "data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subprotocol, func(t *testing.T) {
+			headers := http.Header{"Sec-WebSocket-Protocol": {tt.subprotocol}}
+			conn, _, err := websocket.DefaultDialer.Dial(startMockServer(t, FaultPlan{}), headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if conn.Subprotocol() != tt.subprotocol {
+				t.Fatalf("server selected %q, want %s", conn.Subprotocol(), tt.subprotocol)
+			}
+			c := &wireConn{t: t, conn: conn}
+
+			c.send(GraphQLMessage{Type: "connection_init"})
+			if msg := c.read(); msg.Type != "connection_ack" {
+				t.Fatalf("got %s, want connection_ack", msg.Type)
+			}
+
+			c.start("1", tt.start, gqlCreateSessionDocument, map[string]interface{}{"input": []map[string]string{{"name": "wire"}}})
+			results := c.results(tt.next, "1")["1"]
+			var created GQLCreateSessionResponse
+			if len(results) != 1 || decodeResult(json.RawMessage(results[0]), &created) != nil || len(created.CreateSessions.Sessions) != 1 {
+				t.Fatalf("createSession: got %v", results)
+			}
+			id := created.CreateSessions.Sessions[0].ID
+
+			c.start("2", tt.start, `query { sessions { id } }`, nil)
+			results = c.results(tt.next, "2")["2"]
+			want := fmt.Sprintf(`{"data":{"sessions":[{"id":%q}]}}`, id)
+			if len(results) != 1 || results[0] != want {
+				t.Fatalf("sessions: got %v, want %s", results, want)
+			}
+
+			// The subscription ends once the session it watches is deleted.
+			c.start("3", tt.start, `subscription ($id: ID) { sessionUpdates(id: $id) { type } }`, map[string]string{"id": id})
+			c.start("4", tt.start, gqlDeleteSessionDocument, map[string]interface{}{"input": []map[string]string{{"id": id}}})
+			updates := c.results(tt.next, "3", "4")["3"]
+			if len(updates) != 1 || updates[0] != `{"data":{"sessionUpdates":{"type":"DELETED"}}}` {
+				t.Errorf("subscription: got %v, want one DELETED update", updates)
+			}
+		})
+	}
+}
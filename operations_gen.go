@@ -0,0 +1,88 @@
+// Code generated by "go run . generate"; DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const gqlCreateSessionDocument = `mutation createSession($input: [CreateSessionInput!]!) {
+  createSessions(input: $input) {
+    sessions {
+      id
+      name
+    }
+  }
+}`
+
+type GQLCreateSessionVariables struct {
+	Input []GQLCreateSessionInput `json:"input"`
+}
+
+// GQLCreateSession executes the createSession mutation.
+func GQLCreateSession(ctx context.Context, client Executor, vars GQLCreateSessionVariables) (*GQLCreateSessionResponse, error) {
+	variables, err := json.Marshal(vars)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := client.Execute(ctx, OperationMutation, "createSession", gqlCreateSessionDocument, string(variables))
+	if err != nil {
+		return nil, err
+	}
+	var resp GQLCreateSessionResponse
+	return &resp, decodeResult(payload, &resp)
+}
+
+const gqlDeleteSessionDocument = `mutation deleteSession($input: [DeleteSessionInput!]!) {
+  deleteSessions(input: $input) {
+    success
+  }
+}`
+
+type GQLDeleteSessionVariables struct {
+	Input []GQLDeleteSessionInput `json:"input"`
+}
+
+// GQLDeleteSession executes the deleteSession mutation.
+func GQLDeleteSession(ctx context.Context, client Executor, vars GQLDeleteSessionVariables) (*GQLDeleteSessionResponse, error) {
+	variables, err := json.Marshal(vars)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := client.Execute(ctx, OperationMutation, "deleteSession", gqlDeleteSessionDocument, string(variables))
+	if err != nil {
+		return nil, err
+	}
+	var resp GQLDeleteSessionResponse
+	return &resp, decodeResult(payload, &resp)
+}
+
+type GQLCreateSessionResponseCreateSessionsSessions struct {
+	ID   string  `json:"id"`
+	Name *string `json:"name"`
+}
+
+type GQLCreateSessionResponseCreateSessions struct {
+	Sessions []GQLCreateSessionResponseCreateSessionsSessions `json:"sessions"`
+}
+
+type GQLCreateSessionResponse struct {
+	CreateSessions GQLCreateSessionResponseCreateSessions `json:"createSessions"`
+}
+
+type GQLDeleteSessionResponseDeleteSessions struct {
+	Success bool `json:"success"`
+}
+
+type GQLDeleteSessionResponse struct {
+	DeleteSessions GQLDeleteSessionResponseDeleteSessions `json:"deleteSessions"`
+}
+
+type GQLCreateSessionInput struct {
+	Name *string `json:"name,omitempty"`
+}
+
+type GQLDeleteSessionInput struct {
+	ID string `json:"id"`
+}
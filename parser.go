@@ -0,0 +1,838 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Location is a 1-based line and column inside a GraphQL document.
+type Location struct {
+	Line   int
+	Column int
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%d:%d", l.Line, l.Column)
+}
+
+type Document struct {
+	Operations []*OperationDef
+	Fragments  []*FragmentDef
+}
+
+type OperationDef struct {
+	Type         OperationType
+	Name         string
+	VarDefs      []*VariableDef
+	Directives   []*Directive
+	SelectionSet []Selection
+	Loc          Location
+	// Source is the definition's text as written in the document.
+	Source string
+}
+
+type FragmentDef struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Loc           Location
+	Source        string
+}
+
+type VariableDef struct {
+	Name    string
+	Type    *TypeNode
+	Default *Value
+	Loc     Location
+}
+
+// TypeNode is a type reference such as `[CreateSessionInput!]!`. Elem is set
+// for list types, Name for named types.
+type TypeNode struct {
+	Name    string
+	Elem    *TypeNode
+	NonNull bool
+	Loc     Location
+}
+
+func (t *TypeNode) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+type Selection interface {
+	Location() Location
+}
+
+type FieldNode struct {
+	Alias        string
+	Name         string
+	Args         []*Argument
+	Directives   []*Directive
+	SelectionSet []Selection
+	Loc          Location
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+	Loc        Location
+}
+
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Loc           Location
+}
+
+func (f *FieldNode) Location() Location      { return f.Loc }
+func (f *FragmentSpread) Location() Location { return f.Loc }
+func (f *InlineFragment) Location() Location { return f.Loc }
+
+// ResponseKey is the key the field is returned under.
+func (f *FieldNode) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type Directive struct {
+	Name string
+	Args []*Argument
+	Loc  Location
+}
+
+type Argument struct {
+	Name  string
+	Value *Value
+	Loc   Location
+}
+
+type ValueKind int
+
+const (
+	ValueVariable ValueKind = iota
+	ValueInt
+	ValueFloat
+	ValueString
+	ValueBoolean
+	ValueNull
+	ValueEnum
+	ValueList
+	ValueObject
+)
+
+type Value struct {
+	Kind   ValueKind
+	Raw    string
+	List   []*Value
+	Fields []*ObjectField
+	Loc    Location
+}
+
+type ObjectField struct {
+	Name  string
+	Value *Value
+	Loc   Location
+}
+
+// SyntaxError is returned by parseDocument with the position of the
+// offending token.
+type SyntaxError struct {
+	Loc     Location
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %s: %s", e.Loc, e.Message)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+	// start and end are rune offsets into the source.
+	start, end int
+}
+
+type lexer struct {
+	src  []rune
+	pos  int
+	line int
+	col  int
+}
+
+func (l *lexer) errorf(loc Location, format string, args ...interface{}) error {
+	return &SyntaxError{Loc: loc, Message: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		r := l.src[l.pos]
+		if r == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance()
+			}
+			continue
+		}
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == ',' || r == '\ufeff' {
+			l.advance()
+			continue
+		}
+		break
+	}
+	loc := Location{Line: l.line, Column: l.col}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, loc: loc, start: start, end: start}, nil
+	}
+
+	tok, err := l.token(loc)
+	tok.start, tok.end = start, l.pos
+	return tok, err
+}
+
+func (l *lexer) token(loc Location) (token, error) {
+	r := l.src[l.pos]
+	switch {
+	case strings.ContainsRune("!$&()=:@[]{}|", r):
+		l.advance()
+		return token{kind: tokPunct, value: string(r), loc: loc}, nil
+	case r == '.':
+		if l.peek(1) != '.' || l.peek(2) != '.' {
+			return token{}, l.errorf(loc, "unexpected %q", r)
+		}
+		l.advance()
+		l.advance()
+		l.advance()
+		return token{kind: tokPunct, value: "...", loc: loc}, nil
+	case r == '_' || isLetter(r):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.advance()
+		}
+		return token{kind: tokName, value: string(l.src[start:l.pos]), loc: loc}, nil
+	case r == '-' || isDigit(r):
+		return l.number(loc)
+	case r == '"':
+		if l.peek(1) == '"' && l.peek(2) == '"' {
+			return l.blockString(loc)
+		}
+		return l.string(loc)
+	}
+	return token{}, l.errorf(loc, "unexpected character %q", r)
+}
+
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.advance()
+	}
+	if !isDigit(l.peek(0)) {
+		return token{}, l.errorf(loc, "invalid number")
+	}
+	for isDigit(l.peek(0)) {
+		l.advance()
+	}
+	if l.peek(0) == '.' {
+		kind = tokFloat
+		l.advance()
+		if !isDigit(l.peek(0)) {
+			return token{}, l.errorf(loc, "invalid number")
+		}
+		for isDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+	if l.peek(0) == 'e' || l.peek(0) == 'E' {
+		kind = tokFloat
+		l.advance()
+		if l.peek(0) == '+' || l.peek(0) == '-' {
+			l.advance()
+		}
+		if !isDigit(l.peek(0)) {
+			return token{}, l.errorf(loc, "invalid number")
+		}
+		for isDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+	return token{kind: kind, value: string(l.src[start:l.pos]), loc: loc}, nil
+}
+
+func (l *lexer) string(loc Location) (token, error) {
+	l.advance()
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return token{}, l.errorf(loc, "unterminated string")
+		}
+		r := l.advance()
+		if r == '"' {
+			return token{kind: tokString, value: sb.String(), loc: loc}, nil
+		}
+		if r != '\\' {
+			sb.WriteRune(r)
+			continue
+		}
+		if l.pos >= len(l.src) {
+			return token{}, l.errorf(loc, "unterminated string")
+		}
+		esc := l.advance()
+		switch esc {
+		case '"', '\\', '/':
+			sb.WriteRune(esc)
+		case 'b':
+			sb.WriteRune('\b')
+		case 'f':
+			sb.WriteRune('\f')
+		case 'n':
+			sb.WriteRune('\n')
+		case 'r':
+			sb.WriteRune('\r')
+		case 't':
+			sb.WriteRune('\t')
+		case 'u':
+			var code rune
+			for i := 0; i < 4; i++ {
+				if l.pos >= len(l.src) {
+					return token{}, l.errorf(loc, "invalid unicode escape")
+				}
+				h := l.advance()
+				switch {
+				case isDigit(h):
+					code = code*16 + (h - '0')
+				case h >= 'a' && h <= 'f':
+					code = code*16 + (h - 'a' + 10)
+				case h >= 'A' && h <= 'F':
+					code = code*16 + (h - 'A' + 10)
+				default:
+					return token{}, l.errorf(loc, "invalid unicode escape")
+				}
+			}
+			sb.WriteRune(code)
+		default:
+			return token{}, l.errorf(loc, "invalid escape \\%c", esc)
+		}
+	}
+}
+
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.advance()
+	l.advance()
+	l.advance()
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return token{}, l.errorf(loc, "unterminated block string")
+		}
+		if l.peek(0) == '"' && l.peek(1) == '"' && l.peek(2) == '"' {
+			l.advance()
+			l.advance()
+			l.advance()
+			return token{kind: tokString, value: sb.String(), loc: loc}, nil
+		}
+		if l.peek(0) == '\\' && l.peek(1) == '"' && l.peek(2) == '"' && l.peek(3) == '"' {
+			l.advance()
+			sb.WriteString(`"""`)
+			l.advance()
+			l.advance()
+			l.advance()
+			continue
+		}
+		sb.WriteRune(l.advance())
+	}
+}
+
+func isLetter(r rune) bool { return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') }
+func isDigit(r rune) bool  { return r >= '0' && r <= '9' }
+
+type parser struct {
+	lex *lexer
+	tok token
+	// lastEnd is the end offset of the previously consumed token.
+	lastEnd int
+}
+
+// parseDocument parses a GraphQL executable document (operations and
+// fragments). Type system definitions are rejected.
+func parseDocument(src string) (*Document, error) {
+	p := &parser{lex: &lexer{src: []rune(src), line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &Document{}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.is("{"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.tok.kind == tokName && p.tok.value == "fragment":
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments = append(doc.Fragments, frag)
+		case p.tok.kind == tokName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, &SyntaxError{Loc: p.tok.loc, Message: "document contains no operations"}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	p.lastEnd = p.tok.end
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) is(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return &SyntaxError{Loc: p.tok.loc, Message: "unexpected end of document"}
+	}
+	return &SyntaxError{Loc: p.tok.loc, Message: fmt.Sprintf("unexpected %q", p.tok.value)}
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.is(punct) {
+		if p.tok.kind == tokEOF {
+			return &SyntaxError{Loc: p.tok.loc, Message: fmt.Sprintf("expected %q, found end of document", punct)}
+		}
+		return &SyntaxError{Loc: p.tok.loc, Message: fmt.Sprintf("expected %q, found %q", punct, p.tok.value)}
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		if p.tok.kind == tokEOF {
+			return "", &SyntaxError{Loc: p.tok.loc, Message: "expected name, found end of document"}
+		}
+		return "", &SyntaxError{Loc: p.tok.loc, Message: fmt.Sprintf("expected name, found %q", p.tok.value)}
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) source(start int) string {
+	return string(p.lex.src[start:p.lastEnd])
+}
+
+func (p *parser) operation() (*OperationDef, error) {
+	op := &OperationDef{Type: OperationQuery, Loc: p.tok.loc}
+	start := p.tok.start
+	if p.is("{") {
+		sel, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		op.SelectionSet = sel
+		op.Source = p.source(start)
+		return op, nil
+	}
+	op.Type = OperationType(p.tok.value)
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.Name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("(") {
+		defs, err := p.variableDefs()
+		if err != nil {
+			return nil, err
+		}
+		op.VarDefs = defs
+	}
+	dirs, err := p.directives()
+	if err != nil {
+		return nil, err
+	}
+	op.Directives = dirs
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.SelectionSet = sel
+	op.Source = p.source(start)
+	return op, nil
+}
+
+func (p *parser) fragment() (*FragmentDef, error) {
+	frag := &FragmentDef{Loc: p.tok.loc}
+	start := p.tok.start
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	frag.Name = name
+	if p.tok.kind != tokName || p.tok.value != "on" {
+		return nil, &SyntaxError{Loc: p.tok.loc, Message: "expected \"on\" after fragment name"}
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if frag.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if frag.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if frag.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	frag.Source = p.source(start)
+	return frag, nil
+}
+
+func (p *parser) variableDefs() ([]*VariableDef, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var defs []*VariableDef
+	for !p.is(")") {
+		def := &VariableDef{Loc: p.tok.loc}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		def.Name = name
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if def.Type, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if p.is("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if def.Default, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.directives(); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, p.advance()
+}
+
+func (p *parser) typeRef() (*TypeNode, error) {
+	t := &TypeNode{Loc: p.tok.loc}
+	if p.is("[") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		t.Elem = elem
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t.Name = name
+	}
+	if p.is("!") {
+		t.NonNull = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var dirs []*Directive
+	for p.is("@") {
+		d := &Directive{Loc: p.tok.loc}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d.Name = name
+		if p.is("(") {
+			if d.Args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []Selection
+	for !p.is("}") {
+		if p.tok.kind == tokEOF {
+			return nil, p.unexpected()
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, &SyntaxError{Loc: p.tok.loc, Message: "selection set cannot be empty"}
+	}
+	return sels, p.advance()
+}
+
+func (p *parser) selection() (Selection, error) {
+	loc := p.tok.loc
+	if p.is("...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			spread := &FragmentSpread{Name: p.tok.value, Loc: loc}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			dirs, err := p.directives()
+			if err != nil {
+				return nil, err
+			}
+			spread.Directives = dirs
+			return spread, nil
+		}
+		frag := &InlineFragment{Loc: loc}
+		if p.tok.kind == tokName {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			frag.TypeCondition = name
+		}
+		var err error
+		if frag.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if frag.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+		return frag, nil
+	}
+
+	field := &FieldNode{Loc: loc}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.is(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		field.Alias = name
+		if name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+	if p.is("(") {
+		if field.Args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	if field.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.is("{") {
+		if field.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) arguments() ([]*Argument, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []*Argument
+	for !p.is(")") {
+		arg := &Argument{Loc: p.tok.loc}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		arg.Name = name
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.Value, err = p.value(false); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return nil, &SyntaxError{Loc: p.tok.loc, Message: "argument list cannot be empty"}
+	}
+	return args, p.advance()
+}
+
+func (p *parser) value(constant bool) (*Value, error) {
+	v := &Value{Loc: p.tok.loc, Raw: p.tok.value}
+	switch p.tok.kind {
+	case tokInt:
+		v.Kind = ValueInt
+	case tokFloat:
+		v.Kind = ValueFloat
+	case tokString:
+		v.Kind = ValueString
+	case tokName:
+		switch p.tok.value {
+		case "true", "false":
+			v.Kind = ValueBoolean
+		case "null":
+			v.Kind = ValueNull
+		default:
+			v.Kind = ValueEnum
+		}
+	case tokPunct:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				return nil, &SyntaxError{Loc: p.tok.loc, Message: "variables are not allowed in default values"}
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			v.Kind = ValueVariable
+			v.Raw = name
+			return v, nil
+		case "[":
+			v.Kind = ValueList
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			for !p.is("]") {
+				if p.tok.kind == tokEOF {
+					return nil, p.unexpected()
+				}
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				v.List = append(v.List, item)
+			}
+			return v, p.advance()
+		case "{":
+			v.Kind = ValueObject
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			for !p.is("}") {
+				field := &ObjectField{Loc: p.tok.loc}
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				field.Name = name
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if field.Value, err = p.value(constant); err != nil {
+					return nil, err
+				}
+				v.Fields = append(v.Fields, field)
+			}
+			return v, p.advance()
+		default:
+			return nil, p.unexpected()
+		}
+	default:
+		return nil, p.unexpected()
+	}
+	return v, p.advance()
+}
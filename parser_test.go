@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseDocument(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		check func(t *testing.T, doc *Document)
+	}{
+		{
+			name: "anonymous query",
+			src:  `{ sessions { id } }`,
+			check: func(t *testing.T, doc *Document) {
+				op := doc.Operations[0]
+				if op.Type != OperationQuery || op.Name != "" {
+					t.Errorf("got %s %q, want an anonymous query", op.Type, op.Name)
+				}
+				f := op.SelectionSet[0].(*FieldNode)
+				if f.Name != "sessions" || len(f.SelectionSet) != 1 {
+					t.Errorf("got field %q with %d selections", f.Name, len(f.SelectionSet))
+				}
+			},
+		},
+		{
+			name: "variables, aliases and arguments",
+			src:  "mutation create($input: [CreateSessionInput!]! = [{name: \"a\"}]) {\n  made: createSessions(input: $input) { sessions { id } }\n}",
+			check: func(t *testing.T, doc *Document) {
+				op := doc.Operations[0]
+				if op.Type != OperationMutation || op.Name != "create" {
+					t.Errorf("got %s %q", op.Type, op.Name)
+				}
+				def := op.VarDefs[0]
+				if def.Name != "input" || def.Type.String() != "[CreateSessionInput!]!" {
+					t.Errorf("got variable $%s: %s", def.Name, def.Type)
+				}
+				if def.Default == nil || def.Default.Kind != ValueList || def.Default.List[0].Kind != ValueObject {
+					t.Errorf("got default %+v, want a list of objects", def.Default)
+				}
+				f := op.SelectionSet[0].(*FieldNode)
+				if f.ResponseKey() != "made" || f.Name != "createSessions" {
+					t.Errorf("got %s: %s", f.ResponseKey(), f.Name)
+				}
+				if arg := f.Args[0]; arg.Name != "input" || arg.Value.Kind != ValueVariable || arg.Value.Raw != "input" {
+					t.Errorf("got argument %s: %+v", arg.Name, arg.Value)
+				}
+				if f.Loc != (Location{Line: 2, Column: 3}) {
+					t.Errorf("got field at %s, want 2:3", f.Loc)
+				}
+			},
+		},
+		{
+			name: "fragments and directives",
+			src:  `subscription s { sessionUpdates { ...update @include(if: true) ... on SessionUpdate @defer { sequence } } } fragment update on SessionUpdate { type }`,
+			check: func(t *testing.T, doc *Document) {
+				if len(doc.Fragments) != 1 || doc.Fragments[0].TypeCondition != "SessionUpdate" {
+					t.Fatalf("got fragments %+v", doc.Fragments)
+				}
+				sels := doc.Operations[0].SelectionSet[0].(*FieldNode).SelectionSet
+				spread := sels[0].(*FragmentSpread)
+				if spread.Name != "update" || spread.Directives[0].Name != "include" {
+					t.Errorf("got spread %+v", spread)
+				}
+				inline := sels[1].(*InlineFragment)
+				if inline.TypeCondition != "SessionUpdate" || inline.Directives[0].Name != "defer" {
+					t.Errorf("got inline fragment %+v", inline)
+				}
+			},
+		},
+		{
+			name: "values",
+			src:  `{ f(i: -12, fl: 1.5e3, s: "a\"é", b: """ block """, n: null, e: RED, t: false) }`,
+			check: func(t *testing.T, doc *Document) {
+				want := []struct {
+					kind ValueKind
+					raw  string
+				}{
+					{ValueInt, "-12"}, {ValueFloat, "1.5e3"}, {ValueString, "a\"é"}, {ValueString, " block "},
+					{ValueNull, "null"}, {ValueEnum, "RED"}, {ValueBoolean, "false"},
+				}
+				args := doc.Operations[0].SelectionSet[0].(*FieldNode).Args
+				if len(args) != len(want) {
+					t.Fatalf("got %d arguments, want %d", len(args), len(want))
+				}
+				for i, w := range want {
+					if args[i].Value.Kind != w.kind || args[i].Value.Raw != w.raw {
+						t.Errorf("argument %s: got %d %q, want %d %q", args[i].Name, args[i].Value.Kind, args[i].Value.Raw, w.kind, w.raw)
+					}
+				}
+			},
+		},
+		{
+			name: "source of each definition",
+			src:  "# comment\nquery a { x }\nquery b { y }",
+			check: func(t *testing.T, doc *Document) {
+				if got := doc.Operations[1].Source; got != "query b { y }" {
+					t.Errorf("got source %q", got)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseDocument(tt.src)
+			if err != nil {
+				t.Fatalf("parseDocument: %v", err)
+			}
+			tt.check(t, doc)
+		})
+	}
+}
+
+func TestParseDocumentErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		loc  Location
+		want string
+	}{
+		{src: ``, loc: Location{1, 1}, want: "no operations"},
+		{src: `{ sessions { id }`, loc: Location{1, 18}, want: "end of document"},
+		{src: "{\n  f(a: $) }", loc: Location{2, 9}, want: "expected name"},
+		{src: `{ f(a: "unterminated) }`, loc: Location{1, 8}, want: "unterminated string"},
+		{src: `type Query { a: Int }`, loc: Location{1, 1}, want: "type"},
+		{src: `query ($a: Int = $b) { f }`, loc: Location{1, 18}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := parseDocument(tt.src)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got %v, want a syntax error", err)
+			}
+			if syntaxErr.Loc != tt.loc || !strings.Contains(syntaxErr.Message, tt.want) {
+				t.Errorf("got %v, want an error at %s mentioning %q", err, tt.loc, tt.want)
+			}
+		})
+	}
+}
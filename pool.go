@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// pooledConn is one connection of a Pool.
+type pooledConn struct {
+	client   *Client
+	inFlight int
+	rtt      time.Duration
+	healthy  bool
+	// failedPings counts the pings in a row that failed.
+	failedPings int
+}
+
+// PoolOptions tunes a Pool. Zero MaxRTT, HealthInterval and MaxFailedPings
+// take their defaults, 2s, 10s and 3.
+type PoolOptions struct {
+	// MaxInFlight caps the operations in flight on one connection, if set.
+	MaxInFlight int
+	// MaxRTT is the ping round trip time above which a connection is
+	// unhealthy.
+	MaxRTT time.Duration
+	// HealthInterval is how often connections are pinged.
+	HealthInterval time.Duration
+	// MaxFailedPings is how many pings in a row may fail before a connection
+	// is closed and replaced.
+	MaxFailedPings int
+}
+
+// Pool spreads operations over several websocket connections. Each
+// operation goes to the least loaded healthy connection, up to MaxInFlight
+// operations per connection, and waits when all are at the cap.
+// Connections are pinged every HealthInterval and count as unhealthy while
+// their round trip time exceeds MaxRTT; lost connections, and ones that fail
+// MaxFailedPings pings in a row, are replaced in the background.
+type Pool struct {
+	url         string
+	protocol    string
+	persisted   *PersistedQueries
+	onUnhandled func(message []byte)
+	opts        PoolOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// watchers counts the goroutines checking and replacing connections.
+	watchers sync.WaitGroup
+
+	mu    sync.Mutex
+	conns []*pooledConn
+	// changed is closed and replaced whenever a connection frees up or
+	// joins the pool.
+	changed chan struct{}
+}
+
+// NewPool opens size connections, failing if any of them can't be opened.
+// Every connection sends operations as persisted queries if persisted is
+// not nil.
+func NewPool(url, protocol string, size int, opts PoolOptions, persisted *PersistedQueries, onUnhandled func(message []byte)) (*Pool, error) {
+	if opts.MaxRTT <= 0 {
+		opts.MaxRTT = 2 * time.Second
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = 10 * time.Second
+	}
+	if opts.MaxFailedPings <= 0 {
+		opts.MaxFailedPings = 3
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		url:         url,
+		protocol:    protocol,
+		persisted:   persisted,
+		onUnhandled: onUnhandled,
+		opts:        opts,
+		ctx:         ctx,
+		cancel:      cancel,
+		changed:     make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		conn, proto, err := dial(url, protocol)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.add(NewClient(conn, proto, onUnhandled))
+	}
+	slog.Info("Opened connection pool", "size", size)
+	return p, nil
+}
+
+func (p *Pool) Transport() string { return "ws" }
+
+// add puts a connection in the pool, or closes it if the pool was closed
+// while it was being dialed.
+func (p *Pool) add(client *Client) {
+	client.PersistedQueries = p.persisted
+	pc := &pooledConn{client: client, healthy: true}
+	p.mu.Lock()
+	if p.ctx.Err() != nil {
+		p.mu.Unlock()
+		client.Close()
+		return
+	}
+	p.conns = append(p.conns, pc)
+	p.notify()
+	p.watchers.Add(1)
+	p.mu.Unlock()
+	go func() {
+		defer p.watchers.Done()
+		p.watch(pc)
+	}()
+}
+
+// notify wakes operations waiting for a connection; p.mu must be held.
+func (p *Pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// watch health checks a connection until it is lost, or fails too many
+// pings, then replaces it.
+func (p *Pool) watch(pc *pooledConn) {
+	ticker := time.NewTicker(p.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-pc.client.Done():
+			p.replace(pc)
+			return
+		case <-ticker.C:
+			if !pc.client.proto.SupportsPing() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(p.ctx, p.opts.MaxRTT)
+			rtt, err := pc.client.Ping(ctx)
+			cancel()
+			p.mu.Lock()
+			wasHealthy := pc.healthy
+			pc.rtt, pc.healthy = rtt, err == nil
+			if pc.healthy && !wasHealthy {
+				p.notify()
+			}
+			if err != nil {
+				pc.failedPings++
+			} else {
+				pc.failedPings = 0
+			}
+			failedPings := pc.failedPings
+			p.mu.Unlock()
+			if err != nil && wasHealthy {
+				pc.client.log.Warn("Pooled connection unhealthy", "err", err)
+			}
+			if failedPings >= p.opts.MaxFailedPings {
+				pc.client.log.Warn("Closing pooled connection after failed pings", "pings", failedPings, "err", err)
+				pc.client.Close()
+				p.replace(pc)
+				return
+			}
+		}
+	}
+}
+
+// replace removes a lost connection and dials a new one, backing off while
+// dialing fails.
+func (p *Pool) replace(pc *pooledConn) {
+	p.mu.Lock()
+	for i, c := range p.conns {
+		if c == pc {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	pc.client.log.Warn("Pooled connection lost, replacing it", "err", pc.client.Err())
+
+	backoff := time.Second
+	for {
+		conn, proto, err := dial(p.url, p.protocol)
+		if err == nil {
+			p.add(NewClient(conn, proto, p.onUnhandled))
+			return
+		}
+		slog.Warn("Error replacing pooled connection", "err", err, "retryIn", backoff)
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// acquire reserves the least loaded connection, preferring healthy ones and
+// then the lowest ping round trip time. Lost connections waiting to be
+// replaced are skipped.
+func (p *Pool) acquire(ctx context.Context) (*pooledConn, error) {
+	for {
+		p.mu.Lock()
+		var best *pooledConn
+		for _, pc := range p.conns {
+			if pc.client.Err() != nil {
+				continue
+			}
+			if p.opts.MaxInFlight > 0 && pc.inFlight >= p.opts.MaxInFlight {
+				continue
+			}
+			if best == nil || (pc.healthy && !best.healthy) ||
+				(pc.healthy == best.healthy && (pc.inFlight < best.inFlight || (pc.inFlight == best.inFlight && pc.rtt < best.rtt))) {
+				best = pc
+			}
+		}
+		if best != nil {
+			best.inFlight++
+			p.mu.Unlock()
+			return best, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.ctx.Done():
+			return nil, ErrClientClosed
+		}
+	}
+}
+
+func (p *Pool) release(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc.inFlight--
+	p.notify()
+}
+
+func (p *Pool) Execute(ctx context.Context, opType OperationType, prefix, query, variables string) (json.RawMessage, error) {
+	pc, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: no connection available: %w", prefix, opType, err)
+	}
+	defer p.release(pc)
+	return pc.client.Execute(ctx, opType, prefix, query, variables)
+}
+
+// Subscribe runs a subscription on the least loaded connection, which it
+// counts against for as long as it runs. When the connection is lost the
+// subscription is started again on another one; events sent in between are
+// missed.
+func (p *Pool) Subscribe(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	ctx, wrapped, events := newSubscription(ctx, "")
+	pc, sub, err := p.subscribe(ctx, prefix, query, variables)
+	if err != nil {
+		wrapped.Close()
+		return nil, err
+	}
+	wrapped.id = sub.ID()
+	go func() {
+		defer close(events)
+		for {
+			for payload := range sub.Events {
+				select {
+				case events <- payload:
+				case <-ctx.Done():
+				}
+			}
+			p.release(pc)
+			if !p.lost(pc) || ctx.Err() != nil {
+				wrapped.setErr(sub.Err())
+				return
+			}
+			pc.client.log.Warn("Pooled connection lost, re-establishing subscription", "op", prefix, "err", sub.Err())
+			backoff := time.Second
+			for {
+				if pc, sub, err = p.subscribe(ctx, prefix, query, variables); err == nil {
+					break
+				}
+				slog.Warn("Error re-establishing pooled subscription", "op", prefix, "err", err, "retryIn", backoff)
+				select {
+				case <-ctx.Done():
+					wrapped.setErr(err)
+					return
+				case <-p.ctx.Done():
+					wrapped.setErr(err)
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, 30*time.Second)
+			}
+			wrapped.mu.Lock()
+			wrapped.id = sub.ID()
+			wrapped.mu.Unlock()
+		}
+	}()
+	return wrapped, nil
+}
+
+// subscribe starts a subscription on an acquired connection, which the
+// caller releases once the subscription ends.
+func (p *Pool) subscribe(ctx context.Context, prefix, query, variables string) (*pooledConn, *Subscription, error) {
+	pc, err := p.acquire(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s subscription: no connection available: %w", prefix, err)
+	}
+	sub, err := pc.client.Subscribe(ctx, prefix, query, variables)
+	if err != nil {
+		p.release(pc)
+		return nil, nil, err
+	}
+	return pc, sub, nil
+}
+
+// lost reports whether pc's connection was lost while the pool is open.
+func (p *Pool) lost(pc *pooledConn) bool {
+	select {
+	case <-pc.client.Done():
+		return p.ctx.Err() == nil
+	default:
+		return false
+	}
+}
+
+// Close stops replacing connections and closes them all, waiting for a
+// connection being dialed as a replacement.
+func (p *Pool) Close() {
+	p.cancel()
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+	for _, pc := range conns {
+		pc.client.Close()
+	}
+	p.watchers.Wait()
+}
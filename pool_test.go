@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func openPool(t *testing.T, url string, size int, opts PoolOptions) *Pool {
+	t.Helper()
+	pool, err := NewPool(url, "auto", size, opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// firstClient returns the client of the pool's first connection, if any.
+func (p *Pool) firstClient() *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns) == 0 {
+		return nil
+	}
+	return p.conns[0].client
+}
+
+func TestNewPoolOptions(t *testing.T) {
+	url := startMockServer(t, FaultPlan{})
+	tests := []struct {
+		name string
+		opts PoolOptions
+		want PoolOptions
+	}{
+		{
+			name: "defaults",
+			want: PoolOptions{MaxRTT: 2 * time.Second, HealthInterval: 10 * time.Second, MaxFailedPings: 3},
+		},
+		{
+			name: "configured",
+			opts: PoolOptions{MaxInFlight: 4, MaxRTT: time.Second, HealthInterval: 100 * time.Millisecond, MaxFailedPings: 1},
+			want: PoolOptions{MaxInFlight: 4, MaxRTT: time.Second, HealthInterval: 100 * time.Millisecond, MaxFailedPings: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := openPool(t, url, 1, tt.opts).opts; got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolReplacesConnectionAfterFailedPings(t *testing.T) {
+	url := startMockServer(t, FaultPlan{Default: Faults{StallPings: true}})
+	pool := openPool(t, url, 1, PoolOptions{MaxRTT: 20 * time.Millisecond, HealthInterval: 20 * time.Millisecond, MaxFailedPings: 2})
+	first := pool.firstClient()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if c := pool.firstClient(); c != nil && c != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection failing pings was not replaced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first.Err() == nil {
+		t.Error("replaced connection was not closed")
+	}
+}
+
+func TestPoolSubscriptionSurvivesLostConnection(t *testing.T) {
+	url := startMockServer(t, FaultPlan{Operations: map[string]Faults{"drop": {CloseCode: 4500}}})
+	pool := openPool(t, url, 1, PoolOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sub, err := pool.Subscribe(ctx, "sessionUpdates", sessionUpdatesSubscription, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstID := sub.ID()
+	// The server closes the connection instead of answering.
+	pool.Execute(ctx, OperationQuery, "drop", `query drop { sessions { id } }`, "")
+
+	// Mutations sent while the subscription is being re-established are
+	// missed, so keep creating sessions until one is announced.
+	for {
+		pool.Execute(ctx, OperationMutation, "createSession", gqlCreateSessionDocument, `{"input": [{"name": "pooled"}]}`)
+		select {
+		case _, ok := <-sub.Events:
+			if !ok {
+				t.Fatalf("subscription ended: %v", sub.Err())
+			}
+			if sub.ID() == firstID {
+				t.Error("subscription still has the ID it had on the lost connection")
+			}
+			return
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("no event after the connection was lost")
+		}
+	}
+}
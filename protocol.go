@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	subprotocolTransportWS = "graphql-transport-ws"
+	subprotocolGraphQLWS   = "graphql-ws"
+)
+
+// Protocol translates between the graphql-transport-ws message vocabulary the
+// Client uses internally (subscribe, next, error, complete, ping, pong) and
+// what is actually sent on the wire.
+type Protocol interface {
+	Subprotocol() string
+	// Outgoing converts a message into this protocol. ok is false if the
+	// protocol has no equivalent and nothing should be sent.
+	Outgoing(msg GraphQLMessage) (out GraphQLMessage, ok bool)
+	// Incoming converts a received message into graphql-transport-ws terms.
+	Incoming(msg GraphQLMessage) GraphQLMessage
+	// SupportsPing reports whether the client may send pings.
+	SupportsPing() bool
+	// ServerOutgoing and ServerIncoming are the server side's Outgoing and
+	// Incoming: they convert what a server sends into this protocol, and
+	// what it receives into graphql-transport-ws terms.
+	ServerOutgoing(msg GraphQLMessage) (out GraphQLMessage, ok bool)
+	ServerIncoming(msg GraphQLMessage) GraphQLMessage
+}
+
+// transportWS is the graphql-transport-ws protocol, which needs no
+// translation.
+type transportWS struct{}
+
+func (transportWS) Subprotocol() string { return subprotocolTransportWS }
+func (transportWS) SupportsPing() bool  { return true }
+
+func (transportWS) Outgoing(msg GraphQLMessage) (GraphQLMessage, bool) {
+	if msg.Type == "connection_terminate" {
+		return msg, false
+	}
+	return msg, true
+}
+
+func (transportWS) Incoming(msg GraphQLMessage) GraphQLMessage { return msg }
+
+func (transportWS) ServerOutgoing(msg GraphQLMessage) (GraphQLMessage, bool) { return msg, true }
+func (transportWS) ServerIncoming(msg GraphQLMessage) GraphQLMessage         { return msg }
+
+// legacyGraphQLWS is Apollo's subscriptions-transport-ws protocol, negotiated
+// as the `graphql-ws` subprotocol. The server keeps the connection alive with
+// `ka` messages and the client cannot ping.
+type legacyGraphQLWS struct{}
+
+func (legacyGraphQLWS) Subprotocol() string { return subprotocolGraphQLWS }
+func (legacyGraphQLWS) SupportsPing() bool  { return false }
+
+func (legacyGraphQLWS) Outgoing(msg GraphQLMessage) (GraphQLMessage, bool) {
+	switch msg.Type {
+	case "subscribe":
+		msg.Type = "start"
+	case "complete":
+		msg.Type = "stop"
+		msg.Payload = nil
+	case "ping", "pong":
+		return msg, false
+	}
+	return msg, true
+}
+
+func (legacyGraphQLWS) Incoming(msg GraphQLMessage) GraphQLMessage {
+	switch msg.Type {
+	case "data":
+		msg.Type = "next"
+	case "error":
+		// Errors are a single object rather than an array in some versions.
+		if trimmed := strings.TrimSpace(string(msg.Payload)); strings.HasPrefix(trimmed, "{") {
+			msg.Payload = json.RawMessage("[" + trimmed + "]")
+		}
+	}
+	return msg
+}
+
+func (legacyGraphQLWS) ServerOutgoing(msg GraphQLMessage) (GraphQLMessage, bool) {
+	switch msg.Type {
+	case "next":
+		msg.Type = "data"
+	case "ping", "pong":
+		return msg, false
+	}
+	return msg, true
+}
+
+func (legacyGraphQLWS) ServerIncoming(msg GraphQLMessage) GraphQLMessage {
+	switch msg.Type {
+	case "start":
+		msg.Type = "subscribe"
+	case "stop":
+		msg.Type = "complete"
+	}
+	return msg
+}
+
+// protocolFor returns the protocol for a subprotocol name. An empty name
+// selects graphql-transport-ws.
+func protocolFor(name string) (Protocol, error) {
+	switch name {
+	case subprotocolTransportWS, "":
+		return transportWS{}, nil
+	case subprotocolGraphQLWS:
+		return legacyGraphQLWS{}, nil
+	}
+	return nil, fmt.Errorf("unsupported subprotocol %q (expected %s or %s)", name, subprotocolTransportWS, subprotocolGraphQLWS)
+}
+
+// offeredSubprotocols is the Sec-WebSocket-Protocol header value for a
+// -protocol flag value; "auto" offers both and lets the server choose.
+func offeredSubprotocols(flagValue string) (string, error) {
+	if flagValue == "auto" {
+		return subprotocolTransportWS + ", " + subprotocolGraphQLWS, nil
+	}
+	if _, err := protocolFor(flagValue); err != nil {
+		return "", err
+	}
+	return flagValue, nil
+}
+
+// negotiatedProtocol returns the protocol for the subprotocol a server
+// selected when offered the subprotocols for flagValue. A server that
+// selects none is taken to speak the one the flag names, or
+// graphql-transport-ws if it is "auto".
+func negotiatedProtocol(flagValue, selected string) (Protocol, error) {
+	if selected == "" {
+		if flagValue == "auto" {
+			return transportWS{}, nil
+		}
+		return protocolFor(flagValue)
+	}
+	proto, err := protocolFor(selected)
+	if err != nil || (flagValue != "auto" && selected != flagValue) {
+		return nil, fmt.Errorf("server selected subprotocol %q, which was not offered", selected)
+	}
+	return proto, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNegotiatedProtocol(t *testing.T) {
+	tests := []struct {
+		flag, selected string
+		want           string
+		wantErr        bool
+	}{
+		{flag: "auto", selected: subprotocolTransportWS, want: subprotocolTransportWS},
+		{flag: "auto", selected: subprotocolGraphQLWS, want: subprotocolGraphQLWS},
+		{flag: "auto", selected: "", want: subprotocolTransportWS},
+		{flag: subprotocolGraphQLWS, selected: "", want: subprotocolGraphQLWS},
+		{flag: subprotocolTransportWS, selected: "", want: subprotocolTransportWS},
+		{flag: subprotocolGraphQLWS, selected: subprotocolGraphQLWS, want: subprotocolGraphQLWS},
+		{flag: subprotocolGraphQLWS, selected: subprotocolTransportWS, wantErr: true},
+		{flag: "auto", selected: "graphql-sse", wantErr: true},
+	}
+	for _, tt := range tests {
+		proto, err := negotiatedProtocol(tt.flag, tt.selected)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("-protocol %s, server selected %q: got %s, want an error", tt.flag, tt.selected, proto.Subprotocol())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("-protocol %s, server selected %q: %v", tt.flag, tt.selected, err)
+		} else if proto.Subprotocol() != tt.want {
+			t.Errorf("-protocol %s, server selected %q: got %s, want %s", tt.flag, tt.selected, proto.Subprotocol(), tt.want)
+		}
+	}
+}
+
+// A legacy server that selects no subprotocol is spoken to in the protocol
+// named by -protocol.
+func TestDialLegacyWithoutSubprotocol(t *testing.T) {
+	server := newTestMockServer(t, FaultPlan{})
+	server.legacy = true
+	conn, proto, err := dial(serveMock(t, server), subprotocolGraphQLWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn, proto, nil)
+	defer client.Close()
+	if conn.Subprotocol() != "" {
+		t.Fatalf("server selected %q, want no subprotocol", conn.Subprotocol())
+	}
+	if proto.Subprotocol() != subprotocolGraphQLWS {
+		t.Fatalf("got %s, want %s", proto.Subprotocol(), subprotocolGraphQLWS)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Execute(ctx, OperationMutation, "createSession", gqlCreateSessionDocument, `{"input": [{"name": "legacy"}]}`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TrafficRecord is one line of a traffic file: either the handshake of a
+// connection or a frame sent ("out") or received ("in") on it.
+type TrafficRecord struct {
+	Time      time.Time `json:"time"`
+	Conn      string    `json:"conn"`
+	Event     string    `json:"event"`
+	Direction string    `json:"direction,omitempty"`
+	// Opcode is the websocket opcode: 1 text, 2 binary, 8 close, 9 ping,
+	// 10 pong.
+	Opcode  int    `json:"opcode,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	// Encoding is "base64" for binary payloads, which JSON strings can't
+	// hold, and empty otherwise.
+	Encoding string `json:"encoding,omitempty"`
+
+	URL             string      `json:"url,omitempty"`
+	Subprotocol     string      `json:"subprotocol,omitempty"`
+	RequestHeaders  http.Header `json:"requestHeaders,omitempty"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+}
+
+// redactedHeaders and redactedKeys are replaced with "[REDACTED]" in traffic
+// files. Keys match JSON object keys at any depth, ignoring case.
+var (
+	redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key"}
+	redactedKeys    = []string{"authorization", "token", "access_token", "accesstoken", "password", "secret", "cookie", "apikey", "api_key"}
+)
+
+const redacted = "[REDACTED]"
+
+// Recorder writes websocket traffic to a JSONL file. A nil *Recorder records
+// nothing, so call sites don't need to check whether recording is enabled.
+type Recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+	conns map[*websocket.Conn]string
+}
+
+// traffic is the recorder enabled with -record, if any.
+var traffic *Recorder
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: f, enc: json.NewEncoder(f), conns: make(map[*websocket.Conn]string)}, nil
+}
+
+// startRecording enables the global recorder when path is not empty.
+func startRecording(path string) {
+	if path == "" {
+		return
+	}
+	r, err := NewRecorder(path)
+	if err != nil {
+		log.Fatalf("Error creating traffic file: %v", err)
+	}
+	traffic = r
+	slog.Info("Recording websocket traffic", "path", path)
+}
+
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Open records the handshake of a new connection and installs handlers that
+// record the control frames received on it.
+func (r *Recorder) Open(conn *websocket.Conn, url string, reqHeaders http.Header, resp *http.Response) {
+	if r == nil {
+		return
+	}
+	id := uuid.NewString()
+	r.mu.Lock()
+	r.conns[conn] = id
+	r.mu.Unlock()
+
+	rec := TrafficRecord{
+		Conn:           id,
+		Event:          "handshake",
+		URL:            url,
+		Subprotocol:    conn.Subprotocol(),
+		RequestHeaders: redactHeaders(reqHeaders),
+	}
+	if resp != nil {
+		rec.ResponseHeaders = redactHeaders(resp.Header)
+	}
+	r.write(rec)
+
+	// These mirror gorilla's default handlers, which answer pings and close
+	// frames.
+	conn.SetPingHandler(func(data string) error {
+		r.Frame(conn, "in", websocket.PingMessage, []byte(data))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		r.Frame(conn, "out", websocket.PongMessage, []byte(data))
+		return err
+	})
+	conn.SetPongHandler(func(data string) error {
+		r.Frame(conn, "in", websocket.PongMessage, []byte(data))
+		return nil
+	})
+	conn.SetCloseHandler(func(code int, text string) error {
+		r.Frame(conn, "in", websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+		reply := websocket.FormatCloseMessage(code, "")
+		conn.WriteControl(websocket.CloseMessage, reply, time.Now().Add(time.Second))
+		r.Frame(conn, "out", websocket.CloseMessage, reply)
+		return nil
+	})
+}
+
+// Frame records a frame sent or received on conn. Close frames are recorded
+// as the close code followed by the reason, and binary frames in base64.
+func (r *Recorder) Frame(conn *websocket.Conn, direction string, opcode int, payload []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	id := r.conns[conn]
+	r.mu.Unlock()
+
+	text, encoding := string(payload), ""
+	switch {
+	case opcode == websocket.CloseMessage && len(payload) >= 2:
+		text = fmt.Sprintf("%d %s", int(payload[0])<<8|int(payload[1]), payload[2:])
+	case opcode == websocket.TextMessage:
+		text = redactPayload(payload)
+	case opcode == websocket.BinaryMessage || !utf8.Valid(payload):
+		text, encoding = base64.StdEncoding.EncodeToString(payload), "base64"
+	}
+	r.write(TrafficRecord{Conn: id, Event: "frame", Direction: direction, Opcode: opcode, Payload: text, Encoding: encoding})
+}
+
+// decodePayload undoes the encoding of a recorded payload, leaving the
+// frame's bytes in Payload.
+func (rec *TrafficRecord) decodePayload() error {
+	switch rec.Encoding {
+	case "":
+		return nil
+	case "base64":
+		b, err := base64.StdEncoding.DecodeString(rec.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid base64 payload: %w", err)
+		}
+		rec.Payload, rec.Encoding = string(b), ""
+		return nil
+	}
+	return fmt.Errorf("unknown payload encoding %q", rec.Encoding)
+}
+
+// Closed forgets conn once nothing more will be recorded for it.
+func (r *Recorder) Closed(conn *websocket.Conn) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.conns, conn)
+	r.mu.Unlock()
+}
+
+func (r *Recorder) write(rec TrafficRecord) {
+	rec.Time = time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		slog.Error("Error recording traffic", "err", err)
+	}
+}
+
+func redactHeaders(headers http.Header) http.Header {
+	out := headers.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := out[name]; ok {
+			out[name] = []string{redacted}
+		}
+	}
+	return out
+}
+
+// redactPayload replaces secret values in a JSON payload. Payloads that
+// aren't JSON are returned unchanged.
+func redactPayload(payload []byte) string {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return string(payload)
+	}
+	if !redactValue(v) {
+		return string(payload)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return string(payload)
+	}
+	return string(b)
+}
+
+// redactValue redacts v in place and reports whether anything changed.
+func redactValue(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if isSecretKey(k) {
+				v[k] = redacted
+				changed = true
+				continue
+			}
+			changed = redactValue(field) || changed
+		}
+	case []interface{}:
+		for _, item := range v {
+			changed = redactValue(item) || changed
+		}
+	}
+	return changed
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range redactedKeys {
+		if key == secret {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRecorderForgetsClosedConnections(t *testing.T) {
+	url := startMockServer(t, FaultPlan{Operations: map[string]Faults{"drop": {CloseCode: 4500}}})
+
+	r, err := NewRecorder(filepath.Join(t.TempDir(), "traffic.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic = r
+	defer func() {
+		traffic = nil
+		r.Close()
+	}()
+
+	conns := func() int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.conns)
+	}
+
+	closed := dialMock(t, url)
+	lost := dialMock(t, url)
+	if n := conns(); n != 2 {
+		t.Fatalf("got %d recorded connections, want 2", n)
+	}
+	closed.Close()
+	// The server closes the connection instead of answering.
+	lost.Execute(context.Background(), OperationQuery, "drop", `query drop { sessions { id } }`, "")
+	select {
+	case <-lost.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not lost")
+	}
+	for deadline := time.Now().Add(time.Second); conns() != 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := conns(); n != 0 {
+		t.Errorf("got %d recorded connections after closing them, want 0", n)
+	}
+}
+
+// Frames read back from a traffic file have the bytes that were recorded.
+func TestRecorderFrameRoundTrip(t *testing.T) {
+	frames := []struct {
+		name    string
+		opcode  int
+		payload []byte
+	}{
+		{name: "text", opcode: websocket.TextMessage, payload: []byte(`{"type":"connection_ack"}`)},
+		{name: "binary", opcode: websocket.BinaryMessage, payload: []byte("binary \xff\xfe\x00 payload")},
+		{name: "binary UTF-8", opcode: websocket.BinaryMessage, payload: []byte("héllo")},
+		{name: "ping", opcode: websocket.PingMessage, payload: []byte("\x80\x81")},
+		{name: "close", opcode: websocket.CloseMessage, payload: websocket.FormatCloseMessage(4500, "Internal server error")},
+	}
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		r.Frame(nil, "in", f.opcode, f.payload)
+	}
+	r.Close()
+
+	records, err := readTraffic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(frames) {
+		t.Fatalf("got %d records, want %d", len(records), len(frames))
+	}
+	for i, f := range frames {
+		if got := recordedPayload(records[i]); !bytes.Equal(got, f.payload) {
+			t.Errorf("%s: got %q, want %q", f.name, got, f.payload)
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const replHelp = `Type or paste an operation; it is sent once its braces balance.
+Commands:
+  :vars [JSON|clear]   show, set or clear the variables; each operation gets those it declares
+  :subs                list active subscriptions
+  :cancel ID           stop a subscription (an ID prefix is enough)
+  :raw [on|off]        toggle printing every frame sent and received
+  :filter [EXPR|clear] only show events of new subscriptions matching EXPR, e.g. data.x.type == "CREATED"
+  :project [FIELDS|clear]  show only these [name=]path fields of new subscriptions' events
+  :flatten [on|off]    flatten new subscriptions' events into dotted keys
+  :history [N]         show the last N (default 20) history entries
+  :again N             re-run history entry N
+  :load FILE           send the operation in FILE
+  :help                show this help
+  :quit                close the connection and exit`
+
+// replSubscription is a subscription started from the REPL.
+type replSubscription struct {
+	sub     *Subscription
+	query   string
+	started time.Time
+	events  atomic.Int64
+}
+
+// repl is an interactive session on one websocket connection.
+type repl struct {
+	ctx       context.Context
+	client    *Client
+	sinks     *SinkRouter
+	variables string
+	// filter, project and flatten make the view of new subscriptions.
+	filter  string
+	project string
+	flatten bool
+	raw     atomic.Bool
+
+	mu   sync.Mutex
+	subs map[*replSubscription]bool
+
+	history     []string
+	historyFile string
+	// historyLines is how many entries the history file holds.
+	historyLines int
+}
+
+// runREPL keeps one connection open and sends operations typed or pasted on
+// stdin until :quit or end of input.
+func runREPL(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	url := fs.String("url", defaultURL, "GraphQL websocket endpoint")
+	protocol := fs.String("protocol", "auto", "websocket subprotocol: graphql-transport-ws, graphql-ws, or auto")
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result used to validate operations")
+	historyPath := fs.String("history", defaultHistoryPath(), "file that keeps entered operations and commands across runs")
+	record := fs.String("record", "", "record websocket frames and handshake headers, with secrets redacted, to this JSONL file")
+	var sinkSpecs stringsFlag
+	fs.Var(&sinkSpecs, "sink", "where subscription events go, repeatable: stdout, stdout:pretty, file:PATH[,max-size=10MB][,max-age=1h] or webhook:URL[,retries=3]; prefix with NAME= to route subscriptions by operation name (default stdout)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	logOpts.setup()
+	sinks, err := parseSinks(sinkSpecs)
+	if err != nil {
+		log.Fatalf("Invalid -sink: %v", err)
+	}
+	defer sinks.Close()
+
+	startRecording(*record)
+	defer traffic.Close()
+	if schema, err := loadSchema(*schemaPath); err == nil {
+		cachedSchema = schema
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r := &repl{ctx: ctx, sinks: sinks, subs: make(map[*replSubscription]bool), historyFile: *historyPath}
+	r.loadHistory()
+
+	conn, proto := connect(*url, *protocol)
+	r.client = NewClient(conn, proto, func(message []byte) {
+		if !r.raw.Load() {
+			fmt.Printf("Received: %s\n", string(message))
+		}
+	})
+	defer r.client.Close()
+	r.client.OnFrame(func(direction string, data []byte) {
+		if r.raw.Load() {
+			fmt.Printf("%s %s %s\n", time.Now().Format("15:04:05.000"), map[string]string{"in": "<<", "out": ">>"}[direction], string(data))
+		}
+	})
+	go pingRoutine(ctx, r.client)
+
+	fmt.Println(`Connected. Type :help for commands.`)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	var buf strings.Builder
+	prompt := func() {
+		if buf.Len() == 0 {
+			fmt.Print("gql> ")
+		} else {
+			fmt.Print("...> ")
+		}
+	}
+	prompt()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return
+		case <-r.client.Done():
+			log.Fatalf("Connection lost: %v", r.client.Err())
+		case line, ok := <-lines:
+			if !ok {
+				fmt.Println()
+				return
+			}
+			if buf.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+				cmd := strings.TrimSpace(line)
+				r.addHistory(cmd)
+				if !r.command(cmd) {
+					return
+				}
+			} else if buf.Len() > 0 || strings.TrimSpace(line) != "" {
+				buf.WriteString(line)
+				buf.WriteString("\n")
+				if src := buf.String(); operationComplete(src) {
+					buf.Reset()
+					r.addHistory(strings.TrimSpace(src))
+					r.send(src)
+				}
+			}
+			prompt()
+		}
+	}
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".gql_history"
+	}
+	return filepath.Join(home, ".gql_history")
+}
+
+// operationComplete reports whether src holds a whole operation: it has a
+// selection set and its braces, outside strings and comments, balance.
+func operationComplete(src string) bool {
+	depth, opened := 0, false
+	inString, inComment := false, false
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case inComment:
+			inComment = c != '\n'
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '#':
+			inComment = true
+		case c == '"':
+			inString = true
+		case c == '{':
+			depth++
+			opened = true
+		case c == '}':
+			depth--
+		}
+	}
+	return opened && depth <= 0
+}
+
+// command runs a :command and reports whether the REPL should continue.
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":help":
+		fmt.Println(replHelp)
+	case ":quit", ":exit":
+		return false
+	case ":vars":
+		switch arg {
+		case "":
+			if r.variables == "" {
+				fmt.Println("No variables set")
+			} else {
+				fmt.Println(r.variables)
+			}
+		case "clear":
+			r.variables = ""
+		default:
+			var vars map[string]interface{}
+			if err := json.Unmarshal([]byte(arg), &vars); err != nil {
+				fmt.Printf("Variables must be a JSON object: %v\n", err)
+				break
+			}
+			r.variables = arg
+		}
+	case ":filter", ":project":
+		setting := map[string]*string{":filter": &r.filter, ":project": &r.project}[name]
+		switch arg {
+		case "":
+			if *setting == "" {
+				fmt.Println("Not set")
+			} else {
+				fmt.Println(*setting)
+			}
+		case "clear":
+			*setting = ""
+		default:
+			old := *setting
+			*setting = arg
+			if _, err := newEventView(r.filter, r.project, r.flatten); err != nil {
+				*setting = old
+				fmt.Printf("%v\n", err)
+			}
+		}
+	case ":flatten":
+		switch arg {
+		case "on":
+			r.flatten = true
+		case "off":
+			r.flatten = false
+		case "":
+			r.flatten = !r.flatten
+		default:
+			fmt.Println("Usage: :flatten [on|off]")
+		}
+		fmt.Printf("Flatten %s\n", map[bool]string{true: "on", false: "off"}[r.flatten])
+	case ":subs":
+		r.listSubscriptions()
+	case ":cancel":
+		r.cancel(arg)
+	case ":raw":
+		switch arg {
+		case "on":
+			r.raw.Store(true)
+		case "off":
+			r.raw.Store(false)
+		case "":
+			r.raw.Store(!r.raw.Load())
+		default:
+			fmt.Println("Usage: :raw [on|off]")
+		}
+		fmt.Printf("Raw frames %s\n", map[bool]string{true: "on", false: "off"}[r.raw.Load()])
+	case ":history":
+		n := 20
+		if arg != "" {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil {
+				fmt.Println("Usage: :history [N]")
+				break
+			}
+		}
+		for i := max(0, len(r.history)-n); i < len(r.history); i++ {
+			fmt.Printf("%4d  %s\n", i+1, strings.ReplaceAll(r.history[i], "\n", "\n      "))
+		}
+	case ":again":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(r.history) {
+			fmt.Printf("No history entry %q\n", arg)
+			break
+		}
+		entry := r.history[n-1]
+		if strings.HasPrefix(entry, ":") {
+			fmt.Println("Only operations can be re-run")
+			break
+		}
+		r.send(entry)
+	case ":load":
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", arg, err)
+			break
+		}
+		r.send(string(src))
+	default:
+		fmt.Printf("Unknown command %s, type :help for commands\n", name)
+	}
+	return true
+}
+
+// send runs one operation: queries and mutations wait for their result,
+// subscriptions print their events in the background.
+func (r *repl) send(src string) {
+	src = strings.TrimSpace(src)
+	doc, err := parseDocument(src)
+	if err != nil {
+		fmt.Printf("Invalid operation: %v\n", err)
+		return
+	}
+	if len(doc.Operations) != 1 {
+		fmt.Printf("Document must contain exactly one operation, found %d\n", len(doc.Operations))
+		return
+	}
+	opType := doc.Operations[0].Type
+	variables := r.operationVariables(doc.Operations[0])
+
+	if opType != OperationSubscription {
+		result, err := r.client.ExecuteIncremental(r.ctx, opType, "repl", src, variables)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		for i, patch := range result.Patches {
+			b, _ := json.Marshal(patch)
+			fmt.Printf("Patch %d: %s\n", i+1, string(b))
+		}
+		fmt.Printf("Result: %s\n", string(result.Payload()))
+		return
+	}
+
+	view, err := newEventView(r.filter, r.project, r.flatten)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	sub, err := r.client.Subscribe(r.ctx, "repl", src, variables)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	rs := &replSubscription{sub: sub, query: src, started: time.Now()}
+	r.mu.Lock()
+	r.subs[rs] = true
+	r.mu.Unlock()
+	fmt.Printf("Subscription %s started\n", sub.ID())
+	name := doc.Operations[0].Name
+	if name == "" {
+		name = "repl"
+	}
+
+	go func() {
+		for payload := range sub.Events {
+			rs.events.Add(1)
+			if out, ok := view.Apply(payload); ok {
+				r.sinks.Write(SinkEvent{Time: time.Now(), Subscription: name, ID: sub.ID(), Payload: out})
+			}
+		}
+		r.mu.Lock()
+		delete(r.subs, rs)
+		r.mu.Unlock()
+		if err := sub.Err(); err != nil {
+			fmt.Printf("Subscription %s ended: %v\n", sub.ID(), err)
+		} else {
+			fmt.Printf("Subscription %s completed\n", sub.ID())
+		}
+	}()
+}
+
+// operationVariables returns the variables set with :vars that op declares,
+// so one set of variables can serve several operations.
+func (r *repl) operationVariables(op *OperationDef) string {
+	if r.variables == "" {
+		return ""
+	}
+	var all map[string]json.RawMessage
+	json.Unmarshal([]byte(r.variables), &all)
+	vars := make(map[string]json.RawMessage)
+	for _, def := range op.VarDefs {
+		if v, ok := all[def.Name]; ok {
+			vars[def.Name] = v
+		}
+	}
+	if len(vars) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(vars)
+	return string(b)
+}
+
+func (r *repl) activeSubscriptions() []*replSubscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subs := make([]*replSubscription, 0, len(r.subs))
+	for rs := range r.subs {
+		subs = append(subs, rs)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].started.Before(subs[j].started) })
+	return subs
+}
+
+func (r *repl) listSubscriptions() {
+	subs := r.activeSubscriptions()
+	if len(subs) == 0 {
+		fmt.Println("No active subscriptions")
+		return
+	}
+	for _, rs := range subs {
+		query := strings.Join(strings.Fields(rs.query), " ")
+		if len(query) > 60 {
+			query = query[:57] + "..."
+		}
+		fmt.Printf("%s  %6s  %4d events  %s\n", rs.sub.ID(), time.Since(rs.started).Round(time.Second), rs.events.Load(), query)
+	}
+}
+
+func (r *repl) cancel(prefix string) {
+	if prefix == "" {
+		fmt.Println("Usage: :cancel ID")
+		return
+	}
+	var matches []*replSubscription
+	for _, rs := range r.activeSubscriptions() {
+		if strings.HasPrefix(rs.sub.ID(), prefix) {
+			matches = append(matches, rs)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		fmt.Printf("No active subscription %s\n", prefix)
+	case 1:
+		matches[0].sub.Close()
+	default:
+		fmt.Printf("%s matches %d subscriptions, use more of the ID\n", prefix, len(matches))
+	}
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// maxHistory is how many entries the history file keeps.
+const maxHistory = 1000
+
+// History entries are stored one per line as JSON strings, so multi-line
+// operations survive. The file is cut down to the last maxHistory entries
+// when it is loaded, and rewritten whenever it would grow past them.
+func (r *repl) loadHistory() {
+	f, err := os.Open(r.historyFile)
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		var entry string
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && persistable(entry) {
+			r.history = append(r.history, entry)
+		}
+	}
+	f.Close()
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+	r.historyLines = lines
+	if lines > len(r.history) {
+		r.saveHistory()
+	}
+}
+
+// persistable reports whether an entry may be written to the history file.
+// :vars lines often carry tokens and passwords, so they aren't.
+func persistable(entry string) bool {
+	name, _, _ := strings.Cut(entry, " ")
+	return name != ":vars"
+}
+
+// saveHistory rewrites the history file with the entries in memory.
+func (r *repl) saveHistory() {
+	var sb strings.Builder
+	lines := 0
+	for _, entry := range r.history {
+		if persistable(entry) {
+			b, _ := json.Marshal(entry)
+			sb.Write(b)
+			sb.WriteString("\n")
+			lines++
+		}
+	}
+	tmp := r.historyFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0o600); err != nil {
+		log.Printf("Error saving history: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, r.historyFile); err != nil {
+		log.Printf("Error saving history: %v", err)
+		return
+	}
+	r.historyLines = lines
+}
+
+func (r *repl) addHistory(entry string) {
+	r.history = append(r.history, entry)
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+	if r.historyFile == "" || !persistable(entry) {
+		return
+	}
+	if r.historyLines >= maxHistory {
+		r.saveHistory()
+		return
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Error saving history: %v", err)
+		return
+	}
+	defer f.Close()
+	b, _ := json.Marshal(entry)
+	if _, err := io.WriteString(f, string(b)+"\n"); err != nil {
+		log.Printf("Error saving history: %v", err)
+		return
+	}
+	r.historyLines++
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestREPLHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var old strings.Builder
+	for i := 0; i < maxHistory+10; i++ {
+		b, _ := json.Marshal(fmt.Sprintf("{ entry%d }", i))
+		old.Write(b)
+		old.WriteString("\n")
+	}
+	old.WriteString(`":vars {\"token\": \"old\"}"` + "\n")
+	if err := os.WriteFile(path, []byte(old.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &repl{historyFile: path}
+	r.loadHistory()
+	if len(r.history) != maxHistory || r.history[0] != "{ entry10 }" {
+		t.Fatalf("loaded %d entries starting with %q, want %d starting with the 11th", len(r.history), r.history[0], maxHistory)
+	}
+	r.addHistory(`:vars {"password": "hunter2"}`)
+	r.addHistory("{ last }")
+	if got := r.history[len(r.history)-2]; !strings.HasPrefix(got, ":vars") {
+		t.Errorf("got %q, want :vars kept in memory", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), ":vars") {
+		t.Error("history file contains :vars lines")
+	}
+	// The :vars entry takes a place in memory but not in the file.
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != maxHistory-1 || lines[0] != `"{ entry12 }"` || lines[len(lines)-1] != `"{ last }"` {
+		t.Errorf("history file has %d lines from %s to %s, want %d from the 13th entry to the last", len(lines), lines[0], lines[len(lines)-1], maxHistory-1)
+	}
+
+	// A long session doesn't grow the file past the cap.
+	for i := 0; i < maxHistory+10; i++ {
+		r.addHistory(fmt.Sprintf("{ new%d }", i))
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != maxHistory || lines[0] != `"{ new10 }"` {
+		t.Errorf("history file has %d lines starting with %s, want %d starting with the 11th new entry", len(lines), lines[0], maxHistory)
+	}
+}
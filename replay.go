@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// runReplay reproduces a traffic file written with -record, either by serving
+// the recorded responses to a client or by re-sending the recorded client
+// frames to a server.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	mode := fs.String("mode", "client", "client: re-send the recorded client frames to -url; server: answer clients with the recorded responses")
+	file := fs.String("file", "", "traffic file written with -record")
+	url := fs.String("url", defaultURL, "client mode: GraphQL websocket endpoint to replay against")
+	addr := fs.String("addr", "localhost:8080", "server mode: address to listen on")
+	path := fs.String("path", "/graphql", "server mode: endpoint path")
+	speed := fs.Float64("speed", 1, "timing scale: 1 keeps the recorded timing, 2 is twice as fast, 0 sends without delays")
+	linger := fs.Duration("linger", 2*time.Second, "client mode: how long to wait for responses after the last frame")
+	wait := fs.Duration("wait", 5*time.Second, "client mode: how long a frame waits for the results recorded before it, so IDs they carry can be mapped to live ones; 0 to not wait")
+	record := fs.String("record", "", "record websocket frames and handshake headers, with secrets redacted, to this JSONL file")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+
+	logOpts.setup()
+
+	if *file == "" {
+		log.Fatalf("-file is required")
+	}
+	if *speed < 0 {
+		log.Fatalf("Invalid -speed %v, expected 0 or more", *speed)
+	}
+	records, err := readTraffic(*file)
+	if err != nil {
+		log.Fatalf("Error reading traffic file: %v", err)
+	}
+	startRecording(*record)
+	defer traffic.Close()
+
+	switch *mode {
+	case "client":
+		replayClient(records, *url, *speed, *linger, *wait)
+	case "server":
+		server := newReplayServer(records, *speed)
+		mux := http.NewServeMux()
+		mux.Handle(*path, server)
+		fmt.Printf("Replaying %d recorded operations on ws://%s%s\n", len(server.ops), *addr, *path)
+		log.Fatal(http.ListenAndServe(*addr, mux))
+	default:
+		log.Fatalf("Invalid -mode %q, expected client or server", *mode)
+	}
+}
+
+func readTraffic(path string) ([]TrafficRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []TrafficRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec TrafficRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if err := rec.decodePayload(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s contains no traffic", path)
+	}
+	return records, nil
+}
+
+// scaled divides a recorded delay by speed; speed 0 removes it.
+func scaled(d time.Duration, speed float64) time.Duration {
+	if speed == 0 {
+		return 0
+	}
+	return time.Duration(float64(d) / speed)
+}
+
+// sleepUntil waits until t, or until ctx is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// replayClient re-sends each recorded connection's outbound frames at their
+// recorded offsets and prints what the server answers. Values the server
+// generated, such as the IDs of created objects, differ from the recorded
+// ones, so strings in each recorded result are mapped to those at the same
+// place in the live result, and replaced in the frames sent after it.
+func replayClient(records []TrafficRecord, url string, speed float64, linger, wait time.Duration) {
+	var order []string
+	byConn := make(map[string][]TrafficRecord)
+	for _, rec := range records {
+		if _, ok := byConn[rec.Conn]; !ok {
+			order = append(order, rec.Conn)
+		}
+		byConn[rec.Conn] = append(byConn[rec.Conn], rec)
+	}
+	fmt.Printf("Replaying %d connections against %s\n", len(order), url)
+
+	start := time.Now()
+	base := records[0].Time
+	values := &liveValues{live: make(map[string]string)}
+	var wg sync.WaitGroup
+	for i, id := range order {
+		wg.Add(1)
+		go func(label string, recs []TrafficRecord) {
+			defer wg.Done()
+			replayConnection(label, recs, url, start, base, speed, linger, wait, values)
+		}(fmt.Sprintf("conn %d", i+1), byConn[id])
+	}
+	wg.Wait()
+}
+
+func replayConnection(label string, recs []TrafficRecord, url string, start, base time.Time, speed float64, linger, wait time.Duration, values *liveValues) {
+	ctx := context.Background()
+	if err := sleepUntil(ctx, start.Add(scaled(recs[0].Time.Sub(base), speed))); err != nil {
+		return
+	}
+
+	logger := slog.With("conn", label)
+	// Recorded credentials are redacted, so the handshake uses this client's
+	// own.
+	headers := authHeaders()
+	for _, rec := range recs {
+		if rec.Event == "handshake" && rec.Subprotocol != "" {
+			headers.Add("Sec-WebSocket-Protocol", rec.Subprotocol)
+		}
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, headers)
+	if err != nil {
+		logger.Error("Error connecting to WebSocket", "err", err)
+		return
+	}
+	defer conn.Close()
+	traffic.Open(conn, url, headers, resp)
+	defer traffic.Closed(conn)
+	fmt.Printf("[%s] Connected using %q\n", label, conn.Subprotocol())
+
+	results := newReplayResults(recs, values)
+	go func() {
+		defer results.closed()
+		for {
+			opcode, message, err := conn.ReadMessage()
+			if err != nil {
+				fmt.Printf("[%s] Connection closed: %v\n", label, err)
+				return
+			}
+			traffic.Frame(conn, "in", opcode, message)
+			fmt.Printf("%s: [%s] Received: %s\n", time.Now().Format(time.RFC3339), label, string(message))
+			if opcode == websocket.TextMessage {
+				results.received(message)
+			}
+		}
+	}()
+
+	for i, rec := range recs {
+		if rec.Event != "frame" || rec.Direction != "out" {
+			continue
+		}
+		if err := sleepUntil(ctx, start.Add(scaled(rec.Time.Sub(base), speed))); err != nil {
+			return
+		}
+		if wait > 0 && !results.await(i, wait) {
+			logger.Warn("Sending frame without all the results recorded before it")
+		}
+		if rec.Opcode == websocket.TextMessage {
+			rec.Payload = values.replace(rec.Payload)
+		}
+		if err := sendRecordedFrame(conn, rec); err != nil {
+			logger.Error("Error sending frame", "err", err)
+			return
+		}
+		traffic.Frame(conn, "out", rec.Opcode, recordedPayload(rec))
+		fmt.Printf("[%s] Sent: %s\n", label, rec.Payload)
+		if rec.Opcode == websocket.CloseMessage {
+			break
+		}
+	}
+	time.Sleep(linger)
+}
+
+// liveValues maps strings found in recorded results to the strings at the
+// same place in the live results, across all replayed connections.
+type liveValues struct {
+	mu   sync.Mutex
+	live map[string]string
+}
+
+// learn maps the strings of a recorded result to those of its live
+// counterpart. Redacted strings are skipped.
+func (v *liveValues) learn(recorded, live interface{}) {
+	switch rec := recorded.(type) {
+	case string:
+		if l, ok := live.(string); ok && l != rec && rec != redacted {
+			v.mu.Lock()
+			v.live[rec] = l
+			v.mu.Unlock()
+		}
+	case map[string]interface{}:
+		if l, ok := live.(map[string]interface{}); ok {
+			for k, rv := range rec {
+				v.learn(rv, l[k])
+			}
+		}
+	case []interface{}:
+		if l, ok := live.([]interface{}); ok {
+			for i := 0; i < len(rec) && i < len(l); i++ {
+				v.learn(rec[i], l[i])
+			}
+		}
+	}
+}
+
+// replace returns a recorded text frame with every string that has a live
+// counterpart replaced by it.
+func (v *liveValues) replace(payload string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.live) == 0 {
+		return payload
+	}
+	var msg interface{}
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		return payload
+	}
+	replaced := false
+	var walk func(interface{}) interface{}
+	walk = func(val interface{}) interface{} {
+		switch val := val.(type) {
+		case string:
+			if l, ok := v.live[val]; ok {
+				replaced = true
+				return l
+			}
+		case map[string]interface{}:
+			for k, e := range val {
+				val[k] = walk(e)
+			}
+		case []interface{}:
+			for i, e := range val {
+				val[i] = walk(e)
+			}
+		}
+		return val
+	}
+	msg = walk(msg)
+	if !replaced {
+		return payload
+	}
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return payload
+	}
+	return string(out)
+}
+
+// replayResults pairs the results a connection receives with the ones
+// recorded for it: the nth result of an operation ID with the nth recorded.
+type replayResults struct {
+	values *liveValues
+
+	mu       sync.Mutex
+	recorded map[string][]interface{}
+	seen     map[string]int
+	// before holds, for each record, how many results of each operation were
+	// recorded before it.
+	before  []map[string]int
+	changed chan struct{}
+	done    bool
+}
+
+// replayMessage is the part of a recorded or live message used to pair
+// results, in either websocket subprotocol.
+type replayMessage struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (m replayMessage) isResult() bool {
+	return m.ID != "" && (m.Type == "next" || m.Type == "data")
+}
+
+func newReplayResults(recs []TrafficRecord, values *liveValues) *replayResults {
+	r := &replayResults{
+		values:   values,
+		recorded: make(map[string][]interface{}),
+		seen:     make(map[string]int),
+		before:   make([]map[string]int, len(recs)),
+		changed:  make(chan struct{}),
+	}
+	counts := make(map[string]int)
+	for i, rec := range recs {
+		if rec.Event == "frame" && rec.Direction == "out" {
+			r.before[i] = maps.Clone(counts)
+		}
+		if rec.Event != "frame" || rec.Direction != "in" || rec.Opcode != websocket.TextMessage {
+			continue
+		}
+		var msg replayMessage
+		if json.Unmarshal([]byte(rec.Payload), &msg) != nil || !msg.isResult() {
+			continue
+		}
+		var payload interface{}
+		json.Unmarshal(msg.Payload, &payload)
+		r.recorded[msg.ID] = append(r.recorded[msg.ID], payload)
+		counts[msg.ID]++
+	}
+	return r
+}
+
+// received pairs a live message with its recorded counterpart.
+func (r *replayResults) received(message []byte) {
+	var msg replayMessage
+	if json.Unmarshal(message, &msg) != nil || !msg.isResult() {
+		return
+	}
+	var payload interface{}
+	json.Unmarshal(msg.Payload, &payload)
+
+	r.mu.Lock()
+	n := r.seen[msg.ID]
+	r.seen[msg.ID]++
+	var recorded interface{}
+	if n < len(r.recorded[msg.ID]) {
+		recorded = r.recorded[msg.ID][n]
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+	r.values.learn(recorded, payload)
+}
+
+// closed stops waiting for results once the connection is gone.
+func (r *replayResults) closed() {
+	r.mu.Lock()
+	r.done = true
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+// await waits up to timeout for the results recorded before record i to
+// arrive, and reports whether they did.
+func (r *replayResults) await(i int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		r.mu.Lock()
+		missing := false
+		for id, n := range r.before[i] {
+			if r.seen[id] < n {
+				missing = true
+				break
+			}
+		}
+		changed, done := r.changed, r.done
+		r.mu.Unlock()
+		if !missing {
+			return true
+		}
+		if done {
+			return false
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// recordedPayload returns a frame's payload in wire form. Close frames are
+// recorded as "<code> <reason>".
+func recordedPayload(rec TrafficRecord) []byte {
+	if rec.Opcode != websocket.CloseMessage {
+		return []byte(rec.Payload)
+	}
+	codeText, reason, _ := strings.Cut(rec.Payload, " ")
+	code, err := strconv.Atoi(codeText)
+	if err != nil {
+		return nil
+	}
+	return websocket.FormatCloseMessage(code, reason)
+}
+
+func sendRecordedFrame(conn *websocket.Conn, rec TrafficRecord) error {
+	payload := recordedPayload(rec)
+	switch rec.Opcode {
+	case websocket.TextMessage, websocket.BinaryMessage:
+		return conn.WriteMessage(rec.Opcode, payload)
+	case websocket.CloseMessage, websocket.PingMessage, websocket.PongMessage:
+		return conn.WriteControl(rec.Opcode, payload, time.Now().Add(time.Second))
+	}
+	return fmt.Errorf("unknown opcode %d", rec.Opcode)
+}
+
+// recordedOperation is an operation found in a traffic file with the
+// responses the server sent for it, timed from the subscribe message.
+type recordedOperation struct {
+	key       string
+	query     string
+	responses []recordedResponse
+	used      bool
+}
+
+type recordedResponse struct {
+	delay time.Duration
+	msg   GraphQLMessage
+}
+
+// replayServer answers each operation with the recorded responses of the
+// first unused recorded operation with the same document and variables.
+// Messages are kept in graphql-transport-ws terms and translated to the
+// subprotocol each client negotiates.
+type replayServer struct {
+	mu       sync.Mutex
+	ops      []*recordedOperation
+	speed    float64
+	upgrader websocket.Upgrader
+}
+
+func newReplayServer(records []TrafficRecord, speed float64) *replayServer {
+	s := &replayServer{
+		speed: speed,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{"graphql-transport-ws", "graphql-ws"},
+			CheckOrigin:  func(r *http.Request) bool { return true },
+		},
+	}
+	type started struct {
+		op *recordedOperation
+		at time.Time
+	}
+	active := make(map[string]started)
+	protos := make(map[string]Protocol)
+	for _, rec := range records {
+		if rec.Event == "handshake" {
+			if proto, err := protocolFor(rec.Subprotocol); err == nil {
+				protos[rec.Conn] = proto
+			}
+			continue
+		}
+		if rec.Event != "frame" || rec.Opcode != websocket.TextMessage {
+			continue
+		}
+		var msg GraphQLMessage
+		if err := json.Unmarshal([]byte(rec.Payload), &msg); err != nil || msg.ID == "" {
+			continue
+		}
+		proto, ok := protos[rec.Conn]
+		if !ok {
+			proto = transportWS{}
+		}
+		if rec.Direction == "out" {
+			msg = proto.ServerIncoming(msg)
+		} else {
+			msg = proto.Incoming(msg)
+		}
+		key := rec.Conn + "/" + msg.ID
+		switch {
+		case rec.Direction == "out" && msg.Type == "subscribe":
+			var req mockRequest
+			json.Unmarshal(msg.Payload, &req)
+			op := &recordedOperation{key: operationKey(req), query: operationDocument(req)}
+			s.ops = append(s.ops, op)
+			active[key] = started{op: op, at: rec.Time}
+		case rec.Direction == "in":
+			if st, ok := active[key]; ok {
+				st.op.responses = append(st.op.responses, recordedResponse{delay: rec.Time.Sub(st.at), msg: msg})
+			}
+		}
+	}
+	return s
+}
+
+// operationKey identifies a request by its document (or persisted query
+// hash), operation name and variables.
+func operationKey(req mockRequest) string {
+	vars, _ := json.Marshal(req.Variables)
+	return operationDocument(req) + "\x00" + req.OperationName + "\x00" + string(vars)
+}
+
+func operationDocument(req mockRequest) string {
+	if req.Query != "" {
+		return req.Query
+	}
+	if pq, ok := req.Extensions["persistedQuery"].(map[string]interface{}); ok {
+		hash, _ := pq["sha256Hash"].(string)
+		return "sha256:" + hash
+	}
+	return ""
+}
+
+// match claims the recorded operation for req. Without one recorded with
+// the same variables, it falls back to one with the same document.
+func (s *replayServer) match(req mockRequest) *recordedOperation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := operationKey(req)
+	for _, op := range s.ops {
+		if !op.used && op.key == key {
+			op.used = true
+			return op
+		}
+	}
+	doc := operationDocument(req)
+	for _, op := range s.ops {
+		if !op.used && op.query == doc {
+			slog.Warn("No recording with these variables, replaying one with different variables")
+			op.used = true
+			return op
+		}
+	}
+	return nil
+}
+
+func (s *replayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("Websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	slog.Info("Connection", "remote", r.RemoteAddr, "subprotocol", conn.Subprotocol())
+	proto, err := protocolFor(conn.Subprotocol())
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var writeMu sync.Mutex
+	write := func(msg GraphQLMessage) error {
+		msg, ok := proto.ServerOutgoing(msg)
+		if !ok {
+			return nil
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+	// ops holds the operations being replayed, until they finish or the
+	// client completes them.
+	type replaying struct{ cancel context.CancelFunc }
+	var mu sync.Mutex
+	ops := make(map[string]*replaying)
+	finish := func(id string, op *replaying) {
+		mu.Lock()
+		if ops[id] == op {
+			delete(ops, id)
+		}
+		mu.Unlock()
+		op.cancel()
+	}
+
+	for {
+		var msg GraphQLMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			slog.Info("Connection closed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		msg = proto.ServerIncoming(msg)
+		switch msg.Type {
+		case "connection_init":
+			write(GraphQLMessage{Type: "connection_ack"})
+		case "ping":
+			write(GraphQLMessage{Type: "pong", Payload: msg.Payload})
+		case "subscribe":
+			var req mockRequest
+			json.Unmarshal(msg.Payload, &req)
+			op := s.match(req)
+			if op == nil {
+				slog.Warn("No recorded response", "id", msg.ID)
+				b, _ := json.Marshal(GraphQLErrors{{Message: "no recorded response for this operation"}})
+				write(GraphQLMessage{ID: msg.ID, Type: "error", Payload: b})
+				continue
+			}
+			slog.Info("Replaying recorded responses", "id", msg.ID, "responses", len(op.responses))
+			opCtx, opCancel := context.WithCancel(ctx)
+			running := &replaying{cancel: opCancel}
+			mu.Lock()
+			ops[msg.ID] = running
+			mu.Unlock()
+			go func(id string) {
+				defer finish(id, running)
+				started := time.Now()
+				for _, resp := range op.responses {
+					if sleepUntil(opCtx, started.Add(scaled(resp.delay, s.speed))) != nil {
+						return
+					}
+					// The recorded response keeps its payload but takes the
+					// ID of the operation being answered.
+					resp.msg.ID = id
+					if write(resp.msg) != nil {
+						return
+					}
+				}
+			}(msg.ID)
+		case "complete":
+			mu.Lock()
+			running := ops[msg.ID]
+			mu.Unlock()
+			if running != nil {
+				finish(msg.ID, running)
+			}
+		case "connection_terminate":
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReplayMapsRecordedValues(t *testing.T) {
+	frame := func(direction, payload string) TrafficRecord {
+		return TrafficRecord{Event: "frame", Direction: direction, Opcode: 1, Payload: payload}
+	}
+	recs := []TrafficRecord{
+		{Event: "handshake"},
+		frame("out", `{"id":"1","type":"subscribe","payload":{"query":"mutation { createSessions(input: [{}]) { sessions { id } } }"}}`),
+		frame("in", `{"id":"1","type":"next","payload":{"data":{"createSessions":{"sessions":[{"id":"old-a"},{"id":"old-b"}]},"token":"[REDACTED]"}}}`),
+		frame("in", `{"id":"1","type":"complete"}`),
+		frame("out", `{"id":"2","type":"subscribe","payload":{"variables":{"input":[{"id":"old-a"},{"id":"old-b"},{"id":"other"}],"token":"[REDACTED]"}}}`),
+	}
+	values := &liveValues{live: make(map[string]string)}
+	results := newReplayResults(recs, values)
+
+	if !results.await(1, time.Millisecond) {
+		t.Error("the first frame waited for results")
+	}
+	if results.await(4, 10*time.Millisecond) {
+		t.Error("the second frame didn't wait for the createSessions result")
+	}
+	results.received([]byte(`{"id":"1","type":"next","payload":{"data":{"createSessions":{"sessions":[{"id":"new-a"},{"id":"new-b"}]},"token":"live"}}}`))
+	if !results.await(4, time.Second) {
+		t.Error("the second frame still waits after the result arrived")
+	}
+
+	got := values.replace(recs[4].Payload)
+	want := `{"id":"2","payload":{"variables":{"input":[{"id":"new-a"},{"id":"new-b"},{"id":"other"}],"token":"[REDACTED]"}},"type":"subscribe"}`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if unchanged := `{"id":"3", "type":"complete"}`; values.replace(unchanged) != unchanged {
+		t.Errorf("a frame without recorded values was rewritten: %s", values.replace(unchanged))
+	}
+
+	// Results that never arrive aren't waited for once the connection is
+	// gone.
+	closed := newReplayResults(recs, values)
+	closed.closed()
+	if closed.await(4, time.Minute) {
+		t.Error("await reported results on a closed connection")
+	}
+}
+
+// Recorded responses are translated to the subprotocol the replaying client
+// negotiated.
+func TestReplayServerTranslatesProtocol(t *testing.T) {
+	query := `{"query":"{ sessions { id } }"}`
+	recording := func(subprotocol, start, next string) []TrafficRecord {
+		frame := func(direction, payload string) TrafficRecord {
+			return TrafficRecord{Conn: "c", Event: "frame", Direction: direction, Opcode: websocket.TextMessage, Payload: payload}
+		}
+		return []TrafficRecord{
+			{Conn: "c", Event: "handshake", Subprotocol: subprotocol},
+			frame("out", `{"id":"1","type":"`+start+`","payload":`+query+`}`),
+			frame("in", `{"id":"1","type":"`+next+`","payload":{"data":{"sessions":[]}}}`),
+			frame("in", `{"id":"1","type":"complete"}`),
+		}
+	}
+	tests := []struct {
+		name            string
+		recorded        []TrafficRecord
+		subprotocol     string
+		start, wantNext string
+	}{
+		{name: "recorded as graphql-transport-ws", recorded: recording(subprotocolTransportWS, "subscribe", "next"), subprotocol: subprotocolGraphQLWS, start: "start", wantNext: "data"},
+		{name: "recorded as graphql-ws", recorded: recording(subprotocolGraphQLWS, "start", "data"), subprotocol: subprotocolTransportWS, start: "subscribe", wantNext: "next"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(newReplayServer(tt.recorded, 0))
+			defer ts.Close()
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), http.Header{"Sec-WebSocket-Protocol": {tt.subprotocol}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			conn.WriteJSON(GraphQLMessage{Type: "connection_init"})
+			conn.WriteJSON(GraphQLMessage{ID: "7", Type: tt.start, Payload: json.RawMessage(query)})
+			for _, want := range []string{"connection_ack", tt.wantNext, "complete"} {
+				var msg GraphQLMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.Type != want {
+					t.Fatalf("got %s, want %s", msg.Type, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RunReport summarizes a run for archiving and comparison. Latencies are in
+// milliseconds.
+type RunReport struct {
+	Started         time.Time         `json:"started"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Connections     ConnectionReport  `json:"connections"`
+	Operations      []OperationReport `json:"operations"`
+	Errors          map[string]uint64 `json:"errors"`
+	Dropped         uint64            `json:"droppedIterations,omitempty"`
+	Sequences       []SequenceResult  `json:"sequences,omitempty"`
+	Soak            *SoakReport       `json:"soak,omitempty"`
+	Timeline        []TimelinePoint   `json:"timeline,omitempty"`
+}
+
+type ConnectionReport struct {
+	Opened     uint64            `json:"opened"`
+	CloseCodes map[string]uint64 `json:"closeCodes"`
+}
+
+type OperationReport struct {
+	Name       string  `json:"name"`
+	Count      uint64  `json:"count"`
+	Errors     uint64  `json:"errors"`
+	ErrorRate  float64 `json:"errorRate"`
+	Throughput float64 `json:"throughput"`
+	MinMs      float64 `json:"minMs"`
+	MeanMs     float64 `json:"meanMs"`
+	P50Ms      float64 `json:"p50Ms"`
+	P90Ms      float64 `json:"p90Ms"`
+	P95Ms      float64 `json:"p95Ms"`
+	P99Ms      float64 `json:"p99Ms"`
+	MaxMs      float64 `json:"maxMs"`
+	// Histogram holds the non-empty latency buckets, for comparing runs.
+	Histogram []HistogramBucket `json:"histogram,omitempty"`
+}
+
+// HistogramBucket counts the latencies up to UpperMs, and above the previous
+// bucket's bound.
+type HistogramBucket struct {
+	UpperMs float64 `json:"upperMs"`
+	Count   uint64  `json:"count"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newRunReport(snap Snapshot) *RunReport {
+	report := &RunReport{
+		Started:         snap.Started,
+		DurationSeconds: snap.Elapsed.Seconds(),
+		Connections:     ConnectionReport{Opened: snap.ConnsOpened, CloseCodes: snap.CloseCodes},
+		Errors:          snap.Errors,
+		Sequences:       snap.Sequences,
+		Dropped:         snap.Dropped,
+		Timeline:        snap.Timeline,
+	}
+	for _, op := range snap.Operations {
+		h := &op.Latency
+		r := OperationReport{
+			Name:   op.Name,
+			Count:  op.Count,
+			Errors: op.Errors,
+			MinMs:  milliseconds(h.Min),
+			MeanMs: milliseconds(h.Mean()),
+			P50Ms:  milliseconds(h.Percentile(50)),
+			P90Ms:  milliseconds(h.Percentile(90)),
+			P95Ms:  milliseconds(h.Percentile(95)),
+			P99Ms:  milliseconds(h.Percentile(99)),
+			MaxMs:  milliseconds(h.Max),
+		}
+		for i, n := range h.Counts {
+			if n > 0 {
+				r.Histogram = append(r.Histogram, HistogramBucket{UpperMs: milliseconds(histogramUpper(i)), Count: n})
+			}
+		}
+		if op.Count > 0 {
+			r.ErrorRate = float64(op.Errors) / float64(op.Count)
+		}
+		if report.DurationSeconds > 0 {
+			r.Throughput = float64(op.Count) / report.DurationSeconds
+		}
+		report.Operations = append(report.Operations, r)
+	}
+	return report
+}
+
+// writeReport writes the report as CSV, one row per operation, if path ends
+// in .csv, as an HTML page with charts if it ends in .html, and as JSON
+// otherwise.
+func writeReport(path string, report *RunReport) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return writeReportCSV(path, report)
+	case ".html", ".htm":
+		return writeReportHTML(path, report)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func writeReportCSV(path string, report *RunReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"operation", "count", "errors", "error_rate", "throughput", "min_ms", "mean_ms", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "max_ms"})
+	for _, op := range report.Operations {
+		row := []string{op.Name, fmt.Sprint(op.Count), fmt.Sprint(op.Errors)}
+		for _, v := range []float64{op.ErrorRate, op.Throughput, op.MinMs, op.MeanMs, op.P50Ms, op.P90Ms, op.P95Ms, op.P99Ms, op.MaxMs} {
+			row = append(row, fmt.Sprintf("%.3f", v))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
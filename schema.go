@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const introspectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind name description
+  fields(includeDeprecated: true) { name description args { ...InputValue } type { ...TypeRef } isDeprecated deprecationReason }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue }
+fragment TypeRef on __Type {
+  kind name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
+
+// Schema is the `__schema` object returned by the introspection query. It is
+// cached to disk as JSON so operations can be validated without a connection.
+type Schema struct {
+	QueryType        *NamedRef       `json:"queryType"`
+	MutationType     *NamedRef       `json:"mutationType"`
+	SubscriptionType *NamedRef       `json:"subscriptionType"`
+	Types            []*FullType     `json:"types"`
+	Directives       []*DirectiveDef `json:"directives"`
+
+	types map[string]*FullType
+}
+
+type NamedRef struct {
+	Name string `json:"name"`
+}
+
+type FullType struct {
+	Kind          string        `json:"kind"`
+	Name          string        `json:"name"`
+	Description   string        `json:"description,omitempty"`
+	Fields        []*FieldDef   `json:"fields"`
+	InputFields   []*InputValue `json:"inputFields"`
+	Interfaces    []*TypeRef    `json:"interfaces"`
+	EnumValues    []*EnumValue  `json:"enumValues"`
+	PossibleTypes []*TypeRef    `json:"possibleTypes"`
+}
+
+type FieldDef struct {
+	Name              string        `json:"name"`
+	Description       string        `json:"description,omitempty"`
+	Args              []*InputValue `json:"args"`
+	Type              *TypeRef      `json:"type"`
+	IsDeprecated      bool          `json:"isDeprecated"`
+	DeprecationReason string        `json:"deprecationReason,omitempty"`
+}
+
+type InputValue struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Type         *TypeRef `json:"type"`
+	DefaultValue *string  `json:"defaultValue"`
+}
+
+type EnumValue struct {
+	Name              string `json:"name"`
+	Description       string `json:"description,omitempty"`
+	IsDeprecated      bool   `json:"isDeprecated"`
+	DeprecationReason string `json:"deprecationReason,omitempty"`
+}
+
+type DirectiveDef struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Locations   []string      `json:"locations"`
+	Args        []*InputValue `json:"args"`
+}
+
+// TypeRef is a possibly wrapped (NON_NULL, LIST) reference to a named type.
+type TypeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name,omitempty"`
+	OfType *TypeRef `json:"ofType,omitempty"`
+}
+
+func (t *TypeRef) String() string {
+	switch t.Kind {
+	case "NON_NULL":
+		return t.OfType.String() + "!"
+	case "LIST":
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// NamedType unwraps all NON_NULL and LIST wrappers.
+func (t *TypeRef) NamedType() string {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t.Name
+}
+
+func (s *Schema) index() {
+	s.types = make(map[string]*FullType, len(s.Types))
+	for _, t := range s.Types {
+		s.types[t.Name] = t
+	}
+}
+
+func (s *Schema) Type(name string) *FullType {
+	return s.types[name]
+}
+
+// RootType returns the root object type for an operation type, or nil if the
+// schema does not support it.
+func (s *Schema) RootType(op OperationType) *FullType {
+	var ref *NamedRef
+	switch op {
+	case OperationQuery:
+		ref = s.QueryType
+	case OperationMutation:
+		ref = s.MutationType
+	case OperationSubscription:
+		ref = s.SubscriptionType
+	}
+	if ref == nil {
+		return nil
+	}
+	return s.types[ref.Name]
+}
+
+func (t *FullType) Field(name string) *FieldDef {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (t *FullType) InputField(name string) *InputValue {
+	for _, f := range t.InputFields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (t *FullType) HasEnumValue(name string) bool {
+	for _, v := range t.EnumValues {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *FullType) IsComposite() bool {
+	return t.Kind == "OBJECT" || t.Kind == "INTERFACE" || t.Kind == "UNION"
+}
+
+func (t *FullType) IsInput() bool {
+	return t.Kind == "SCALAR" || t.Kind == "ENUM" || t.Kind == "INPUT_OBJECT"
+}
+
+// parseIntrospection accepts either a full `{"data": {"__schema": ...}}`
+// response payload or the cached `__schema` object.
+func parseIntrospection(data []byte) (*Schema, error) {
+	var envelope struct {
+		Data *struct {
+			Schema *Schema `json:"__schema"`
+		} `json:"data"`
+		Schema *Schema `json:"__schema"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("error unmarshalling introspection result: %w", err)
+	}
+	if len(envelope.Errors) > 0 {
+		return nil, fmt.Errorf("introspection query returned an error: %s", envelope.Errors[0].Message)
+	}
+	schema := envelope.Schema
+	if envelope.Data != nil && envelope.Data.Schema != nil {
+		schema = envelope.Data.Schema
+	}
+	if schema == nil {
+		var raw Schema
+		if err := json.Unmarshal(data, &raw); err != nil || len(raw.Types) == 0 {
+			return nil, fmt.Errorf("introspection result contains no __schema")
+		}
+		schema = &raw
+	}
+	schema.index()
+	return schema, nil
+}
+
+// loadSchema reads a cached introspection result, or an SDL file when path
+// ends in .graphql or .graphqls.
+func loadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schema *Schema
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".graphql" || ext == ".graphqls" {
+		schema, err = parseSDL(string(data))
+	} else {
+		schema, err = parseIntrospection(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// saveSchema writes the schema as JSON to path and as SDL next to it, with the
+// extension replaced by .graphql. path itself can't end in .graphql, or the
+// SDL would overwrite the JSON.
+func saveSchema(schema *Schema, path string) (string, error) {
+	sdlPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".graphql"
+	if sdlPath == path {
+		return "", fmt.Errorf("%s would be overwritten by the SDL; use a .json path", path)
+	}
+	data, err := json.MarshalIndent(map[string]*Schema{"__schema": schema}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(sdlPath, []byte(printSDL(schema)), 0o644); err != nil {
+		return "", err
+	}
+	return sdlPath, nil
+}
+
+var builtinScalars = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+
+// printSDL renders the schema in GraphQL schema definition language, skipping
+// introspection and built-in scalar types.
+func printSDL(s *Schema) string {
+	var sb strings.Builder
+
+	sb.WriteString("schema {\n")
+	if s.QueryType != nil {
+		fmt.Fprintf(&sb, "  query: %s\n", s.QueryType.Name)
+	}
+	if s.MutationType != nil {
+		fmt.Fprintf(&sb, "  mutation: %s\n", s.MutationType.Name)
+	}
+	if s.SubscriptionType != nil {
+		fmt.Fprintf(&sb, "  subscription: %s\n", s.SubscriptionType.Name)
+	}
+	sb.WriteString("}\n")
+
+	types := make([]*FullType, 0, len(s.Types))
+	for _, t := range s.Types {
+		if strings.HasPrefix(t.Name, "__") || builtinScalars[t.Name] {
+			continue
+		}
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+	for _, t := range types {
+		sb.WriteString("\n")
+		writeDescription(&sb, t.Description, "")
+		switch t.Kind {
+		case "SCALAR":
+			fmt.Fprintf(&sb, "scalar %s\n", t.Name)
+		case "ENUM":
+			fmt.Fprintf(&sb, "enum %s {\n", t.Name)
+			for _, v := range t.EnumValues {
+				writeDescription(&sb, v.Description, "  ")
+				fmt.Fprintf(&sb, "  %s%s\n", v.Name, deprecation(v.IsDeprecated, v.DeprecationReason))
+			}
+			sb.WriteString("}\n")
+		case "UNION":
+			names := make([]string, len(t.PossibleTypes))
+			for i, p := range t.PossibleTypes {
+				names[i] = p.Name
+			}
+			fmt.Fprintf(&sb, "union %s = %s\n", t.Name, strings.Join(names, " | "))
+		case "INPUT_OBJECT":
+			fmt.Fprintf(&sb, "input %s {\n", t.Name)
+			for _, f := range t.InputFields {
+				writeDescription(&sb, f.Description, "  ")
+				fmt.Fprintf(&sb, "  %s\n", inputValueSDL(f))
+			}
+			sb.WriteString("}\n")
+		case "OBJECT", "INTERFACE":
+			keyword := "type"
+			if t.Kind == "INTERFACE" {
+				keyword = "interface"
+			}
+			fmt.Fprintf(&sb, "%s %s", keyword, t.Name)
+			if len(t.Interfaces) > 0 {
+				names := make([]string, len(t.Interfaces))
+				for i, iface := range t.Interfaces {
+					names[i] = iface.Name
+				}
+				fmt.Fprintf(&sb, " implements %s", strings.Join(names, " & "))
+			}
+			sb.WriteString(" {\n")
+			for _, f := range t.Fields {
+				writeDescription(&sb, f.Description, "  ")
+				fmt.Fprintf(&sb, "  %s", f.Name)
+				if len(f.Args) > 0 {
+					args := make([]string, len(f.Args))
+					for i, a := range f.Args {
+						args[i] = inputValueSDL(a)
+					}
+					fmt.Fprintf(&sb, "(%s)", strings.Join(args, ", "))
+				}
+				fmt.Fprintf(&sb, ": %s%s\n", f.Type, deprecation(f.IsDeprecated, f.DeprecationReason))
+			}
+			sb.WriteString("}\n")
+		}
+	}
+	return sb.String()
+}
+
+func inputValueSDL(v *InputValue) string {
+	s := v.Name + ": " + v.Type.String()
+	if v.DefaultValue != nil {
+		s += " = " + *v.DefaultValue
+	}
+	return s
+}
+
+func deprecation(deprecated bool, reason string) string {
+	if !deprecated {
+		return ""
+	}
+	if reason == "" {
+		return " @deprecated"
+	}
+	b, _ := json.Marshal(reason)
+	return fmt.Sprintf(" @deprecated(reason: %s)", b)
+}
+
+func writeDescription(sb *strings.Builder, desc, indent string) {
+	if desc == "" {
+		return
+	}
+	if !strings.Contains(desc, "\n") {
+		b, _ := json.Marshal(desc)
+		fmt.Fprintf(sb, "%s%s\n", indent, b)
+		return
+	}
+	fmt.Fprintf(sb, "%s\"\"\"\n", indent)
+	for _, line := range strings.Split(desc, "\n") {
+		fmt.Fprintf(sb, "%s%s\n", indent, line)
+	}
+	fmt.Fprintf(sb, "%s\"\"\"\n", indent)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrintSDL(t *testing.T) {
+	tests := []struct {
+		name string
+		sdl  string
+		want string
+	}{
+		{
+			name: "objects, enums and arguments",
+			sdl: `
+"A thing."
+type Thing implements Node {
+  id: ID!
+  "Its colour."
+  colour(dark: Boolean = false): Colour @deprecated(reason: "use hue")
+}
+interface Node { id: ID! }
+enum Colour { RED GREEN }
+type Query { things(first: Int!): [Thing!]! }`,
+			want: `schema {
+  query: Query
+}
+
+enum Colour {
+  RED
+  GREEN
+}
+
+interface Node {
+  id: ID!
+}
+
+type Query {
+  things(first: Int!): [Thing!]!
+}
+
+"A thing."
+type Thing implements Node {
+  id: ID!
+  "Its colour."
+  colour(dark: Boolean = false): Colour @deprecated(reason: "use hue")
+}
+`,
+		},
+		{
+			name: "inputs, unions and scalars",
+			sdl: `
+scalar Time
+input Filter { after: Time, names: [String!] = [] }
+union Result = A | B
+type A { a: Int }
+type B { b: Int }
+type Query { search(filter: Filter): [Result] }
+type Subscription { ticks: Time }`,
+			want: `schema {
+  query: Query
+  subscription: Subscription
+}
+
+type A {
+  a: Int
+}
+
+type B {
+  b: Int
+}
+
+input Filter {
+  after: Time
+  names: [String!] = []
+}
+
+type Query {
+  search(filter: Filter): [Result]
+}
+
+union Result = A | B
+
+type Subscription {
+  ticks: Time
+}
+
+scalar Time
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := parseSDL(tt.sdl)
+			if err != nil {
+				t.Fatalf("parseSDL: %v", err)
+			}
+			if got := printSDL(schema); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintSDLRoundTrip(t *testing.T) {
+	sdl := printSDL(mockSchema(t))
+	schema, err := parseSDL(sdl)
+	if err != nil {
+		t.Fatalf("parsing printed SDL: %v\n%s", err, sdl)
+	}
+	if again := printSDL(schema); again != sdl {
+		t.Errorf("printing the parsed SDL changed it:\n%s\nwant:\n%s", again, sdl)
+	}
+}
+
+func TestSaveSchema(t *testing.T) {
+	schema := mockSchema(t)
+	dir := t.TempDir()
+	tests := []struct {
+		path    string
+		wantSDL string
+	}{
+		{path: "schema.json", wantSDL: "schema.graphql"},
+		{path: "cache", wantSDL: "cache.graphql"},
+		{path: "my.schema.txt", wantSDL: "my.schema.graphql"},
+		{path: "schema.graphql"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			sdlPath, err := saveSchema(schema, filepath.Join(dir, tt.path))
+			if tt.wantSDL == "" {
+				if err == nil {
+					t.Fatalf("got SDL at %s, want an error", sdlPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("saveSchema: %v", err)
+			}
+			if sdlPath != filepath.Join(dir, tt.wantSDL) {
+				t.Errorf("got SDL at %s, want %s", sdlPath, tt.wantSDL)
+			}
+			if _, err := loadSchema(filepath.Join(dir, tt.path)); err != nil {
+				t.Errorf("loading the saved JSON: %v", err)
+			}
+			if _, err := os.Stat(sdlPath); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
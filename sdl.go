@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// builtinSDL declares the scalars, directives and introspection types every
+// schema has, so they can be left out of schema files.
+const builtinSDL = `
+scalar String
+scalar Int
+scalar Float
+scalar Boolean
+scalar ID
+
+directive @include(if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+directive @skip(if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+directive @deprecated(reason: String = "No longer supported") on FIELD_DEFINITION | ARGUMENT_DEFINITION | INPUT_FIELD_DEFINITION | ENUM_VALUE
+directive @defer(if: Boolean! = true, label: String) on FRAGMENT_SPREAD | INLINE_FRAGMENT
+directive @stream(if: Boolean! = true, label: String, initialCount: Int = 0) on FIELD
+
+type __Schema {
+  description: String
+  types: [__Type!]!
+  queryType: __Type!
+  mutationType: __Type
+  subscriptionType: __Type
+  directives: [__Directive!]!
+}
+
+type __Type {
+  kind: __TypeKind!
+  name: String
+  description: String
+  specifiedByURL: String
+  fields(includeDeprecated: Boolean = false): [__Field!]
+  interfaces: [__Type!]
+  possibleTypes: [__Type!]
+  enumValues(includeDeprecated: Boolean = false): [__EnumValue!]
+  inputFields(includeDeprecated: Boolean = false): [__InputValue!]
+  ofType: __Type
+}
+
+enum __TypeKind { SCALAR OBJECT INTERFACE UNION ENUM INPUT_OBJECT LIST NON_NULL }
+
+type __Field {
+  name: String!
+  description: String
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  type: __Type!
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __InputValue {
+  name: String!
+  description: String
+  type: __Type!
+  defaultValue: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __EnumValue {
+  name: String!
+  description: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __Directive {
+  name: String!
+  description: String
+  locations: [__DirectiveLocation!]!
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  isRepeatable: Boolean!
+}
+
+enum __DirectiveLocation {
+  QUERY MUTATION SUBSCRIPTION FIELD FRAGMENT_DEFINITION FRAGMENT_SPREAD INLINE_FRAGMENT VARIABLE_DEFINITION
+  SCHEMA SCALAR OBJECT FIELD_DEFINITION ARGUMENT_DEFINITION INTERFACE UNION ENUM ENUM_VALUE INPUT_OBJECT INPUT_FIELD_DEFINITION
+}
+`
+
+// parseSDL builds a Schema from a GraphQL schema definition document, adding
+// built-in scalars, directives and introspection types not defined in it.
+func parseSDL(src string) (*Schema, error) {
+	schema := &Schema{}
+	seen := make(map[string]bool)
+	if err := parseTypeSystem(src, schema, seen); err != nil {
+		return nil, err
+	}
+	if err := parseTypeSystem(builtinSDL, schema, seen); err != nil {
+		return nil, fmt.Errorf("built-in definitions: %w", err)
+	}
+
+	if schema.QueryType == nil && seen["Query"] {
+		schema.QueryType = &NamedRef{Name: "Query"}
+	}
+	if schema.MutationType == nil && seen["Mutation"] {
+		schema.MutationType = &NamedRef{Name: "Mutation"}
+	}
+	if schema.SubscriptionType == nil && seen["Subscription"] {
+		schema.SubscriptionType = &NamedRef{Name: "Subscription"}
+	}
+	schema.index()
+
+	for _, t := range schema.Types {
+		for _, iface := range t.Interfaces {
+			if it := schema.Type(iface.Name); it != nil {
+				it.PossibleTypes = append(it.PossibleTypes, &TypeRef{Kind: "OBJECT", Name: t.Name})
+			}
+		}
+	}
+	// Named references were parsed without knowing the kind of their type.
+	var resolve func(*TypeRef)
+	resolve = func(ref *TypeRef) {
+		if ref == nil {
+			return
+		}
+		if ref.OfType != nil {
+			resolve(ref.OfType)
+			return
+		}
+		if t := schema.Type(ref.Name); t != nil {
+			ref.Kind = t.Kind
+		}
+	}
+	for _, t := range schema.Types {
+		for _, f := range t.Fields {
+			resolve(f.Type)
+			for _, a := range f.Args {
+				resolve(a.Type)
+			}
+		}
+		for _, f := range t.InputFields {
+			resolve(f.Type)
+		}
+		for _, ref := range t.Interfaces {
+			resolve(ref)
+		}
+		for _, ref := range t.PossibleTypes {
+			resolve(ref)
+		}
+	}
+	for _, d := range schema.Directives {
+		for _, a := range d.Args {
+			resolve(a.Type)
+		}
+	}
+	return schema, nil
+}
+
+func parseTypeSystem(src string, schema *Schema, seen map[string]bool) error {
+	p := &parser{lex: &lexer{src: []rune(src), line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	for p.tok.kind != tokEOF {
+		desc, err := p.description()
+		if err != nil {
+			return err
+		}
+		if p.tok.kind != tokName {
+			return p.unexpected()
+		}
+		loc := p.tok.loc
+		keyword := p.tok.value
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		if keyword == "schema" {
+			if err := p.schemaDefinition(schema); err != nil {
+				return err
+			}
+			continue
+		}
+		if keyword == "directive" {
+			d, err := p.directiveDefinition(desc)
+			if err != nil {
+				return err
+			}
+			exists := false
+			for _, existing := range schema.Directives {
+				exists = exists || existing.Name == d.Name
+			}
+			if !exists {
+				schema.Directives = append(schema.Directives, d)
+			}
+			continue
+		}
+
+		t := &FullType{Description: desc}
+		switch keyword {
+		case "scalar":
+			t.Kind = "SCALAR"
+		case "type":
+			t.Kind = "OBJECT"
+		case "interface":
+			t.Kind = "INTERFACE"
+		case "union":
+			t.Kind = "UNION"
+		case "enum":
+			t.Kind = "ENUM"
+		case "input":
+			t.Kind = "INPUT_OBJECT"
+		default:
+			return &SyntaxError{Loc: loc, Message: fmt.Sprintf("unsupported definition %q", keyword)}
+		}
+		if t.Name, err = p.name(); err != nil {
+			return err
+		}
+		if err := p.typeBody(t); err != nil {
+			return err
+		}
+		if seen[t.Name] {
+			if strings.HasPrefix(t.Name, "__") || builtinScalars[t.Name] {
+				continue
+			}
+			return &SyntaxError{Loc: loc, Message: fmt.Sprintf("type %q is defined more than once", t.Name)}
+		}
+		seen[t.Name] = true
+		schema.Types = append(schema.Types, t)
+	}
+	return nil
+}
+
+func (p *parser) description() (string, error) {
+	if p.tok.kind != tokString {
+		return "", nil
+	}
+	desc := strings.TrimSpace(p.tok.value)
+	return desc, p.advance()
+}
+
+func (p *parser) schemaDefinition(schema *Schema) error {
+	if _, err := p.directives(); err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.is("}") {
+		op, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "query":
+			schema.QueryType = &NamedRef{Name: name}
+		case "mutation":
+			schema.MutationType = &NamedRef{Name: name}
+		case "subscription":
+			schema.SubscriptionType = &NamedRef{Name: name}
+		}
+	}
+	return p.advance()
+}
+
+func (p *parser) directiveDefinition(desc string) (*DirectiveDef, error) {
+	if err := p.expect("@"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	d := &DirectiveDef{Name: name, Description: desc, Args: []*InputValue{}}
+	if p.is("(") {
+		if d.Args, err = p.inputValueDefs("(", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.tok.kind == tokName && p.tok.value == "repeatable" {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.tok.kind != tokName || p.tok.value != "on" {
+		return nil, &SyntaxError{Loc: p.tok.loc, Message: "expected \"on\" in directive definition"}
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.is("|") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		loc, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d.Locations = append(d.Locations, loc)
+		if !p.is("|") {
+			return d, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) typeBody(t *FullType) error {
+	if t.Kind == "OBJECT" || t.Kind == "INTERFACE" {
+		if p.tok.kind == tokName && p.tok.value == "implements" {
+			if err := p.advance(); err != nil {
+				return err
+			}
+			if p.is("&") {
+				if err := p.advance(); err != nil {
+					return err
+				}
+			}
+			for p.tok.kind == tokName {
+				t.Interfaces = append(t.Interfaces, &TypeRef{Kind: "INTERFACE", Name: p.tok.value})
+				if err := p.advance(); err != nil {
+					return err
+				}
+				if !p.is("&") {
+					break
+				}
+				if err := p.advance(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return err
+	}
+
+	var err error
+	switch t.Kind {
+	case "OBJECT", "INTERFACE":
+		if p.is("{") {
+			t.Fields, err = p.fieldDefs()
+		}
+	case "INPUT_OBJECT":
+		if p.is("{") {
+			t.InputFields, err = p.inputValueDefs("{", "}")
+		}
+	case "ENUM":
+		if p.is("{") {
+			t.EnumValues, err = p.enumValueDefs()
+		}
+	case "UNION":
+		if p.is("=") {
+			if err := p.advance(); err != nil {
+				return err
+			}
+			if p.is("|") {
+				if err := p.advance(); err != nil {
+					return err
+				}
+			}
+			for {
+				name, err := p.name()
+				if err != nil {
+					return err
+				}
+				t.PossibleTypes = append(t.PossibleTypes, &TypeRef{Kind: "OBJECT", Name: name})
+				if !p.is("|") {
+					break
+				}
+				if err := p.advance(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return err
+}
+
+func (p *parser) fieldDefs() ([]*FieldDef, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*FieldDef
+	for !p.is("}") {
+		desc, err := p.description()
+		if err != nil {
+			return nil, err
+		}
+		f := &FieldDef{Description: desc, Args: []*InputValue{}}
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if p.is("(") {
+			if f.Args, err = p.inputValueDefs("(", ")"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		f.Type = typeNodeRef(typ)
+		dirs, err := p.directives()
+		if err != nil {
+			return nil, err
+		}
+		f.IsDeprecated, f.DeprecationReason = deprecatedDirective(dirs)
+		fields = append(fields, f)
+	}
+	return fields, p.advance()
+}
+
+func (p *parser) inputValueDefs(open, close string) ([]*InputValue, error) {
+	if err := p.expect(open); err != nil {
+		return nil, err
+	}
+	values := []*InputValue{}
+	for !p.is(close) {
+		desc, err := p.description()
+		if err != nil {
+			return nil, err
+		}
+		v := &InputValue{Description: desc}
+		if v.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		v.Type = typeNodeRef(typ)
+		if p.is("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			start := p.tok.start
+			if _, err := p.value(true); err != nil {
+				return nil, err
+			}
+			def := p.source(start)
+			v.DefaultValue = &def
+		}
+		if _, err := p.directives(); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, p.advance()
+}
+
+func (p *parser) enumValueDefs() ([]*EnumValue, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var values []*EnumValue
+	for !p.is("}") {
+		desc, err := p.description()
+		if err != nil {
+			return nil, err
+		}
+		v := &EnumValue{Description: desc}
+		if v.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		dirs, err := p.directives()
+		if err != nil {
+			return nil, err
+		}
+		v.IsDeprecated, v.DeprecationReason = deprecatedDirective(dirs)
+		values = append(values, v)
+	}
+	return values, p.advance()
+}
+
+func deprecatedDirective(dirs []*Directive) (bool, string) {
+	for _, d := range dirs {
+		if d.Name != "deprecated" {
+			continue
+		}
+		for _, arg := range d.Args {
+			if arg.Name == "reason" && arg.Value.Kind == ValueString {
+				return true, arg.Value.Raw
+			}
+		}
+		return true, ""
+	}
+	return false, ""
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// maxGaps bounds how many gaps are remembered per stream to recognize late
+// arrivals. Past that, the oldest gap is given up on: its numbers stay
+// missing and late arrivals for it count as duplicates.
+const maxGaps = 10000
+
+// sequenceStream is the state of one checked stream: a subscription, or one
+// key within it. Numbers below the first one received are late arrivals
+// rather than missing.
+type sequenceStream struct {
+	started bool
+	first   int64
+	last    int64
+	// seen holds the numbers received, as sorted ranges with gaps between
+	// them.
+	seen []sequenceRange
+}
+
+type sequenceRange struct{ lo, hi int64 }
+
+// index returns the position of the first range ending at or after seq-1,
+// which is where seq belongs, and whether seq was seen.
+func (s *sequenceStream) index(seq int64) (int, bool) {
+	i := sort.Search(len(s.seen), func(i int) bool { return s.seen[i].hi >= seq-1 })
+	return i, i < len(s.seen) && s.seen[i].lo <= seq && seq <= s.seen[i].hi
+}
+
+// add records seq, which has not been seen, at the position index returned.
+func (s *sequenceStream) add(i int, seq int64) {
+	switch {
+	case i < len(s.seen) && s.seen[i].hi == seq-1:
+		s.seen[i].hi = seq
+		if i+1 < len(s.seen) && s.seen[i+1].lo == seq+1 {
+			s.seen[i].hi = s.seen[i+1].hi
+			s.seen = append(s.seen[:i+1], s.seen[i+2:]...)
+		}
+	case i < len(s.seen) && s.seen[i].lo == seq+1:
+		s.seen[i].lo = seq
+	default:
+		s.seen = append(s.seen, sequenceRange{})
+		copy(s.seen[i+1:], s.seen[i:])
+		s.seen[i] = sequenceRange{seq, seq}
+	}
+	if len(s.seen) > maxGaps+1 {
+		s.seen[1].lo = s.seen[0].lo
+		s.seen = s.seen[1:]
+	}
+}
+
+// SequenceResult counts what a SequenceChecker saw on one subscription.
+// Missing is the number of sequence numbers skipped that haven't arrived
+// since.
+type SequenceResult struct {
+	Subscription string `json:"subscription"`
+	Events       uint64 `json:"events"`
+	Gaps         uint64 `json:"gaps"`
+	Missing      uint64 `json:"missing"`
+	Duplicates   uint64 `json:"duplicates"`
+	OutOfOrder   uint64 `json:"outOfOrder"`
+	Unsequenced  uint64 `json:"unsequenced"`
+}
+
+// SequenceChecker checks that a sequence or version field in `next`
+// payloads increases by one from event to event. With a key path, each
+// value of the key is checked separately, e.g. a version per session.
+// Streams are identified by subscription name rather than operation ID, so
+// checking continues when a subscription is re-established. A nil
+// *SequenceChecker checks nothing.
+type SequenceChecker struct {
+	field []pathSegment
+	key   []pathSegment
+
+	mu      sync.Mutex
+	streams map[[2]string]*sequenceStream
+	results map[string]*SequenceResult
+}
+
+// sequences is the checker enabled with -sequence-field, if any.
+var sequences *SequenceChecker
+
+func NewSequenceChecker(field, key string) (*SequenceChecker, error) {
+	c := &SequenceChecker{
+		streams: make(map[[2]string]*sequenceStream),
+		results: make(map[string]*SequenceResult),
+	}
+	var err error
+	if c.field, err = parsePath(field); err != nil {
+		return nil, fmt.Errorf("invalid sequence field: %w", err)
+	}
+	if key != "" {
+		if c.key, err = parsePath(key); err != nil {
+			return nil, fmt.Errorf("invalid sequence key: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// startSequenceCheck enables the global checker when field is not empty.
+func startSequenceCheck(field, key string) {
+	if field == "" {
+		return
+	}
+	c, err := NewSequenceChecker(field, key)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sequences = c
+}
+
+// Check classifies the next event of a subscription.
+func (c *SequenceChecker) Check(subscription string, payload json.RawMessage) {
+	if c == nil {
+		return
+	}
+	var v interface{}
+	json.Unmarshal(payload, &v)
+	seq, ok := sequenceNumber(selectPath(v, c.field))
+	var key string
+	if c.key != nil {
+		if keys := selectPath(v, c.key); len(keys) == 1 {
+			key = fmt.Sprint(keys[0])
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, found := c.results[subscription]
+	if !found {
+		res = &SequenceResult{Subscription: subscription}
+		c.results[subscription] = res
+	}
+	res.Events++
+	if !ok {
+		res.Unsequenced++
+		metrics.Sequence(subscription, "unsequenced", res.Missing)
+		return
+	}
+	stream, found := c.streams[[2]string{subscription, key}]
+	if !found {
+		stream = &sequenceStream{}
+		c.streams[[2]string{subscription, key}] = stream
+	}
+
+	logger := slog.With("subscription", subscription, "sequence", seq)
+	if key != "" {
+		logger = logger.With("key", key)
+	}
+	result := "ok"
+	i, seen := stream.index(seq)
+	switch {
+	case !stream.started:
+		stream.started = true
+		stream.first, stream.last = seq, seq
+	case seen:
+		result = "duplicate"
+		res.Duplicates++
+		logger.Warn("Duplicate event", "last", stream.last)
+	case seq == stream.last+1:
+		stream.last = seq
+	case seq > stream.last:
+		result = "gap"
+		res.Gaps++
+		res.Missing += uint64(seq - stream.last - 1)
+		logger.Warn("Sequence gap", "expected", stream.last+1, "missed", seq-stream.last-1)
+		stream.last = seq
+	default:
+		result = "out_of_order"
+		res.OutOfOrder++
+		if seq > stream.first {
+			res.Missing--
+		}
+		logger.Warn("Out of order event", "last", stream.last)
+	}
+	if !seen {
+		stream.add(i, seq)
+	}
+	metrics.Sequence(subscription, result, res.Missing)
+}
+
+// sequenceNumber reads a single selected value as an integer, from a JSON
+// number or a numeric string.
+func sequenceNumber(values []interface{}) (int64, bool) {
+	if len(values) != 1 {
+		return 0, false
+	}
+	switch v := values[0].(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Results returns the counts per subscription, sorted by name.
+func (c *SequenceChecker) Results() []SequenceResult {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := make([]SequenceResult, 0, len(c.results))
+	for _, res := range c.results {
+		results = append(results, *res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Subscription < results[j].Subscription })
+	return results
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestSequenceCheckerCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		// events are sequence numbers, or "key:sequence" with a key path;
+		// -1 is an event without a sequence.
+		events []string
+		want   SequenceResult
+	}{
+		{
+			name:   "in order",
+			events: []string{"1", "2", "3"},
+			want:   SequenceResult{Events: 3},
+		},
+		{
+			name:   "starts anywhere",
+			events: []string{"41", "42"},
+			want:   SequenceResult{Events: 2},
+		},
+		{
+			name:   "gap",
+			events: []string{"1", "2", "5", "6"},
+			want:   SequenceResult{Events: 4, Gaps: 1, Missing: 2},
+		},
+		{
+			name:   "late arrival fills a gap",
+			events: []string{"1", "4", "2", "5"},
+			want:   SequenceResult{Events: 4, Gaps: 1, Missing: 1, OutOfOrder: 1},
+		},
+		{
+			name:   "duplicate",
+			events: []string{"1", "2", "2", "3"},
+			want:   SequenceResult{Events: 4, Duplicates: 1},
+		},
+		{
+			name:   "late arrival twice",
+			events: []string{"1", "3", "2", "2"},
+			want:   SequenceResult{Events: 4, Gaps: 1, OutOfOrder: 1, Duplicates: 1},
+		},
+		{
+			name:   "older than the start",
+			events: []string{"5", "3", "3", "4"},
+			want:   SequenceResult{Events: 4, OutOfOrder: 2, Duplicates: 1},
+		},
+		{
+			name:   "gap larger than the gaps remembered",
+			events: []string{"1", fmt.Sprint(maxGaps * 3), "2", fmt.Sprint(maxGaps*3 - 1), "2"},
+			want:   SequenceResult{Events: 5, Gaps: 1, Missing: maxGaps*3 - 4, OutOfOrder: 2, Duplicates: 1},
+		},
+		{
+			name:   "late arrivals fill a gap from both ends",
+			events: []string{"1", "5", "2", "4", "3", "3"},
+			want:   SequenceResult{Events: 6, Gaps: 1, OutOfOrder: 3, Duplicates: 1},
+		},
+		{
+			name:   "unsequenced",
+			events: []string{"1", "-1", "2"},
+			want:   SequenceResult{Events: 3, Unsequenced: 1},
+		},
+		{
+			name:   "per key",
+			key:    "key",
+			events: []string{"a:1", "b:7", "a:2", "b:8", "a:4", "b:8"},
+			want:   SequenceResult{Events: 6, Gaps: 1, Missing: 1, Duplicates: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewSequenceChecker("seq", tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range tt.events {
+				var key string
+				var seq int
+				if tt.key != "" {
+					fmt.Sscanf(ev, "%1s:%d", &key, &seq)
+				} else {
+					fmt.Sscanf(ev, "%d", &seq)
+				}
+				payload := map[string]interface{}{"key": key}
+				if seq >= 0 {
+					payload["seq"] = seq
+				}
+				b, _ := json.Marshal(payload)
+				c.Check("updates", b)
+			}
+			results := c.Results()
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			tt.want.Subscription = "updates"
+			if results[0] != tt.want {
+				t.Errorf("got %+v, want %+v", results[0], tt.want)
+			}
+		})
+	}
+}
+
+// Once more gaps are outstanding than are remembered, the oldest is given
+// up on and only the others still recognize late arrivals.
+func TestSequenceCheckerForgetsOldestGap(t *testing.T) {
+	c, err := NewSequenceChecker("seq", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(seq int) {
+		c.Check("updates", json.RawMessage(fmt.Sprintf(`{"seq": %d}`, seq)))
+	}
+	// Every other number is skipped.
+	for seq := 0; seq <= 2*(maxGaps+1); seq += 2 {
+		check(seq)
+	}
+	check(1)
+	check(2*maxGaps + 1)
+	want := SequenceResult{Subscription: "updates", Events: maxGaps + 4, Gaps: maxGaps + 1, Missing: maxGaps, Duplicates: 1, OutOfOrder: 1}
+	if got := c.Results(); len(got) != 1 || got[0] != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSequenceCheckerNumericStrings(t *testing.T) {
+	c, err := NewSequenceChecker("data.version", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Check("updates", json.RawMessage(`{"data": {"version": "9"}}`))
+	c.Check("updates", json.RawMessage(`{"data": {"version": 10}}`))
+	c.Check("updates", json.RawMessage(`{"data": {"version": "v11"}}`))
+	want := SequenceResult{Subscription: "updates", Events: 3, Unsequenced: 1}
+	if got := c.Results(); len(got) != 1 || got[0] != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSubscriptionLost(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{GraphQLErrors{{Message: "unauthorized"}}, false},
+		{fmt.Errorf("subscription: %w", GraphQLErrors{{Message: "unauthorized"}}), false},
+		{fmt.Errorf("websocket: close 1006 (abnormal closure)"), true},
+	}
+	for _, tt := range tests {
+		if got := subscriptionLost(tt.err); got != tt.want {
+			t.Errorf("subscriptionLost(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
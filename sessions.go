@@ -1,106 +1,58 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/gorilla/websocket"
-	"log"
+	"log/slog"
+	"time"
 )
 
-func createAndDeleteSession(conn *websocket.Conn) {
-	createQuery := `mutation createSessions($input: [CreateSessionInput!]!) { createSessions(input: $input) { sessions { id name } } }`
-	createVars := `{"input": [{"name": "CreateSession"}]}`
-
-	send(conn, OperationMutation, "createSession", createQuery, createVars)
-
-	var sessionID string
-	for {
-		message, err := readResponse(conn)
-		if err != nil {
-			log.Fatalf("Error reading createSessions response: %v", err)
-		}
-		var wsMsg GraphQLMessage
-		if err := json.Unmarshal(message, &wsMsg); err != nil {
-			log.Printf("Error unmarshalling GraphQLMessage: %v", err)
-			continue
-		}
-		if wsMsg.Type == "next" {
-			id, err := parseCreateSessionsResponse(wsMsg.Payload)
-			if err != nil {
-				log.Printf("Error parsing 'next' payload: %v", err)
-				continue
-			}
-			sessionID = id
-			fmt.Printf("createSessions returned id: %s\n", sessionID)
-		} else if wsMsg.Type == "complete" {
-			break
-		}
-	}
-
-	if sessionID == "" {
-		log.Printf("No session ID found, skipping deleteSession")
-		return
-	}
-
-	deleteQuery := `mutation($input: [DeleteSessionInput!]!) { deleteSessions(input: $input) { success } }`
-	deleteVars := fmt.Sprintf(`{"input": [{"id": "%s"}]}`, sessionID)
-
-	send(conn, OperationMutation, "deleteSession", deleteQuery, deleteVars)
-
-	for {
-		message, err := readResponse(conn)
-		if err != nil {
-			log.Fatalf("Error reading deleteSessions response: %v", err)
-		}
-		var wsMsg GraphQLMessage
-		if err := json.Unmarshal(message, &wsMsg); err != nil {
-			log.Printf("Error unmarshalling GraphQLMessage: %v", err)
-			continue
-		}
-		if wsMsg.Type == "next" {
-			fmt.Printf("deleteSessions response: %s\n", string(wsMsg.Payload))
-		} else if wsMsg.Type == "complete" {
-			break
-		}
-	}
+// BuiltinOperation is an operation sent by the workflow, with representative
+// variables so it can be validated before connecting.
+type BuiltinOperation struct {
+	Name      string
+	Query     string
+	Variables string
 }
 
-type CreateSessionsPayload struct {
-	Data struct {
-		CreateSessions struct {
-			Sessions []struct {
-				ID   string `json:"id"`
-				Name string `json:"name"`
-			} `json:"sessions"`
-		} `json:"createSessions"`
-	} `json:"data"`
-	Errors []struct {
-		Message string `json:"message"`
-	} `json:"errors"`
+var builtinOperations = []BuiltinOperation{
+	{Name: "createSession", Query: gqlCreateSessionDocument, Variables: `{"input": [{"name": "CreateSession"}]}`},
+	{Name: "deleteSession", Query: gqlDeleteSessionDocument, Variables: `{"input": [{"id": "00000000-0000-0000-0000-000000000000"}]}`},
 }
 
-func parseCreateSessionsResponse(payload json.RawMessage) (string, error) {
-	if payload == nil || len(payload) == 0 {
-		return "", fmt.Errorf("payload is nil or empty")
+// createAndDeleteSession creates a session and deletes it again. With a
+// verifier, it also waits for the session's CREATED and DELETED updates.
+func createAndDeleteSession(ctx context.Context, exec Executor, verify *sessionVerifier) error {
+	name := "CreateSession"
+	sent := time.Now()
+	resp, err := GQLCreateSession(ctx, exec, GQLCreateSessionVariables{Input: []GQLCreateSessionInput{{Name: &name}}})
+	if resp == nil {
+		return fmt.Errorf("error executing createSession: %w", err)
 	}
-
-	var respPayload CreateSessionsPayload
-	if err := json.Unmarshal(payload, &respPayload); err != nil {
-		return "", fmt.Errorf("error unmarshalling payload into structs: %w", err)
+	if err != nil {
+		slog.Warn("Error parsing createSession result", "err", err)
 	}
-
-	if len(respPayload.Errors) > 0 {
-		return "", fmt.Errorf("GraphQL query returned an error: %s", respPayload.Errors[0].Message)
-	}
-
-	if len(respPayload.Data.CreateSessions.Sessions) == 0 {
-		return "", fmt.Errorf("sessions array not found or is empty in payload")
-	}
-
-	id := respPayload.Data.CreateSessions.Sessions[0].ID
-	if id == "" {
-		return "", fmt.Errorf("session ID was empty in payload")
+	var sessionID string
+	if sessions := resp.CreateSessions.Sessions; len(sessions) > 0 {
+		sessionID = sessions[0].ID
 	}
 
-	return id, nil
+	if sessionID == "" {
+		slog.Warn("No session ID found, skipping deleteSession")
+		return nil
+	}
+	slog.Info("Created session", "session", sessionID)
+	// The session is deleted even if its CREATED update is missing.
+	created := verify.expect(ctx, "createSession", "CREATED", sessionID, sent)
+
+	sent = time.Now()
+	deletedResp, err := GQLDeleteSession(ctx, exec, GQLDeleteSessionVariables{Input: []GQLDeleteSessionInput{{ID: sessionID}}})
+	if deletedResp == nil {
+		return fmt.Errorf("error executing deleteSession: %w", err)
+	}
+	slog.Info("Deleted session", "session", sessionID)
+	slog.Debug("deleteSession result", "success", deletedResp.DeleteSessions.Success, "err", err)
+	deleted := verify.expect(ctx, "deleteSession", "DELETED", sessionID, sent)
+	return errors.Join(created, deleted)
 }
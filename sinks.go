@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SinkEvent is a subscription event on its way to the sinks.
+type SinkEvent struct {
+	Time         time.Time       `json:"time"`
+	Subscription string          `json:"subscription"`
+	ID           string          `json:"id,omitempty"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+// Sink receives subscription events.
+type Sink interface {
+	Write(ev SinkEvent) error
+	Close() error
+}
+
+// stdoutSink prints events, indented if pretty.
+type stdoutSink struct {
+	pretty bool
+}
+
+func (s *stdoutSink) Write(ev SinkEvent) error {
+	payload := []byte(ev.Payload)
+	if s.pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, ev.Payload, "", "  "); err == nil {
+			payload = buf.Bytes()
+		}
+	}
+	label := ev.Subscription
+	if ev.ID != "" {
+		label += " " + shortID(ev.ID)
+	}
+	_, err := fmt.Printf("%s: [%s] Received: %s\n", ev.Time.Format(time.RFC3339), label, payload)
+	return err
+}
+
+func (s *stdoutSink) Close() error { return nil }
+
+// fileSink appends events to a JSONL file. When the file grows past maxSize
+// bytes or gets older than maxAge, it is renamed with a timestamp and a new
+// one is started.
+type fileSink struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func newFileSink(path string, maxSize int64, maxAge time.Duration) (*fileSink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size, s.created = f, info.Size(), time.Now()
+	return nil
+}
+
+// rotate closes the current file and moves it aside, as
+// events-20060102T150405.000.jsonl for events.jsonl.
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(s.path)
+	rotated := strings.TrimSuffix(s.path, ext) + "-" + time.Now().Format("20060102T150405.000") + ext
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	slog.Info("Rotated event file", "path", rotated)
+	return s.open()
+}
+
+func (s *fileSink) Write(ev SinkEvent) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size > 0 && ((s.maxSize > 0 && s.size+int64(len(line)) > s.maxSize) || (s.maxAge > 0 && time.Since(s.created) > s.maxAge)) {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("error rotating %s: %w", s.path, err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// webhookSink POSTs each event as JSON to a URL from a background queue,
+// retrying failed requests with exponential backoff. Events are dropped,
+// with a warning, when the queue is full, and ignored once it is closed.
+type webhookSink struct {
+	url     string
+	retries int
+	client  *http.Client
+	queue   chan SinkEvent
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+const webhookQueueSize = 1024
+
+func newWebhookSink(url string, retries int) *webhookSink {
+	s := &webhookSink{
+		url:     url,
+		retries: retries,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan SinkEvent, webhookQueueSize),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		for ev := range s.queue {
+			if err := s.post(ev); err != nil {
+				slog.Error("Error posting event to webhook", "url", s.url, "subscription", ev.Subscription, "err", err)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *webhookSink) post(ev SinkEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("HTTP %s", resp.Status)
+			if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+				return err
+			}
+		}
+		if attempt >= s.retries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+		slog.Warn("Retrying webhook", "url", s.url, "attempt", attempt+1, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *webhookSink) Write(ev SinkEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.queue <- ev:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full, dropped event")
+	}
+}
+
+// Close waits for queued events to be posted.
+func (s *webhookSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+// sinkRoute sends the events of one subscription, or of all of them if
+// subscription is empty, to a sink.
+type sinkRoute struct {
+	subscription string
+	sink         Sink
+}
+
+// SinkRouter delivers each event to the sinks routed for its subscription.
+type SinkRouter struct {
+	routes []sinkRoute
+}
+
+// parseSinks builds a router from -sink flags, which look like
+// [subscription=]kind[:target][,option=value...]:
+//
+//	stdout, stdout:pretty
+//	file:events.jsonl,max-size=10MB,max-age=1h
+//	webhook:http://localhost:9000/events,retries=5
+//
+// With no flags, every event is printed to stdout.
+func parseSinks(specs []string) (*SinkRouter, error) {
+	if len(specs) == 0 {
+		specs = []string{"stdout"}
+	}
+	r := &SinkRouter{}
+	for _, spec := range specs {
+		var subscription string
+		if name, rest, ok := strings.Cut(spec, "="); ok && !strings.ContainsAny(name, ":,") {
+			subscription, spec = name, rest
+		}
+		sink, err := newSink(spec)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("sink %q: %w", spec, err)
+		}
+		r.routes = append(r.routes, sinkRoute{subscription: subscription, sink: sink})
+	}
+	return r, nil
+}
+
+func newSink(spec string) (Sink, error) {
+	parts := strings.Split(spec, ",")
+	kind, target, _ := strings.Cut(parts[0], ":")
+	options := make(map[string]string)
+	for _, opt := range parts[1:] {
+		k, v, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid option %q, expected name=value", opt)
+		}
+		options[k] = v
+	}
+	option := func(name string) (string, bool) {
+		v, ok := options[name]
+		delete(options, name)
+		return v, ok
+	}
+
+	var sink Sink
+	switch kind {
+	case "stdout":
+		if target != "" && target != "pretty" {
+			return nil, fmt.Errorf("unknown stdout format %q, expected pretty", target)
+		}
+		sink = &stdoutSink{pretty: target == "pretty"}
+	case "file":
+		if target == "" {
+			return nil, fmt.Errorf("missing file path")
+		}
+		var maxSize int64
+		var maxAge time.Duration
+		if v, ok := option("max-size"); ok {
+			n, err := parseSize(v)
+			if err != nil {
+				return nil, err
+			}
+			maxSize = n
+		}
+		if v, ok := option("max-age"); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max-age: %w", err)
+			}
+			maxAge = d
+		}
+		f, err := newFileSink(target, maxSize, maxAge)
+		if err != nil {
+			return nil, err
+		}
+		sink = f
+	case "webhook":
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return nil, fmt.Errorf("webhook target must be an http(s) URL")
+		}
+		retries := 3
+		if v, ok := option("retries"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid retries %q", v)
+			}
+			retries = n
+		}
+		sink = newWebhookSink(target, retries)
+	default:
+		return nil, fmt.Errorf("unknown sink %q, expected stdout, file or webhook", kind)
+	}
+	for name := range options {
+		sink.Close()
+		return nil, fmt.Errorf("unknown option %q for %s sink", name, kind)
+	}
+	return sink, nil
+}
+
+// parseSize parses a byte count with an optional KB, MB or GB suffix.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(s)
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(upper, u.suffix); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return n * u.size, nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n, nil
+}
+
+// Write delivers ev to its sinks, logging sinks that fail. A nil router
+// drops it.
+func (r *SinkRouter) Write(ev SinkEvent) {
+	if r == nil {
+		return
+	}
+	for _, route := range r.routes {
+		if route.subscription != "" && route.subscription != ev.Subscription {
+			continue
+		}
+		if err := route.sink.Write(ev); err != nil {
+			slog.Warn("Error writing event to sink", "subscription", ev.Subscription, "err", err)
+		}
+	}
+}
+
+// Close flushes and closes every sink.
+func (r *SinkRouter) Close() {
+	if r == nil {
+		return
+	}
+	for _, route := range r.routes {
+		if err := route.sink.Close(); err != nil {
+			slog.Warn("Error closing sink", "err", err)
+		}
+	}
+}
+
+// stringsFlag is a flag that can be given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
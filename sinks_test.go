@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSinkAfterClose(t *testing.T) {
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer srv.Close()
+
+	s := newWebhookSink(srv.URL, 0)
+	ev := SinkEvent{Time: time.Now(), Subscription: "unhandled", Payload: json.RawMessage(`{"type":"ping"}`)}
+	if err := s.Write(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A late event, e.g. from a subscription still draining, is dropped.
+	if err := s.Write(ev); err != nil {
+		t.Fatalf("Write after Close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if n := posts.Load(); n != 1 {
+		t.Fatalf("got %d posts, want 1", n)
+	}
+}
+
+func TestSinkRouterRoutesBySubscription(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unhandled.jsonl")
+	sinks, err := parseSinks([]string{"unhandled=file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks.Write(SinkEvent{Subscription: "unhandled", Payload: json.RawMessage(`{"type":"ping"}`)})
+	sinks.Write(SinkEvent{Subscription: "sessionUpdates", Payload: json.RawMessage(`{"data":{}}`)})
+	sinks.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"subscription":"unhandled"`) {
+		t.Fatalf("got %q, want only the unhandled event", data)
+	}
+}
+
+func TestNilSinkRouter(t *testing.T) {
+	var sinks *SinkRouter
+	sinks.Write(SinkEvent{Subscription: "unhandled"})
+	sinks.Close()
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SoakReport is what a soak run adds to its run report.
+type SoakReport struct {
+	Target             int     `json:"target"`
+	PeakOpen           int     `json:"peakOpen"`
+	DialFailures       uint64  `json:"dialFailures"`
+	Lost               uint64  `json:"lost"`
+	SubscriptionErrors uint64  `json:"subscriptionErrors"`
+	Events             uint64  `json:"events"`
+	PeakGoroutines     int     `json:"peakGoroutines"`
+	PeakHeapMB         float64 `json:"peakHeapMB"`
+}
+
+// soak holds many mostly idle connections open, each with its own
+// subscriptions. Connections are opened at a fixed rate by a few dialers,
+// kept alive by one shared ping loop rather than a routine per connection,
+// and lost ones are queued to be reopened at the same rate, so a mass
+// disconnect doesn't turn into a reconnect storm.
+type soak struct {
+	url           string
+	protocol      string
+	query         string
+	variables     string
+	subscriptions int
+	keepalive     time.Duration
+	reconnect     bool
+
+	// want holds a token for every connection that should be opened.
+	want chan struct{}
+
+	mu    sync.Mutex
+	conns []*Client
+
+	opening      atomic.Int64
+	dialFailures atomic.Uint64
+	lost         atomic.Uint64
+	subErrors    atomic.Uint64
+	events       atomic.Uint64
+
+	peakOpen       int
+	peakGoroutines int
+	peakHeap       uint64
+}
+
+func runSoak(args []string) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	url := fs.String("url", defaultURL, "GraphQL websocket endpoint")
+	protocol := fs.String("protocol", "auto", "websocket subprotocol: graphql-transport-ws, graphql-ws, or auto")
+	connections := fs.Int("connections", 1000, "connections to hold open")
+	rate := fs.Float64("rate", 50, "connections opened per second, including reconnects")
+	dialers := fs.Int("dialers", 20, "connections being opened at once at most")
+	subscriptions := fs.Int("subscriptions", 1, "subscriptions started on each connection")
+	query := fs.String("query", sessionUpdatesSubscription, "subscription document")
+	variables := fs.String("vars", "", "variables as a JSON object")
+	keepalive := fs.Duration("keepalive", 10*time.Second, "how often each connection is pinged")
+	reconnect := fs.Bool("reconnect", true, "reopen lost connections")
+	duration := fs.Duration("duration", 0, "how long to soak, 0 to run until interrupted")
+	interval := fs.Duration("report-interval", 10*time.Second, "how often to log progress and client resource usage")
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result used to validate the subscription")
+	reportPath := fs.String("report", "", "write a run report to this file when the soak ends: CSV if it ends in .csv, an HTML page with charts if it ends in .html, JSON otherwise")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address at /metrics, e.g. :9090")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+
+	logOpts.setup()
+	serveMetrics(*metricsAddr)
+	if *connections < 1 || *rate <= 0 || *dialers < 1 || *subscriptions < 0 || *keepalive <= 0 || *interval <= 0 {
+		log.Fatalf("Invalid soak: -connections and -dialers must be at least 1, -rate, -keepalive and -report-interval positive and -subscriptions not negative")
+	}
+	if _, err := offeredSubprotocols(*protocol); err != nil {
+		log.Fatalf("Invalid -protocol: %v", err)
+	}
+	if schema, err := loadSchema(*schemaPath); err == nil && *subscriptions > 0 {
+		if err := validateOperation(schema, *query, *variables); err != nil {
+			log.Fatalf("Subscription is invalid against %s:\n%v", *schemaPath, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	runStats = NewStats()
+	s := &soak{
+		url:           *url,
+		protocol:      *protocol,
+		query:         *query,
+		variables:     *variables,
+		subscriptions: *subscriptions,
+		keepalive:     *keepalive,
+		reconnect:     *reconnect,
+		want:          make(chan struct{}, *connections),
+	}
+	for i := 0; i < *connections; i++ {
+		s.want <- struct{}{}
+	}
+	slog.Info("Starting soak", "connections", *connections, "rate", *rate, "subscriptions", *subscriptions)
+
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2 + *dialers)
+	go func() {
+		defer wg.Done()
+		s.schedule(ctx, jobs, max(time.Duration(float64(time.Second) / *rate), time.Microsecond))
+	}()
+	go func() {
+		defer wg.Done()
+		s.keepAlive(ctx)
+	}()
+	for i := 0; i < *dialers; i++ {
+		go func() {
+			defer wg.Done()
+			s.dialer(ctx, jobs)
+		}()
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+		s.progress(*connections)
+	}
+	wg.Wait()
+	s.closeAll()
+
+	report := newRunReport(runStats.Snapshot())
+	report.Soak = &SoakReport{
+		Target:             *connections,
+		PeakOpen:           s.peakOpen,
+		DialFailures:       s.dialFailures.Load(),
+		Lost:               s.lost.Load(),
+		SubscriptionErrors: s.subErrors.Load(),
+		Events:             s.events.Load(),
+		PeakGoroutines:     s.peakGoroutines,
+		PeakHeapMB:         float64(s.peakHeap) / (1 << 20),
+	}
+	fmt.Printf("Soak of %d connections: peak %d open, %d dial failures, %d lost, %d subscription errors, %d events\n",
+		report.Soak.Target, report.Soak.PeakOpen, report.Soak.DialFailures, report.Soak.Lost, report.Soak.SubscriptionErrors, report.Soak.Events)
+	fmt.Printf("Client peak: %d goroutines, %.1f MB heap\n", report.Soak.PeakGoroutines, report.Soak.PeakHeapMB)
+	for _, line := range statsTable(runStats.Snapshot()) {
+		fmt.Println(line)
+	}
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, report); err != nil {
+			log.Fatalf("Error writing report: %v", err)
+		}
+		fmt.Printf("Wrote run report to %s\n", *reportPath)
+	}
+}
+
+// schedule hands a token to the dialers every interval, for as long as
+// connections are wanted.
+func (s *soak) schedule(ctx context.Context, jobs chan<- struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.want:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case jobs <- struct{}{}:
+		}
+	}
+}
+
+// dialer opens a connection per token, putting the token back to be retried
+// when that fails.
+func (s *soak) dialer(ctx context.Context, jobs <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-jobs:
+		}
+		s.opening.Add(1)
+		c, err := s.open(ctx)
+		s.opening.Add(-1)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Error opening soak connection", "err", err)
+				s.want <- struct{}{}
+			}
+			continue
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.peakOpen = max(s.peakOpen, len(s.conns))
+		s.mu.Unlock()
+	}
+}
+
+// open connects and starts the subscriptions, timing both as the "connect"
+// operation.
+func (s *soak) open(ctx context.Context) (*Client, error) {
+	runStats.OperationStarted("connect")
+	start := time.Now()
+	conn, proto, err := dial(s.url, s.protocol)
+	if err != nil {
+		runStats.OperationFinished("connect", time.Since(start), nil, err)
+		s.dialFailures.Add(1)
+		return nil, err
+	}
+	client := NewClient(conn, proto, nil)
+	for i := 0; i < s.subscriptions; i++ {
+		sub, err := client.Subscribe(ctx, "soak", s.query, s.variables)
+		if err != nil {
+			runStats.OperationFinished("connect", time.Since(start), nil, err)
+			s.subErrors.Add(1)
+			client.Close()
+			return nil, err
+		}
+		go s.drain(client, sub)
+	}
+	runStats.OperationFinished("connect", time.Since(start), nil, nil)
+	return client, nil
+}
+
+// drain counts the events of a subscription. Subscriptions that end while
+// their connection stays open are counted as errors; lost connections are
+// counted by keepAlive.
+func (s *soak) drain(client *Client, sub *Subscription) {
+	for range sub.Events {
+		s.events.Add(1)
+	}
+	select {
+	case <-client.Done():
+		return
+	default:
+	}
+	if err := sub.Err(); !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		s.subErrors.Add(1)
+		client.log.Warn("Soak subscription ended", "id", sub.ID(), "err", err)
+	}
+}
+
+// keepAlive pings every connection once per keepalive interval, spreading the
+// pings evenly, and removes lost connections, queueing them to be reopened.
+func (s *soak) keepAlive(ctx context.Context) {
+	const tick = 100 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	next := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		live := s.conns[:0]
+		for _, c := range s.conns {
+			select {
+			case <-c.Done():
+				s.lost.Add(1)
+				c.log.Warn("Soak connection lost", "reason", closeReason(c.Err()))
+				if s.reconnect {
+					s.want <- struct{}{}
+				}
+			default:
+				live = append(live, c)
+			}
+		}
+		clear(s.conns[len(live):])
+		s.conns = live
+		batch := (len(live)*int(tick) + int(s.keepalive) - 1) / int(s.keepalive)
+		var ping []*Client
+		for i := 0; i < batch && i < len(live); i++ {
+			next = (next + 1) % len(live)
+			ping = append(ping, live[next])
+		}
+		s.mu.Unlock()
+
+		for _, client := range ping {
+			if !client.proto.SupportsPing() {
+				continue
+			}
+			if err := client.write(GraphQLMessage{Type: "ping"}); err != nil {
+				client.log.Warn("Failed to send ping", "err", err)
+			}
+		}
+	}
+}
+
+// progress logs the state of the soak and samples the client's goroutines
+// and heap.
+func (s *soak) progress(target int) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	goroutines := runtime.NumGoroutine()
+
+	s.mu.Lock()
+	open := len(s.conns)
+	s.peakGoroutines = max(s.peakGoroutines, goroutines)
+	s.peakHeap = max(s.peakHeap, mem.HeapInuse)
+	s.mu.Unlock()
+
+	slog.Info("Soak progress",
+		"open", open,
+		"target", target,
+		"opening", s.opening.Load(),
+		"dialFailures", s.dialFailures.Load(),
+		"lost", s.lost.Load(),
+		"subscriptionErrors", s.subErrors.Load(),
+		"events", s.events.Load(),
+		"goroutines", goroutines,
+		"heapMB", fmt.Sprintf("%.1f", float64(mem.HeapInuse)/(1<<20)),
+	)
+}
+
+// closeAll closes every connection.
+func (s *soak) closeAll() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const sseTokenHeader = "X-GraphQL-Event-Stream-Token"
+
+// SSEClient runs subscriptions over graphql-sse. In distinct connections mode
+// every subscription is its own POST whose response is the event stream. In
+// single connection mode one reserved stream carries the events of all
+// operations, which are started with POSTs and stopped with DELETEs; its
+// events are queued per operation so a slow subscription doesn't hold up the
+// others.
+type SSEClient struct {
+	URL              string
+	SingleConnection bool
+	client           *http.Client
+
+	mu     sync.Mutex
+	token  string
+	stream io.Closer
+	ops    map[string]*queue[sseEvent]
+	err    error
+	// done is closed when the shared stream ends.
+	done chan struct{}
+}
+
+type sseEvent struct {
+	event string
+	data  string
+}
+
+func NewSSEClient(endpoint string, singleConnection bool) *SSEClient {
+	return &SSEClient{
+		URL:              endpoint,
+		SingleConnection: singleConnection,
+		client:           &http.Client{},
+		ops:              make(map[string]*queue[sseEvent]),
+		done:             make(chan struct{}),
+	}
+}
+
+func (s *SSEClient) Subscribe(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	if err := checkOperation(OperationSubscription, prefix, query, variables); err != nil {
+		return nil, err
+	}
+	if s.SingleConnection {
+		return s.subscribeSingle(ctx, prefix, query, variables)
+	}
+	return s.subscribeDistinct(ctx, prefix, query, variables)
+}
+
+func (s *SSEClient) newRequest(ctx context.Context, method, target string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range authHeaders() {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set(sseTokenHeader, s.token)
+	}
+	return req, nil
+}
+
+func (s *SSEClient) subscribeDistinct(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	ctx, sub, events := newSubscription(ctx, uuid.New().String())
+	req, err := s.newRequest(ctx, http.MethodPost, s.URL, marshalPayload(query, variables, nil))
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		sub.Close()
+		return nil, fmt.Errorf("error sending subscription: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		sub.Close()
+		return nil, fmt.Errorf("%s subscription: HTTP %s: %s", prefix, resp.Status, strings.TrimSpace(string(body)))
+	}
+	logSent(slog.With("transport", "sse"), OperationSubscription, prefix, query, variables, "")
+
+	metrics.SubscriptionStarted("sse")
+	go func() {
+		defer metrics.SubscriptionEnded("sse")
+		defer close(events)
+		defer resp.Body.Close()
+		var completed bool
+		err := readSSE(resp.Body, func(ev sseEvent) bool {
+			switch ev.event {
+			case "next":
+				select {
+				case events <- json.RawMessage(ev.data):
+				case <-ctx.Done():
+					return false
+				}
+			case "complete":
+				completed = true
+				return false
+			}
+			return true
+		})
+		switch {
+		case ctx.Err() != nil:
+			sub.setErr(ctx.Err())
+		case err != nil:
+			sub.setErr(err)
+		case !completed:
+			// The stream ended without the server completing the
+			// subscription.
+			sub.setErr(io.ErrUnexpectedEOF)
+		}
+	}()
+	return sub, nil
+}
+
+// reserve obtains a stream token and opens the shared event stream, once.
+func (s *SSEClient) reserve() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream != nil {
+		return nil
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	req, err := s.newRequest(context.Background(), http.MethodPut, s.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error reserving SSE stream: %w", err)
+	}
+	token, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reserving SSE stream: HTTP %s: %s", resp.Status, strings.TrimSpace(string(token)))
+	}
+	s.token = strings.TrimSpace(string(token))
+
+	req, err = s.newRequest(context.Background(), http.MethodGet, s.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error opening SSE stream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return fmt.Errorf("opening SSE stream: HTTP %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	s.stream = resp.Body
+	slog.Info("Opened single SSE stream", "token", s.token)
+
+	go func() {
+		err := readSSE(resp.Body, func(ev sseEvent) bool {
+			var msg struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal([]byte(ev.data), &msg); err != nil {
+				slog.Warn("Error unmarshalling SSE event", "err", err)
+				return true
+			}
+			s.mu.Lock()
+			q, ok := s.ops[msg.ID]
+			s.mu.Unlock()
+			if ok {
+				q.put(ev)
+			}
+			return true
+		})
+		if err == nil {
+			err = io.EOF
+		}
+		s.mu.Lock()
+		s.err = fmt.Errorf("SSE stream closed: %w", err)
+		for id, q := range s.ops {
+			q.close()
+			delete(s.ops, id)
+		}
+		s.stream = nil
+		close(s.done)
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+func (s *SSEClient) subscribeSingle(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	if err := s.reserve(); err != nil {
+		return nil, err
+	}
+	id := uuid.New().String()
+	q := newQueue[sseEvent]()
+	s.mu.Lock()
+	s.ops[id] = q
+	s.mu.Unlock()
+
+	body := marshalPayload(query, variables, map[string]interface{}{"operationId": id})
+	req, err := s.newRequest(ctx, http.MethodPost, s.URL, body)
+	if err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.remove(id)
+		return nil, fmt.Errorf("error sending subscription: %w", err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		s.remove(id)
+		return nil, fmt.Errorf("%s subscription: HTTP %s: %s", prefix, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	logSent(slog.With("transport", "sse", "id", id), OperationSubscription, prefix, query, variables, "")
+
+	ctx, sub, events := newSubscription(ctx, id)
+	metrics.SubscriptionStarted("sse")
+	go func() {
+		defer metrics.SubscriptionEnded("sse")
+		defer close(events)
+		for {
+			select {
+			case ev := <-q.out:
+				switch ev.event {
+				case "next":
+					var msg struct {
+						Payload json.RawMessage `json:"payload"`
+					}
+					json.Unmarshal([]byte(ev.data), &msg)
+					select {
+					case events <- msg.Payload:
+					case <-ctx.Done():
+					}
+				case "complete":
+					s.remove(id)
+					return
+				}
+			case <-s.done:
+				sub.setErr(s.streamErr())
+				return
+			case <-ctx.Done():
+				s.stop(id)
+				sub.setErr(ctx.Err())
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (s *SSEClient) remove(id string) {
+	s.mu.Lock()
+	q, ok := s.ops[id]
+	delete(s.ops, id)
+	s.mu.Unlock()
+	if ok {
+		q.close()
+	}
+}
+
+func (s *SSEClient) streamErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// stop tells the server to end an operation on the shared stream.
+func (s *SSEClient) stop(id string) {
+	s.remove(id)
+	target, err := withQuery(s.URL, url.Values{"operationId": {id}})
+	if err != nil {
+		return
+	}
+	req, err := s.newRequest(context.Background(), http.MethodDelete, target, nil)
+	if err != nil {
+		return
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		slog.Warn("Error stopping SSE operation", "id", id, "err", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// Close ends the shared stream, if one was opened.
+func (s *SSEClient) Close() error {
+	s.mu.Lock()
+	stream := s.stream
+	s.mu.Unlock()
+	if stream != nil {
+		return stream.Close()
+	}
+	return nil
+}
+
+// readSSE parses a text/event-stream, calling handle for each event until it
+// returns false or the stream ends.
+func readSSE(r io.Reader, handle func(sseEvent) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var ev sseEvent
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if ev.event != "" || len(data) > 0 {
+				ev.data = strings.Join(data, "\n")
+				if !handle(ev) {
+					return nil
+				}
+			}
+			ev, data = sseEvent{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return scanner.Err()
+}
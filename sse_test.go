@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sseServer is a graphql-sse server in single connection mode whose events
+// are pushed by the test.
+type sseServer struct {
+	events  chan string
+	started chan string
+	deleted chan string
+}
+
+func (s *sseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "token")
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case ev := <-s.events:
+				fmt.Fprint(w, ev)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case http.MethodPost:
+		var body struct {
+			Extensions struct {
+				OperationID string `json:"operationId"`
+			} `json:"extensions"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusAccepted)
+		s.started <- body.Extensions.OperationID
+	case http.MethodDelete:
+		s.deleted <- r.URL.RawQuery
+	}
+}
+
+func (s *sseServer) next(id string, n int) {
+	s.events <- fmt.Sprintf("event: next\ndata: {\"id\": %q, \"payload\": {\"data\": {\"n\": %d}}}\n\n", id, n)
+}
+
+func TestSSESingleConnection(t *testing.T) {
+	server := &sseServer{events: make(chan string), started: make(chan string, 2), deleted: make(chan string, 2)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := NewSSEClient(ts.URL+"?tenant=a", true)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.Subscribe(ctx, "stalled", sessionUpdatesSubscription, ""); err != nil {
+		t.Fatal(err)
+	}
+	stalled := <-server.started
+	sub, err := client.Subscribe(ctx, "read", sessionUpdatesSubscription, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := <-server.started
+
+	// Events for a subscription nobody reads must not hold up the others.
+	for i := 0; i < 100; i++ {
+		server.next(stalled, i)
+	}
+	server.next(read, 1)
+	select {
+	case payload := <-sub.Events:
+		if string(payload) != `{"data": {"n": 1}}` {
+			t.Errorf("got %s", payload)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for an event")
+	}
+
+	sub.Close()
+	if got, want := <-server.deleted, "operationId="+read+"&tenant=a"; got != want {
+		t.Errorf("got DELETE query %q, want %q", got, want)
+	}
+}
+
+func TestSSEDistinctStreamEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  string
+		wantErr error
+	}{
+		{name: "completed", events: "event: next\ndata: {\"data\": {\"n\": 1}}\n\nevent: complete\n\n"},
+		{name: "closed mid-stream", events: "event: next\ndata: {\"data\": {\"n\": 1}}\n\n", wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, tt.events)
+			}))
+			defer ts.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			sub, err := NewSSEClient(ts.URL, false).Subscribe(ctx, "sessionUpdates", sessionUpdatesSubscription, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			for range sub.Events {
+				n++
+			}
+			if n != 1 {
+				t.Errorf("got %d events, want 1", n)
+			}
+			if err := sub.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got, want := subscriptionLost(sub.Err()), tt.wantErr != nil; got != want {
+				t.Errorf("subscriptionLost = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Latencies are counted in exponential buckets 2% wide, from 1µs up to
+// about seven minutes, so percentiles are accurate to within 2% without
+// keeping every sample.
+const (
+	histogramGrowth  = 1.02
+	histogramBuckets = 1000
+)
+
+var logHistogramGrowth = math.Log(histogramGrowth)
+
+// Histogram counts durations in exponential buckets.
+type Histogram struct {
+	Counts [histogramBuckets]uint64
+	Total  uint64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+func histogramBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Log(us)/logHistogramGrowth) + 1
+	if i >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return i
+}
+
+// histogramUpper is the upper bound of bucket i.
+func histogramUpper(i int) time.Duration {
+	return time.Duration(math.Pow(histogramGrowth, float64(i)) * float64(time.Microsecond))
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if h.Total == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Counts[histogramBucket(d)]++
+	h.Total++
+	h.Sum += d
+}
+
+// Percentile returns the latency below which p percent of samples fall.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(h.Total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= rank {
+			if upper := histogramUpper(i); upper < h.Max {
+				return upper
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Total)
+}
+
+// operationStats are the results of one named operation.
+type operationStats struct {
+	latency  Histogram
+	errors   uint64
+	inFlight int
+	// Completions in the current Unix second and the one before it, for
+	// the live rate.
+	second     int64
+	thisSecond uint64
+	lastSecond uint64
+}
+
+// Stats collects what happens during a run: connections, operations and
+// their latencies, errors and a log of recent events. A nil *Stats records
+// nothing.
+type Stats struct {
+	mu         sync.Mutex
+	started    time.Time
+	connsOpen  int
+	opened     uint64
+	closeCodes map[string]uint64
+	operations map[string]*operationStats
+	errors     map[string]uint64
+	events     []string
+	dropped    uint64
+	// timeline holds what happened in each second of the run, nil for
+	// seconds where nothing did.
+	timeline []*timelineSecond
+}
+
+// runStats is the collector of the current load run, if any.
+var runStats *Stats
+
+const maxEvents = 500
+
+func NewStats() *Stats {
+	return &Stats{
+		started:    time.Now(),
+		closeCodes: make(map[string]uint64),
+		operations: make(map[string]*operationStats),
+		errors:     make(map[string]uint64),
+	}
+}
+
+func (s *Stats) ConnectionOpened() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connsOpen++
+	s.opened++
+	if t := s.second(); t != nil {
+		t.opened++
+	}
+}
+
+// IterationDropped records an iteration an arrival-rate run had no virtual
+// user for.
+func (s *Stats) IterationDropped() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.dropped++
+	if t := s.second(); t != nil {
+		t.dropped++
+	}
+	s.mu.Unlock()
+	s.Event("Iteration dropped: all virtual users busy")
+}
+
+// ConnectionClosed records why a connection ended: its close code, or the
+// kind of error for connections lost without a close frame.
+func (s *Stats) ConnectionClosed(err error) {
+	if s == nil {
+		return
+	}
+	reason := closeReason(err)
+	s.mu.Lock()
+	s.connsOpen--
+	s.closeCodes[reason]++
+	if t := s.second(); t != nil {
+		t.closeCodes[reason]++
+	}
+	s.mu.Unlock()
+	s.Event(fmt.Sprintf("Connection closed: %s", reason))
+}
+
+func closeReason(err error) string {
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		return fmt.Sprint(closeErr.Code)
+	case errors.Is(err, ErrClientClosed):
+		return "client"
+	case err == nil:
+		return "unknown"
+	}
+	return "network"
+}
+
+func (s *Stats) op(name string) *operationStats {
+	op, ok := s.operations[name]
+	if !ok {
+		op = &operationStats{}
+		s.operations[name] = op
+	}
+	return op
+}
+
+func (s *Stats) OperationStarted(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.op(name).inFlight++
+}
+
+// OperationFinished records an operation's latency and, if it failed or its
+// result has errors, the kind of error.
+func (s *Stats) OperationFinished(name string, latency time.Duration, payload json.RawMessage, err error) {
+	if s == nil {
+		return
+	}
+	kind := errorKind(payload, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := s.op(name)
+	op.inFlight--
+	op.latency.Record(latency)
+	if sec := time.Now().Unix(); sec != op.second {
+		if sec == op.second+1 {
+			op.lastSecond = op.thisSecond
+		} else {
+			op.lastSecond = 0
+		}
+		op.second, op.thisSecond = sec, 0
+	}
+	op.thisSecond++
+	if kind != "" {
+		op.errors++
+		s.errors[kind]++
+	}
+	if t := s.second(); t != nil {
+		t.operation(name, latency, kind != "")
+		if kind != "" {
+			t.errors[kind]++
+		}
+	}
+}
+
+// errorKind classifies a failed operation, or returns "" if it succeeded.
+// GraphQL errors are grouped by their extensions.code.
+func errorKind(payload json.RawMessage, err error) string {
+	var gqlErrs GraphQLErrors
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.As(err, &gqlErrs):
+	case errors.As(err, &closeErr):
+		return fmt.Sprintf("close %d", closeErr.Code)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case err != nil:
+		return "transport"
+	}
+	gqlErrs = resultErrors(payload, err)
+	if len(gqlErrs) == 0 {
+		return ""
+	}
+	if code, ok := gqlErrs[0].Extensions["code"].(string); ok {
+		return "graphql " + code
+	}
+	return "graphql"
+}
+
+// resultErrors returns the GraphQL errors of an operation, from its error
+// or from the errors of its result.
+func resultErrors(payload json.RawMessage, err error) GraphQLErrors {
+	var gqlErrs GraphQLErrors
+	if errors.As(err, &gqlErrs) || err != nil {
+		return gqlErrs
+	}
+	var result struct {
+		Errors GraphQLErrors `json:"errors"`
+	}
+	json.Unmarshal(payload, &result)
+	return result.Errors
+}
+
+// Event adds a line to the event log.
+func (s *Stats) Event(line string) {
+	if s == nil {
+		return
+	}
+	line = time.Now().Format("15:04:05.000") + " " + strings.TrimRight(line, "\n")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, line)
+	if len(s.events) > maxEvents {
+		s.events = append(s.events[:0], s.events[len(s.events)-maxEvents:]...)
+	}
+}
+
+// OperationSnapshot is the state of one operation at a point in the run.
+type OperationSnapshot struct {
+	Name     string
+	Count    uint64
+	Errors   uint64
+	InFlight int
+	Rate     float64
+	Latency  Histogram
+}
+
+// Snapshot is a consistent copy of the collected stats.
+type Snapshot struct {
+	Started     time.Time
+	Elapsed     time.Duration
+	ConnsOpen   int
+	ConnsOpened uint64
+	CloseCodes  map[string]uint64
+	Operations  []OperationSnapshot
+	Errors      map[string]uint64
+	Events      []string
+	Sequences   []SequenceResult
+	Dropped     uint64
+	Timeline    []TimelinePoint
+}
+
+// Snapshot copies the stats collected so far, timeline included; nil Stats
+// have none.
+func (s *Stats) Snapshot() Snapshot {
+	return s.snapshot(true)
+}
+
+// snapshot is Snapshot, leaving out the timeline, which takes time in
+// proportion to the length of the run, unless withTimeline is set.
+func (s *Stats) snapshot(withTimeline bool) Snapshot {
+	if s == nil {
+		return Snapshot{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{
+		Started:     s.started,
+		Elapsed:     time.Since(s.started),
+		ConnsOpen:   s.connsOpen,
+		ConnsOpened: s.opened,
+		CloseCodes:  make(map[string]uint64, len(s.closeCodes)),
+		Errors:      make(map[string]uint64, len(s.errors)),
+		Events:      append([]string(nil), s.events...),
+		Sequences:   sequences.Results(),
+		Dropped:     s.dropped,
+	}
+	for k, v := range s.closeCodes {
+		snap.CloseCodes[k] = v
+	}
+	for k, v := range s.errors {
+		snap.Errors[k] = v
+	}
+	now := time.Now().Unix()
+	for name, op := range s.operations {
+		rate := float64(0)
+		switch now {
+		case op.second:
+			rate = float64(op.lastSecond)
+		case op.second + 1:
+			rate = float64(op.thisSecond)
+		}
+		snap.Operations = append(snap.Operations, OperationSnapshot{
+			Name:     name,
+			Count:    op.latency.Total,
+			Errors:   op.errors,
+			InFlight: op.inFlight,
+			Rate:     rate,
+			Latency:  op.latency,
+		})
+	}
+	sort.Slice(snap.Operations, func(i, j int) bool { return snap.Operations[i].Name < snap.Operations[j].Name })
+	if withTimeline {
+		snap.Timeline = s.timelineLocked()
+	}
+	return snap
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Microsecond, 0},
+		{time.Microsecond + 1, 1},
+		{1019 * time.Nanosecond, 1},
+		{1020 * time.Nanosecond, 2},
+		{time.Millisecond, 349},
+		{time.Second, 698},
+		{time.Hour, histogramBuckets - 1},
+	}
+	for _, tt := range tests {
+		got := histogramBucket(tt.d)
+		if got != tt.want {
+			t.Errorf("histogramBucket(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+		// Every duration below the last bucket is within its bucket's bounds.
+		if got > 0 && got < histogramBuckets-1 && (tt.d < histogramUpper(got-1) || tt.d >= histogramUpper(got)) {
+			t.Errorf("%v is outside bucket %d [%v, %v)", tt.d, got, histogramUpper(got-1), histogramUpper(got))
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	uniform := make([]time.Duration, 100)
+	for i := range uniform {
+		uniform[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		p       float64
+		want    time.Duration
+	}{
+		{name: "empty", p: 50, want: 0},
+		{name: "single", samples: []time.Duration{5 * time.Millisecond}, p: 99, want: 5 * time.Millisecond},
+		{name: "p0 is the smallest", samples: uniform, p: 0, want: time.Millisecond},
+		{name: "p50", samples: uniform, p: 50, want: 50 * time.Millisecond},
+		{name: "p90", samples: uniform, p: 90, want: 90 * time.Millisecond},
+		{name: "p99", samples: uniform, p: 99, want: 99 * time.Millisecond},
+		{name: "p100 is the max", samples: uniform, p: 100, want: 100 * time.Millisecond},
+		{name: "outlier", samples: append([]time.Duration{time.Minute}, uniform[:99]...), p: 99, want: 99 * time.Millisecond},
+		{name: "clamped to max", samples: []time.Duration{time.Second, time.Second}, p: 50, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Histogram
+			for _, d := range tt.samples {
+				h.Record(d)
+			}
+			got := h.Percentile(tt.p)
+			// Buckets are 2% wide, and the percentile is the upper bound of
+			// its bucket.
+			if got < tt.want || float64(got) > float64(tt.want)*histogramGrowth {
+				t.Errorf("Percentile(%v) = %v, want %v within 2%%", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilStats(t *testing.T) {
+	var s *Stats
+	s.ConnectionOpened()
+	s.OperationStarted("op")
+	s.OperationFinished("op", time.Millisecond, nil, nil)
+	if snap := s.Snapshot(); len(snap.Operations) != 0 || snap.ConnsOpened != 0 {
+		t.Errorf("got %+v from nil Stats", snap)
+	}
+	if s.Timeline() != nil {
+		t.Error("got a timeline from nil Stats")
+	}
+}
+
+// The report's timeline comes from the snapshot it is built from, not from
+// the global collector.
+func TestRunReportTimelineFromSnapshot(t *testing.T) {
+	s := NewStats()
+	s.OperationStarted("op")
+	s.OperationFinished("op", time.Millisecond, nil, nil)
+	snap := s.Snapshot()
+	if len(snap.Timeline) == 0 || snap.Timeline[0].Operations["op"].Count != 1 {
+		t.Fatalf("got timeline %+v, want op counted in the first second", snap.Timeline)
+	}
+	if s.snapshot(false).Timeline != nil {
+		t.Error("got a timeline from a snapshot without one")
+	}
+
+	saved := runStats
+	runStats = nil
+	defer func() { runStats = saved }()
+	if report := newRunReport(snap); len(report.Timeline) != len(snap.Timeline) {
+		t.Errorf("got %d timeline points in the report, want %d", len(report.Timeline), len(snap.Timeline))
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// runSubscribe starts one subscription over the websocket or graphql-sse and
+// prints its events until it completes or is interrupted.
+func runSubscribe(args []string) {
+	fs := flag.NewFlagSet("subscribe", flag.ExitOnError)
+	url := fs.String("url", defaultURL, "GraphQL websocket endpoint")
+	protocol := fs.String("protocol", "auto", "websocket subprotocol: graphql-transport-ws, graphql-ws, or auto")
+	transport := fs.String("transport", "ws", "subscription transport: ws or sse")
+	sseURL := fs.String("sse-url", "", "graphql-sse endpoint (default: -url with http(s) scheme)")
+	sseMode := fs.String("sse-mode", "distinct", "graphql-sse mode: distinct (one request per subscription) or single (one shared stream)")
+	name := fs.String("name", "subscription", "name used for the operation in output")
+	query := fs.String("query", "", "subscription document")
+	queryFile := fs.String("query-file", "", "read the subscription document from a file")
+	variables := fs.String("vars", "", "variables as a JSON object")
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result used to validate the subscription")
+	record := fs.String("record", "", "record websocket frames and handshake headers, with secrets redacted, to this JSONL file")
+	traceEndpoint := fs.String("trace-endpoint", "", "export OpenTelemetry spans as OTLP/JSON to this collector URL, e.g. http://localhost:4318/v1/traces")
+	traceFile := fs.String("trace-file", "", "append OpenTelemetry spans as OTLP/JSON lines to this file")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address at /metrics, e.g. :9090")
+	filter := fs.String("filter", "", `only deliver events matching this expression, e.g. 'data.sessionUpdates.type == "CREATED"'`)
+	project := fs.String("project", "", "deliver only these comma-separated [name=]path fields of each event, e.g. id=data.sessionUpdates.session.id")
+	flat := fs.Bool("flatten", false, "flatten events into one object with dotted keys")
+	sequenceField := fs.String("sequence-field", "", "check that this sequence or version path increases by one per event, e.g. data.sessionUpdates.sequence")
+	sequenceKey := fs.String("sequence-key", "", "check -sequence-field separately for each value of this path, e.g. data.sessionUpdates.session.id")
+	resubscribe := fs.Bool("resubscribe", true, "re-establish the subscription, on a new connection, when it is lost rather than completed or rejected")
+	reportPath := fs.String("report", "", "write a run report, including sequence results, to this file when the subscription ends")
+	var sinkSpecs stringsFlag
+	fs.Var(&sinkSpecs, "sink", "where events go, repeatable: stdout, stdout:pretty, file:PATH[,max-size=10MB][,max-age=1h] or webhook:URL[,retries=3]; prefix with NAME= to route one subscription (default stdout)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	logOpts.setup()
+	sinks, err := parseSinks(sinkSpecs)
+	if err != nil {
+		log.Fatalf("Invalid -sink: %v", err)
+	}
+	view, err := newEventView(*filter, *project, *flat)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer sinks.Close()
+	startSequenceCheck(*sequenceField, *sequenceKey)
+	runStats = NewStats()
+	serveMetrics(*metricsAddr)
+	startTracing(*traceEndpoint, *traceFile)
+	defer tracer.Close()
+
+	startRecording(*record)
+	defer traffic.Close()
+
+	if *queryFile != "" {
+		src, err := os.ReadFile(*queryFile)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", *queryFile, err)
+		}
+		*query = string(src)
+	}
+	if *query == "" {
+		log.Fatalf("One of -query or -query-file is required")
+	}
+	if schema, err := loadSchema(*schemaPath); err == nil {
+		cachedSchema = schema
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *transport != "ws" && *transport != "sse" {
+		log.Fatalf("Invalid -transport %q, expected ws or sse", *transport)
+	}
+	if *transport == "sse" {
+		if *sseURL == "" {
+			*sseURL = httpURL(*url)
+		}
+		if *sseMode != "distinct" && *sseMode != "single" {
+			log.Fatalf("Invalid -sse-mode %q, expected distinct or single", *sseMode)
+		}
+	}
+	// subscribe opens a new connection and starts the subscription on it.
+	// The subscription keeps its name, so sequence checking carries over.
+	subscribe := func() (*Subscription, func() error, error) {
+		var subscriber Subscriber
+		var closeSubscriber func() error
+		if *transport == "ws" {
+			conn, proto, err := dial(*url, *protocol)
+			if err != nil {
+				return nil, nil, err
+			}
+			client := NewClient(conn, proto, nil)
+			go pingRoutine(ctx, client)
+			subscriber, closeSubscriber = client, client.Close
+		} else {
+			sse := NewSSEClient(*sseURL, *sseMode == "single")
+			subscriber, closeSubscriber = sse, sse.Close
+		}
+		sub, err := subscriber.Subscribe(ctx, *name, *query, *variables)
+		if err != nil {
+			closeSubscriber()
+			return nil, nil, err
+		}
+		return sub, closeSubscriber, nil
+	}
+
+	sub, closeSub, err := subscribe()
+	if err != nil {
+		log.Fatalf("Error starting subscription: %v", err)
+	}
+	for {
+		for payload := range sub.Events {
+			sequences.Check(*name, payload)
+			if out, ok := view.Apply(payload); ok {
+				sinks.Write(SinkEvent{Time: time.Now(), Subscription: *name, ID: sub.ID(), Payload: out})
+			}
+		}
+		closeSub()
+		if !*resubscribe || !subscriptionLost(sub.Err()) || ctx.Err() != nil {
+			break
+		}
+		slog.Warn("Subscription lost, re-establishing it", "subscription", *name, "err", sub.Err())
+		next, closeNext, err := subscribe()
+		for backoff := time.Second; err != nil && ctx.Err() == nil; backoff = min(2*backoff, 30*time.Second) {
+			slog.Warn("Error re-establishing subscription", "subscription", *name, "err", err, "retryIn", backoff)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+				next, closeNext, err = subscribe()
+			}
+		}
+		if err != nil {
+			break
+		}
+		sub, closeSub = next, closeNext
+	}
+	for _, res := range sequences.Results() {
+		fmt.Printf("[%s] %d events: %d gaps (%d missing), %d duplicates, %d out of order, %d without a sequence\n",
+			res.Subscription, res.Events, res.Gaps, res.Missing, res.Duplicates, res.OutOfOrder, res.Unsequenced)
+	}
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, newRunReport(runStats.Snapshot())); err != nil {
+			log.Fatalf("Error writing report: %v", err)
+		}
+		fmt.Printf("Wrote run report to %s\n", *reportPath)
+	}
+	if err := sub.Err(); err != nil {
+		sinks.Close()
+		log.Fatalf("Subscription ended: %v", err)
+	}
+	fmt.Println("Subscription completed")
+}
+
+// subscriptionLost reports whether a subscription that ended with err was
+// cut off, as opposed to completed, cancelled or rejected by the server.
+func subscriptionLost(err error) bool {
+	var errs GraphQLErrors
+	return err != nil && !errors.As(err, &errs)
+}
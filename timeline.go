@@ -0,0 +1,156 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxTimelineSeconds bounds how much of a run the timeline covers.
+const maxTimelineSeconds = 7 * 24 * 60 * 60
+
+// timelineOperation is what one operation did in one second. Latencies are
+// kept as sparse counts of Histogram buckets.
+type timelineOperation struct {
+	count   uint64
+	errors  uint64
+	max     time.Duration
+	buckets map[int]uint64
+}
+
+func (op *timelineOperation) percentile(p float64) time.Duration {
+	indexes := make([]int, 0, len(op.buckets))
+	for i := range op.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	rank := uint64(math.Ceil(p / 100 * float64(op.count)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for _, i := range indexes {
+		seen += op.buckets[i]
+		if seen >= rank {
+			return min(histogramUpper(i), op.max)
+		}
+	}
+	return op.max
+}
+
+// timelineSecond is what happened in one second of a run.
+type timelineSecond struct {
+	connsOpen  int
+	opened     uint64
+	operations map[string]*timelineOperation
+	errors     map[string]uint64
+	closeCodes map[string]uint64
+	dropped    uint64
+}
+
+// second returns the timeline entry for now, or nil past the end of the
+// timeline; s.mu must be held.
+func (s *Stats) second() *timelineSecond {
+	i := int(time.Since(s.started) / time.Second)
+	if i >= maxTimelineSeconds {
+		return nil
+	}
+	for len(s.timeline) <= i {
+		s.timeline = append(s.timeline, nil)
+	}
+	if s.timeline[i] == nil {
+		s.timeline[i] = &timelineSecond{
+			operations: make(map[string]*timelineOperation),
+			errors:     make(map[string]uint64),
+			closeCodes: make(map[string]uint64),
+		}
+	}
+	t := s.timeline[i]
+	t.connsOpen = s.connsOpen
+	return t
+}
+
+func (t *timelineSecond) operation(name string, latency time.Duration, failed bool) {
+	op, ok := t.operations[name]
+	if !ok {
+		op = &timelineOperation{buckets: make(map[int]uint64)}
+		t.operations[name] = op
+	}
+	op.count++
+	if failed {
+		op.errors++
+	}
+	op.max = max(op.max, latency)
+	op.buckets[histogramBucket(latency)]++
+}
+
+// TimelinePoint is one second of a run in a report. ConnsOpen is the number
+// of open connections at the end of the second.
+type TimelinePoint struct {
+	Second     int                          `json:"second"`
+	ConnsOpen  int                          `json:"connsOpen"`
+	Opened     uint64                       `json:"opened,omitempty"`
+	Operations map[string]TimelineOperation `json:"operations,omitempty"`
+	Errors     map[string]uint64            `json:"errors,omitempty"`
+	CloseCodes map[string]uint64            `json:"closeCodes,omitempty"`
+	Dropped    uint64                       `json:"dropped,omitempty"`
+}
+
+// TimelineOperation is what one operation did in one second. Latencies are
+// in milliseconds.
+type TimelineOperation struct {
+	Count  uint64  `json:"count"`
+	Errors uint64  `json:"errors,omitempty"`
+	P50Ms  float64 `json:"p50Ms"`
+	P95Ms  float64 `json:"p95Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+// Timeline returns a point for every second of the run so far, carrying the
+// open connection count through quiet seconds.
+func (s *Stats) Timeline() []TimelinePoint {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timelineLocked()
+}
+
+// timelineLocked is Timeline; s.mu must be held.
+func (s *Stats) timelineLocked() []TimelinePoint {
+	n := max(len(s.timeline), min(int(time.Since(s.started)/time.Second)+1, maxTimelineSeconds))
+	points := make([]TimelinePoint, n)
+	connsOpen := 0
+	for i := range points {
+		p := TimelinePoint{Second: i, ConnsOpen: connsOpen}
+		if i < len(s.timeline) && s.timeline[i] != nil {
+			t := s.timeline[i]
+			connsOpen = t.connsOpen
+			p.ConnsOpen, p.Opened, p.Dropped = t.connsOpen, t.opened, t.dropped
+			if len(t.operations) > 0 {
+				p.Operations = make(map[string]TimelineOperation, len(t.operations))
+			}
+			for name, op := range t.operations {
+				p.Operations[name] = TimelineOperation{
+					Count:  op.count,
+					Errors: op.errors,
+					P50Ms:  milliseconds(op.percentile(50)),
+					P95Ms:  milliseconds(op.percentile(95)),
+					P99Ms:  milliseconds(op.percentile(99)),
+					MaxMs:  milliseconds(op.max),
+				}
+			}
+			if len(t.errors) > 0 {
+				p.Errors = maps.Clone(t.errors)
+			}
+			if len(t.closeCodes) > 0 {
+				p.CloseCodes = maps.Clone(t.closeCodes)
+			}
+		}
+		points[i] = p
+	}
+	return points
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLP span kinds and status codes.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	statusOK    = 1
+	statusError = 2
+)
+
+// Span is one timed unit of work in a trace. A nil *Span records nothing.
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu     sync.Mutex
+	attrs  map[string]interface{}
+	events []spanEvent
+}
+
+type spanEvent struct {
+	time  time.Time
+	name  string
+	attrs map[string]interface{}
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the span ctx was derived from by Tracer.Start, or
+// nil.
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttr sets an attribute; values are strings, ints, bools or floats.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// AddEvent adds a timestamped event to the span.
+func (s *Span) AddEvent(name string, attrs map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, spanEvent{time: time.Now(), name: name, attrs: attrs})
+}
+
+// Traceparent is the W3C trace context header value for the span, or "" for
+// a nil span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// End finishes the span, with an error status if err is not nil, and queues
+// it for export.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	status := map[string]interface{}{"code": statusOK}
+	if err != nil {
+		status = map[string]interface{}{"code": statusError, "message": err.Error()}
+	}
+	s.mu.Lock()
+	span := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(time.Now().UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+		"status":            status,
+	}
+	if s.parentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if len(s.events) > 0 {
+		events := make([]map[string]interface{}, len(s.events))
+		for i, ev := range s.events {
+			events[i] = map[string]interface{}{
+				"timeUnixNano": strconv.FormatInt(ev.time.UnixNano(), 10),
+				"name":         ev.name,
+				"attributes":   otlpAttributes(ev.attrs),
+			}
+		}
+		span["events"] = events
+	}
+	s.mu.Unlock()
+	s.tracer.queue(span)
+}
+
+// otlpAttributes converts attributes to OTLP/JSON key-value pairs.
+func otlpAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(attrs))
+	for _, key := range sortedKeys(attrs) {
+		var value map[string]interface{}
+		switch v := attrs[key].(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]interface{}{"key": key, "value": value})
+	}
+	return out
+}
+
+// Tracer batches finished spans and exports them as OTLP/JSON, either posted
+// to a collector's /v1/traces endpoint or appended to a file, one export
+// request per line. A nil *Tracer records nothing.
+type Tracer struct {
+	service  string
+	endpoint string
+	file     *os.File
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []map[string]interface{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// tracer is the tracer enabled with -trace-endpoint or -trace-file, if any.
+var tracer *Tracer
+
+const traceFlushInterval = 5 * time.Second
+
+func NewTracer(service, endpoint, path string) (*Tracer, error) {
+	t := &Tracer{
+		service:  service,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		t.file = f
+	}
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(traceFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.flush()
+			case <-t.done:
+				t.flush()
+				return
+			}
+		}
+	}()
+	return t, nil
+}
+
+// startTracing enables tracing for the flags of a command. It does nothing
+// if both endpoint and path are empty.
+func startTracing(endpoint, path string) {
+	if endpoint == "" && path == "" {
+		return
+	}
+	t, err := NewTracer("graphql-client", endpoint, path)
+	if err != nil {
+		log.Fatalf("Error starting tracing: %v", err)
+	}
+	tracer = t
+}
+
+// Start begins a span, the child of the span in ctx if there is one, and
+// returns a context carrying it.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: make(map[string]interface{})}
+	if parent := spanFromContext(ctx); parent != nil {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) queue(span map[string]interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, span)
+}
+
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": t.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": t.service},
+				"spans": spans,
+			}},
+		}},
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		slog.Error("Error encoding spans", "err", err)
+		return
+	}
+	if t.file != nil {
+		if _, err := t.file.Write(append(data, '\n')); err != nil {
+			slog.Error("Error writing spans", "err", err)
+		}
+	}
+	if t.endpoint != "" {
+		resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+		if err != nil {
+			slog.Warn("Error exporting spans", "err", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			slog.Warn("Error exporting spans", "status", resp.Status)
+		}
+	}
+}
+
+// Close exports the remaining spans.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	close(t.done)
+	<-t.stopped
+	if t.file != nil {
+		return t.file.Close()
+	}
+	return nil
+}
+
+// startOperationSpan starts the span of a GraphQL operation, named by the
+// OpenTelemetry GraphQL conventions.
+func startOperationSpan(ctx context.Context, opType OperationType, prefix string) (context.Context, *Span) {
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", opType, prefix), spanKindClient)
+	span.SetAttr("graphql.operation.name", prefix)
+	span.SetAttr("graphql.operation.type", string(opType))
+	return ctx, span
+}
+
+// endOperationSpan ends an operation's span, failed if the operation failed
+// or its result has GraphQL errors.
+func endOperationSpan(span *Span, result *IncrementalResult, err error) {
+	if err == nil && result != nil {
+		if errs := resultErrors(result.Payload(), nil); len(errs) > 0 {
+			err = errs
+		}
+	}
+	span.End(err)
+}
+
+// traceExtensions adds the traceparent of the span in ctx to an operation's
+// extensions.
+func traceExtensions(ctx context.Context, extensions map[string]interface{}) map[string]interface{} {
+	tp := spanFromContext(ctx).Traceparent()
+	if tp == "" {
+		return extensions
+	}
+	if extensions == nil {
+		extensions = make(map[string]interface{})
+	}
+	extensions["traceparent"] = tp
+	return extensions
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var traceparentFormat = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-01$`)
+
+// exportedSpan is a span as exported in OTLP/JSON.
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+}
+
+// readSpans returns the spans exported to path, by name.
+func readSpans(t *testing.T, path string) map[string]exportedSpan {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := make(map[string]exportedSpan)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range request.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func TestTraceparent(t *testing.T) {
+	if tp := (*Span)(nil).Traceparent(); tp != "" {
+		t.Errorf("nil span: got %q, want none", tp)
+	}
+	tr, err := NewTracer("test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	ctx, parent := tr.Start(context.Background(), "parent", spanKindInternal)
+	_, child := tr.Start(ctx, "child", spanKindClient)
+	_, other := tr.Start(context.Background(), "other", spanKindInternal)
+
+	p := traceparentFormat.FindStringSubmatch(parent.Traceparent())
+	c := traceparentFormat.FindStringSubmatch(child.Traceparent())
+	o := traceparentFormat.FindStringSubmatch(other.Traceparent())
+	if p == nil || c == nil || o == nil {
+		t.Fatalf("got %q, %q and %q, want version-traceid-spanid-flags", parent.Traceparent(), child.Traceparent(), other.Traceparent())
+	}
+	if c[1] != p[1] {
+		t.Errorf("child is in trace %s, want its parent's %s", c[1], p[1])
+	}
+	if c[2] == p[2] {
+		t.Errorf("child has its parent's span ID %s", c[2])
+	}
+	if o[1] == p[1] {
+		t.Errorf("a span without a parent joined trace %s", o[1])
+	}
+}
+
+// The traceparent headers sent on the websocket upgrade and on HTTP
+// operations name the exported spans that sent them.
+func TestTraceExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	tr, err := NewTracer("test", "", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer = tr
+	t.Cleanup(func() { tracer = nil })
+
+	var mu sync.Mutex
+	headers := make(map[string]string)
+	server := newTestMockServer(t, FaultPlan{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind := "http"
+		if r.Header.Get("Upgrade") != "" {
+			kind = "ws"
+		}
+		mu.Lock()
+		headers[kind] = r.Header.Get("traceparent")
+		mu.Unlock()
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := dialMock(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+	client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx, workflow := tr.Start(ctx, "workflow", spanKindInternal)
+	if _, err := NewHTTPClient(ts.URL, http.MethodPost).Execute(ctx, OperationQuery, "sessions", `query sessions { sessions { id } }`, ""); err != nil {
+		t.Fatal(err)
+	}
+	workflow.End(nil)
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := readSpans(t, path)
+	// The upgrade is sent by the dial span, a child of the connection's root
+	// span.
+	connect, dial := spans["websocket connect"], spans["dial"]
+	checkSentBy(t, "websocket upgrade", headers["ws"], dial)
+	if connect.SpanID == "" || connect.ParentSpanID != "" {
+		t.Errorf("websocket connect: got %+v, want a root span", connect)
+	}
+	if dial.TraceID != connect.TraceID || dial.ParentSpanID != connect.SpanID {
+		t.Errorf("dial: got %+v, want a child of %+v", dial, connect)
+	}
+
+	op := spans["query sessions"]
+	checkSentBy(t, "HTTP operation", headers["http"], op)
+	if op.TraceID != spans["workflow"].TraceID || op.ParentSpanID != spans["workflow"].SpanID {
+		t.Errorf("query sessions: got %+v, want a child of %+v", op, spans["workflow"])
+	}
+	if op.Kind != spanKindClient {
+		t.Errorf("query sessions: got kind %d, want client", op.Kind)
+	}
+}
+
+// checkSentBy checks that traceparent names span.
+func checkSentBy(t *testing.T, what, traceparent string, span exportedSpan) {
+	t.Helper()
+	match := traceparentFormat.FindStringSubmatch(traceparent)
+	if match == nil {
+		t.Errorf("%s: got traceparent %q, want version-traceid-spanid-flags", what, traceparent)
+		return
+	}
+	if match[1] != span.TraceID || match[2] != span.SpanID {
+		t.Errorf("%s: traceparent %s doesn't name span %+v", what, traceparent, span)
+	}
+}
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is a problem found in an operation, positioned at the
+// offending node of the document.
+type ValidationError struct {
+	Loc     Location
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Loc, e.Message)
+}
+
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateOperation parses query and checks it, and the JSON variables sent
+// with it, against the schema.
+func validateOperation(schema *Schema, query, variables string) error {
+	doc, err := parseDocument(query)
+	if err != nil {
+		return err
+	}
+	errs := validateDocument(schema, doc)
+	if len(errs) == 0 && len(doc.Operations) == 1 {
+		errs = validateVariables(schema, doc.Operations[0], variables)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type validator struct {
+	schema    *Schema
+	fragments map[string]*FragmentDef
+	errs      ValidationErrors
+
+	op      *OperationDef
+	vars    map[string]*VariableDef
+	used    map[string]bool
+	visited map[string]bool
+}
+
+func (v *validator) errorf(loc Location, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Loc: loc, Message: fmt.Sprintf(format, args...)})
+}
+
+func validateDocument(schema *Schema, doc *Document) ValidationErrors {
+	v := &validator{schema: schema, fragments: make(map[string]*FragmentDef)}
+
+	for _, frag := range doc.Fragments {
+		if _, ok := v.fragments[frag.Name]; ok {
+			v.errorf(frag.Loc, "there can be only one fragment named %q", frag.Name)
+			continue
+		}
+		v.fragments[frag.Name] = frag
+		if t := schema.Type(frag.TypeCondition); t == nil {
+			v.errorf(frag.Loc, "unknown type %q", frag.TypeCondition)
+		} else if !t.IsComposite() {
+			v.errorf(frag.Loc, "fragment %q cannot condition on non composite type %q", frag.Name, frag.TypeCondition)
+		}
+	}
+
+	names := make(map[string]bool)
+	spread := make(map[string]bool)
+	for _, op := range doc.Operations {
+		if op.Name == "" && len(doc.Operations) > 1 {
+			v.errorf(op.Loc, "this anonymous operation must be the only defined operation")
+		}
+		if op.Name != "" {
+			if names[op.Name] {
+				v.errorf(op.Loc, "there can be only one operation named %q", op.Name)
+			}
+			names[op.Name] = true
+		}
+		v.operation(op)
+		for name := range v.visited {
+			spread[name] = true
+		}
+	}
+
+	for _, frag := range doc.Fragments {
+		if !spread[frag.Name] {
+			v.errorf(frag.Loc, "fragment %q is never used", frag.Name)
+		}
+	}
+	return v.errs
+}
+
+func (v *validator) operation(op *OperationDef) {
+	v.op = op
+	v.vars = make(map[string]*VariableDef)
+	v.used = make(map[string]bool)
+	v.visited = make(map[string]bool)
+
+	for _, def := range op.VarDefs {
+		if _, ok := v.vars[def.Name]; ok {
+			v.errorf(def.Loc, "there can be only one variable named \"$%s\"", def.Name)
+			continue
+		}
+		v.vars[def.Name] = def
+		named := def.Type
+		for named.Elem != nil {
+			named = named.Elem
+		}
+		t := v.schema.Type(named.Name)
+		if t == nil {
+			v.errorf(named.Loc, "unknown type %q", named.Name)
+		} else if !t.IsInput() {
+			v.errorf(def.Type.Loc, "variable \"$%s\" cannot be non-input type %q", def.Name, def.Type)
+		} else if def.Default != nil {
+			v.value(def.Default, typeNodeRef(def.Type))
+		}
+	}
+
+	root := v.schema.RootType(op.Type)
+	if root == nil {
+		v.errorf(op.Loc, "schema does not support %s operations", op.Type)
+		return
+	}
+	v.directives(op.Directives)
+	v.selectionSet(op.SelectionSet, root)
+
+	for _, def := range op.VarDefs {
+		if !v.used[def.Name] {
+			v.errorf(def.Loc, "variable \"$%s\" is never used%s", def.Name, v.inOperation())
+		}
+	}
+}
+
+func (v *validator) inOperation() string {
+	if v.op.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" in operation %q", v.op.Name)
+}
+
+func (v *validator) selectionSet(sels []Selection, parent *FullType) {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *FieldNode:
+			v.field(sel, parent)
+		case *FragmentSpread:
+			v.directives(sel.Directives)
+			frag, ok := v.fragments[sel.Name]
+			if !ok {
+				v.errorf(sel.Loc, "unknown fragment %q", sel.Name)
+				continue
+			}
+			if v.visited[sel.Name] {
+				continue
+			}
+			v.visited[sel.Name] = true
+			v.directives(frag.Directives)
+			if t := v.schema.Type(frag.TypeCondition); t != nil && t.IsComposite() {
+				v.selectionSet(frag.SelectionSet, t)
+			}
+		case *InlineFragment:
+			v.directives(sel.Directives)
+			t := parent
+			if sel.TypeCondition != "" {
+				t = v.schema.Type(sel.TypeCondition)
+				if t == nil {
+					v.errorf(sel.Loc, "unknown type %q", sel.TypeCondition)
+					continue
+				}
+				if !t.IsComposite() {
+					v.errorf(sel.Loc, "fragment cannot condition on non composite type %q", sel.TypeCondition)
+					continue
+				}
+			}
+			v.selectionSet(sel.SelectionSet, t)
+		}
+	}
+}
+
+func (v *validator) field(f *FieldNode, parent *FullType) {
+	v.directives(f.Directives)
+	if f.Name == "__typename" {
+		if f.SelectionSet != nil {
+			v.errorf(f.Loc, "field \"__typename\" must not have a selection since type \"String!\" has no subfields")
+		}
+		return
+	}
+
+	def := parent.Field(f.Name)
+	if def == nil && strings.HasPrefix(f.Name, "__") {
+		if def = v.metaField(f.Name, parent); def == nil {
+			// Without the introspection types there is nothing to check the
+			// selection against, but fragments spread in it are still used.
+			v.markSpreads(f.SelectionSet)
+			return
+		}
+	}
+	if def == nil {
+		v.errorf(f.Loc, "cannot query field %q on type %q%s", f.Name, parent.Name, suggest(f.Name, fieldNames(parent)))
+		return
+	}
+	v.arguments(f.Args, def.Args, f.Loc, fmt.Sprintf("field %q", f.Name))
+
+	t := v.schema.Type(def.Type.NamedType())
+	if t == nil {
+		return
+	}
+	if t.IsComposite() {
+		if f.SelectionSet == nil {
+			v.errorf(f.Loc, "field %q of type %q must have a selection of subfields", f.Name, def.Type)
+			return
+		}
+		v.selectionSet(f.SelectionSet, t)
+	} else if f.SelectionSet != nil {
+		v.errorf(f.SelectionSet[0].Location(), "field %q must not have a selection since type %q has no subfields", f.Name, def.Type)
+	}
+}
+
+// metaField returns the definition of the __schema and __type fields, which
+// are available on the query root but not listed among its fields.
+func (v *validator) metaField(name string, parent *FullType) *FieldDef {
+	root := v.schema.RootType(OperationQuery)
+	if root == nil || parent.Name != root.Name {
+		return nil
+	}
+	switch name {
+	case "__schema":
+		if v.schema.Type("__Schema") != nil {
+			return &FieldDef{Name: name, Type: &TypeRef{Kind: "NON_NULL", OfType: &TypeRef{Kind: "OBJECT", Name: "__Schema"}}}
+		}
+	case "__type":
+		if v.schema.Type("__Type") != nil {
+			arg := &InputValue{Name: "name", Type: &TypeRef{Kind: "NON_NULL", OfType: &TypeRef{Kind: "SCALAR", Name: "String"}}}
+			return &FieldDef{Name: name, Args: []*InputValue{arg}, Type: &TypeRef{Kind: "OBJECT", Name: "__Type"}}
+		}
+	}
+	return nil
+}
+
+func (v *validator) markSpreads(sels []Selection) {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *FieldNode:
+			v.markSpreads(sel.SelectionSet)
+		case *InlineFragment:
+			v.markSpreads(sel.SelectionSet)
+		case *FragmentSpread:
+			if frag, ok := v.fragments[sel.Name]; ok && !v.visited[sel.Name] {
+				v.visited[sel.Name] = true
+				v.markSpreads(frag.SelectionSet)
+			}
+		}
+	}
+}
+
+func (v *validator) directives(dirs []*Directive) {
+	for _, d := range dirs {
+		var def *DirectiveDef
+		for _, candidate := range v.schema.Directives {
+			if candidate.Name == d.Name {
+				def = candidate
+				break
+			}
+		}
+		if def == nil {
+			v.errorf(d.Loc, "unknown directive \"@%s\"", d.Name)
+			continue
+		}
+		v.arguments(d.Args, def.Args, d.Loc, fmt.Sprintf("directive \"@%s\"", d.Name))
+	}
+}
+
+func (v *validator) arguments(args []*Argument, defs []*InputValue, loc Location, owner string) {
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		if seen[arg.Name] {
+			v.errorf(arg.Loc, "there can be only one argument named %q", arg.Name)
+			continue
+		}
+		seen[arg.Name] = true
+		var def *InputValue
+		for _, candidate := range defs {
+			if candidate.Name == arg.Name {
+				def = candidate
+				break
+			}
+		}
+		if def == nil {
+			names := make([]string, len(defs))
+			for i, d := range defs {
+				names[i] = d.Name
+			}
+			v.errorf(arg.Loc, "unknown argument %q on %s%s", arg.Name, owner, suggest(arg.Name, names))
+			continue
+		}
+		v.value(arg.Value, def.Type)
+	}
+	for _, def := range defs {
+		if def.Type.Kind == "NON_NULL" && def.DefaultValue == nil && !seen[def.Name] {
+			v.errorf(loc, "%s argument %q of type %q is required, but it was not provided", owner, def.Name, def.Type)
+		}
+	}
+}
+
+// value checks a literal or variable reference against the expected input
+// type.
+func (v *validator) value(val *Value, expected *TypeRef) {
+	if val.Kind == ValueVariable {
+		v.used[val.Raw] = true
+		def, ok := v.vars[val.Raw]
+		if !ok {
+			v.errorf(val.Loc, "variable \"$%s\" is not defined%s", val.Raw, v.inOperation())
+			return
+		}
+		if !variableFits(def, expected) {
+			v.errorf(val.Loc, "variable \"$%s\" of type %q used in position expecting type %q", val.Raw, def.Type, expected)
+		}
+		return
+	}
+
+	if expected.Kind == "NON_NULL" {
+		if val.Kind == ValueNull {
+			v.errorf(val.Loc, "expected value of type %q, found null", expected)
+			return
+		}
+		v.value(val, expected.OfType)
+		return
+	}
+	if val.Kind == ValueNull {
+		return
+	}
+	if expected.Kind == "LIST" {
+		if val.Kind == ValueList {
+			for _, item := range val.List {
+				v.value(item, expected.OfType)
+			}
+			return
+		}
+		v.value(val, expected.OfType)
+		return
+	}
+
+	t := v.schema.Type(expected.Name)
+	if t == nil {
+		return
+	}
+	switch t.Kind {
+	case "SCALAR":
+		if !literalFitsScalar(val, t.Name) {
+			v.errorf(val.Loc, "%s cannot represent value %s", t.Name, literalString(val))
+		}
+	case "ENUM":
+		if val.Kind != ValueEnum || !t.HasEnumValue(val.Raw) {
+			v.errorf(val.Loc, "enum %q cannot represent value %s", t.Name, literalString(val))
+		}
+	case "INPUT_OBJECT":
+		if val.Kind != ValueObject {
+			v.errorf(val.Loc, "expected value of type %q, found %s", t.Name, literalString(val))
+			return
+		}
+		seen := make(map[string]bool)
+		for _, field := range val.Fields {
+			seen[field.Name] = true
+			def := t.InputField(field.Name)
+			if def == nil {
+				v.errorf(field.Loc, "field %q is not defined by type %q%s", field.Name, t.Name, suggest(field.Name, inputFieldNames(t)))
+				continue
+			}
+			v.value(field.Value, def.Type)
+		}
+		for _, def := range t.InputFields {
+			if def.Type.Kind == "NON_NULL" && def.DefaultValue == nil && !seen[def.Name] {
+				v.errorf(val.Loc, "field \"%s.%s\" of required type %q was not provided", t.Name, def.Name, def.Type)
+			}
+		}
+	}
+}
+
+func variableFits(def *VariableDef, expected *TypeRef) bool {
+	varType := def.Type
+	if expected.Kind == "NON_NULL" && !varType.NonNull && def.Default != nil && def.Default.Kind != ValueNull {
+		expected = expected.OfType
+	}
+	return typeFits(varType, expected)
+}
+
+func typeFits(t *TypeNode, expected *TypeRef) bool {
+	if expected.Kind == "NON_NULL" {
+		if !t.NonNull {
+			return false
+		}
+		return typeFits(&TypeNode{Name: t.Name, Elem: t.Elem}, expected.OfType)
+	}
+	if t.NonNull {
+		return typeFits(&TypeNode{Name: t.Name, Elem: t.Elem}, expected)
+	}
+	if expected.Kind == "LIST" {
+		return t.Elem != nil && typeFits(t.Elem, expected.OfType)
+	}
+	return t.Elem == nil && t.Name == expected.Name
+}
+
+func typeNodeRef(t *TypeNode) *TypeRef {
+	var ref *TypeRef
+	if t.Elem != nil {
+		ref = &TypeRef{Kind: "LIST", OfType: typeNodeRef(t.Elem)}
+	} else {
+		ref = &TypeRef{Kind: "NAMED", Name: t.Name}
+	}
+	if t.NonNull {
+		ref = &TypeRef{Kind: "NON_NULL", OfType: ref}
+	}
+	return ref
+}
+
+func literalFitsScalar(val *Value, scalar string) bool {
+	switch scalar {
+	case "Int":
+		if val.Kind != ValueInt {
+			return false
+		}
+		_, err := strconv.ParseInt(val.Raw, 10, 32)
+		return err == nil
+	case "Float":
+		return val.Kind == ValueInt || val.Kind == ValueFloat
+	case "String":
+		return val.Kind == ValueString
+	case "Boolean":
+		return val.Kind == ValueBoolean
+	case "ID":
+		return val.Kind == ValueString || val.Kind == ValueInt
+	}
+	return true
+}
+
+func literalString(val *Value) string {
+	switch val.Kind {
+	case ValueString:
+		return strconv.Quote(val.Raw)
+	case ValueList:
+		return "list"
+	case ValueObject:
+		return "object"
+	}
+	return val.Raw
+}
+
+// validateVariables checks the JSON variables sent with op against its
+// variable definitions. Errors are positioned at the variable definition and
+// carry the JSON path of the offending value.
+func validateVariables(schema *Schema, op *OperationDef, variables string) ValidationErrors {
+	v := &validator{schema: schema, op: op}
+	vars := map[string]interface{}{}
+	if strings.TrimSpace(variables) != "" {
+		dec := json.NewDecoder(bytes.NewReader([]byte(variables)))
+		dec.UseNumber()
+		if err := dec.Decode(&vars); err != nil {
+			v.errorf(op.Loc, "variables are not a JSON object: %v", err)
+			return v.errs
+		}
+	}
+
+	defined := make(map[string]bool)
+	for _, def := range op.VarDefs {
+		defined[def.Name] = true
+		val, ok := vars[def.Name]
+		if !ok || val == nil {
+			if def.Type.NonNull && def.Default == nil {
+				v.errorf(def.Loc, "variable \"$%s\" of required type %q was not provided", def.Name, def.Type)
+			}
+			continue
+		}
+		v.jsonValue(def.Loc, "$"+def.Name, val, typeNodeRef(def.Type))
+	}
+
+	extra := make([]string, 0)
+	for name := range vars {
+		if !defined[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		v.errorf(op.Loc, "variable \"$%s\" is not defined%s", name, v.inOperation())
+	}
+	return v.errs
+}
+
+func (v *validator) jsonValue(loc Location, path string, val interface{}, expected *TypeRef) {
+	if expected.Kind == "NON_NULL" {
+		if val == nil {
+			v.errorf(loc, "%s: expected non-null value of type %q", path, expected)
+			return
+		}
+		v.jsonValue(loc, path, val, expected.OfType)
+		return
+	}
+	if val == nil {
+		return
+	}
+	if expected.Kind == "LIST" {
+		if items, ok := val.([]interface{}); ok {
+			for i, item := range items {
+				v.jsonValue(loc, fmt.Sprintf("%s[%d]", path, i), item, expected.OfType)
+			}
+			return
+		}
+		v.jsonValue(loc, path, val, expected.OfType)
+		return
+	}
+
+	t := v.schema.Type(expected.Name)
+	if t == nil {
+		return
+	}
+	switch t.Kind {
+	case "SCALAR":
+		if !jsonFitsScalar(val, t.Name) {
+			v.errorf(loc, "%s: %s cannot represent value %s", path, t.Name, jsonString(val))
+		}
+	case "ENUM":
+		s, ok := val.(string)
+		if !ok || !t.HasEnumValue(s) {
+			v.errorf(loc, "%s: enum %q cannot represent value %s", path, t.Name, jsonString(val))
+		}
+	case "INPUT_OBJECT":
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			v.errorf(loc, "%s: expected object of type %q, found %s", path, t.Name, jsonString(val))
+			return
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			def := t.InputField(k)
+			if def == nil {
+				v.errorf(loc, "%s.%s: field is not defined by type %q%s", path, k, t.Name, suggest(k, inputFieldNames(t)))
+				continue
+			}
+			v.jsonValue(loc, path+"."+k, obj[k], def.Type)
+		}
+		for _, def := range t.InputFields {
+			if _, ok := obj[def.Name]; !ok && def.Type.Kind == "NON_NULL" && def.DefaultValue == nil {
+				v.errorf(loc, "%s.%s: field of required type %q was not provided", path, def.Name, def.Type)
+			}
+		}
+	}
+}
+
+func jsonFitsScalar(val interface{}, scalar string) bool {
+	switch scalar {
+	case "Int":
+		n, ok := val.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseInt(n.String(), 10, 32)
+		return err == nil
+	case "Float":
+		_, ok := val.(json.Number)
+		return ok
+	case "String":
+		_, ok := val.(string)
+		return ok
+	case "Boolean":
+		_, ok := val.(bool)
+		return ok
+	case "ID":
+		switch n := val.(type) {
+		case string:
+			return true
+		case json.Number:
+			_, err := n.Int64()
+			return err == nil
+		}
+		return false
+	}
+	return true
+}
+
+func jsonString(val interface{}) string {
+	b, _ := json.Marshal(val)
+	return string(b)
+}
+
+func fieldNames(t *FullType) []string {
+	names := make([]string, len(t.Fields))
+	for i, f := range t.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func inputFieldNames(t *FullType) []string {
+	names := make([]string, len(t.InputFields))
+	for i, f := range t.InputFields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// suggest returns a "did you mean" hint for the closest candidate to name.
+func suggest(name string, candidates []string) string {
+	best, bestDist := "", len(name)/2+1
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf("; did you mean %q?", best)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// runValidate checks the built-in operations, and any .graphql files given
+// as arguments, against the cached schema without connecting.
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	schemaPath := fs.String("schema", defaultSchemaPath, "cached introspection result")
+	fs.Parse(args)
+
+	schema, err := loadSchema(*schemaPath)
+	if err != nil {
+		log.Fatalf("Error loading schema (run introspect first): %v", err)
+	}
+
+	failed := false
+	for _, op := range builtinOperations {
+		if err := validateOperation(schema, op.Query, op.Variables); err != nil {
+			fmt.Printf("%s:\n%s\n", op.Name, indent(err.Error()))
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok\n", op.Name)
+	}
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		doc, err := parseDocument(string(src))
+		if err == nil {
+			if errs := validateDocument(schema, doc); len(errs) > 0 {
+				err = errs
+			}
+		}
+		if err != nil {
+			fmt.Printf("%s:\n%s\n", path, indent(err.Error()))
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func mockSchema(t *testing.T) *Schema {
+	t.Helper()
+	schema, err := parseSDL(mockSchemaSDL)
+	if err != nil {
+		t.Fatalf("parseSDL: %v", err)
+	}
+	return schema
+}
+
+func TestValidateOperation(t *testing.T) {
+	schema := mockSchema(t)
+	tests := []struct {
+		name      string
+		query     string
+		variables string
+		// want are the expected errors, each as location and message.
+		want []string
+	}{
+		{
+			name:  "valid query",
+			query: `query { sessions { id name __typename } session(id: "1") { ...s } } fragment s on Session { createdAt }`,
+		},
+		{
+			name:      "valid mutation with variables",
+			query:     `mutation ($input: [CreateSessionInput!]!) { createSessions(input: $input) { sessions { id } } }`,
+			variables: `{"input": [{"name": "a"}, {}]}`,
+		},
+		{
+			name:  "unknown field with suggestion",
+			query: `{ sessions { idd } }`,
+			want:  []string{`1:14: cannot query field "idd" on type "Session"; did you mean "id"?`},
+		},
+		{
+			name:  "missing subselection",
+			query: `{ sessions }`,
+			want:  []string{`1:3: field "sessions" of type "[Session!]!" must have a selection of subfields`},
+		},
+		{
+			name:  "selection on scalar",
+			query: `{ sessions { id { x } } }`,
+			want:  []string{`1:19: field "id" must not have a selection since type "ID!" has no subfields`},
+		},
+		{
+			name:  "missing required argument",
+			query: `{ session { id } }`,
+			want:  []string{`1:3: field "session" argument "id" of type "ID!" is required, but it was not provided`},
+		},
+		{
+			name:  "wrong literal type",
+			query: `{ session(id: true) { id } }`,
+			want:  []string{`1:15: ID cannot represent value true`},
+		},
+		{
+			name:  "undefined and unused variables",
+			query: `query q($unused: ID) { session(id: $id) { id } }`,
+			want: []string{
+				`1:36: variable "$id" is not defined in operation "q"`,
+				`1:9: variable "$unused" is never used in operation "q"`,
+			},
+		},
+		{
+			name:  "unused fragment",
+			query: `{ sessions { id } } fragment f on Session { id }`,
+			want:  []string{`1:21: fragment "f" is never used`},
+		},
+		{
+			name:  "valid subscription",
+			query: `subscription { sessionUpdates(id: "1") { type sequence } }`,
+		},
+		{
+			name:      "variables of the wrong type",
+			query:     `mutation ($input: [CreateSessionInput!]!) { createSessions(input: $input) { sessions { id } } }`,
+			variables: `{"input": [{"nam": "a"}, null]}`,
+			want: []string{
+				`1:11: $input[0].nam: field is not defined by type "CreateSessionInput"; did you mean "name"?`,
+				`1:11: $input[1]: expected non-null value of type "CreateSessionInput!"`,
+			},
+		},
+		{
+			name:  "missing required variable",
+			query: `query ($id: ID!) { session(id: $id) { id } }`,
+			want:  []string{`1:8: variable "$id" of required type "ID!" was not provided`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOperation(schema, tt.query, tt.variables)
+			var got []string
+			if errs, ok := err.(ValidationErrors); ok {
+				for _, e := range errs {
+					got = append(got, e.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("got %v, want validation errors", err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
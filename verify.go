@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const sessionUpdatesSubscription = `subscription sessionUpdates { sessionUpdates { type session { id } } }`
+
+// sessionVerifier checks that every session the workflow creates or deletes
+// is announced on the sessionUpdates subscription within a deadline, and
+// records the time from sending each mutation to receiving its event as
+// the "createSession event" and "deleteSession event" operations.
+type sessionVerifier struct {
+	deadline time.Duration
+
+	mu sync.Mutex
+	// seen holds events that arrived before anyone waited for them, which
+	// happens when the event overtakes the mutation result.
+	seen    map[[2]string]time.Time
+	waiting map[[2]string]chan time.Time
+}
+
+// startSessionVerifier subscribes to session updates on subscriber and
+// writes each of them to sinks, if any. The subscription is re-established,
+// backing off while that fails, whenever it ends before ctx is cancelled;
+// updates sent in between are missed. It is given up when the server
+// rejects it or the subscriber is closed.
+func startSessionVerifier(ctx context.Context, subscriber Subscriber, deadline time.Duration, sinks *SinkRouter) (*sessionVerifier, error) {
+	sub, err := subscriber.Subscribe(ctx, "sessionUpdates", sessionUpdatesSubscription, "")
+	if err != nil {
+		return nil, err
+	}
+	v := &sessionVerifier{
+		deadline: deadline,
+		seen:     make(map[[2]string]time.Time),
+		waiting:  make(map[[2]string]chan time.Time),
+	}
+	go func() {
+		for {
+			for payload := range sub.Events {
+				at := time.Now()
+				v.handle(payload, at)
+				sinks.Write(SinkEvent{Time: at, Subscription: "sessionUpdates", ID: sub.ID(), Payload: payload})
+			}
+			err := sub.Err()
+			if ctx.Err() != nil || errors.Is(err, ErrClientClosed) {
+				return
+			}
+			var errs GraphQLErrors
+			if errors.As(err, &errs) {
+				slog.Error("Verification subscription ended", "err", err)
+				return
+			}
+			slog.Warn("Verification subscription ended, re-establishing it", "err", err)
+			for backoff := time.Second; ; backoff = min(2*backoff, 30*time.Second) {
+				if sub, err = subscriber.Subscribe(ctx, "sessionUpdates", sessionUpdatesSubscription, ""); err == nil {
+					break
+				}
+				slog.Warn("Error re-establishing verification subscription", "err", err, "retryIn", backoff)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+			}
+		}
+	}()
+	return v, nil
+}
+
+func (v *sessionVerifier) handle(payload json.RawMessage, at time.Time) {
+	var event struct {
+		Data struct {
+			SessionUpdates struct {
+				Type    string `json:"type"`
+				Session struct {
+					ID string `json:"id"`
+				} `json:"session"`
+			} `json:"sessionUpdates"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		slog.Warn("Error unmarshalling session update", "err", err)
+		return
+	}
+	update := event.Data.SessionUpdates
+	key := [2]string{update.Type, update.Session.ID}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if ch, ok := v.waiting[key]; ok {
+		delete(v.waiting, key)
+		ch <- at
+		return
+	}
+	v.seen[key] = at
+	// Forget updates for sessions nobody waits for, such as other clients'.
+	if len(v.seen) > 1000 {
+		for k, t := range v.seen {
+			if at.Sub(t) > v.deadline {
+				delete(v.seen, k)
+			}
+		}
+	}
+}
+
+// expect waits for the update of kind (CREATED or DELETED) for session id,
+// whose mutation named op was sent at sent.
+func (v *sessionVerifier) expect(ctx context.Context, op, kind, id string, sent time.Time) error {
+	if v == nil {
+		return nil
+	}
+	name := op + " event"
+	runStats.OperationStarted(name)
+	key := [2]string{kind, id}
+
+	v.mu.Lock()
+	at, ok := v.seen[key]
+	var ch chan time.Time
+	if ok {
+		delete(v.seen, key)
+	} else {
+		ch = make(chan time.Time, 1)
+		v.waiting[key] = ch
+	}
+	v.mu.Unlock()
+
+	if !ok {
+		ctx, cancel := context.WithDeadline(ctx, sent.Add(v.deadline))
+		defer cancel()
+		select {
+		case at = <-ch:
+		case <-ctx.Done():
+			v.mu.Lock()
+			delete(v.waiting, key)
+			v.mu.Unlock()
+			err := fmt.Errorf("no %s update for session %s within %v: %w", kind, id, v.deadline, ctx.Err())
+			runStats.OperationFinished(name, time.Since(sent), nil, err)
+			return err
+		}
+	}
+	latency := at.Sub(sent)
+	runStats.OperationFinished(name, latency, nil, nil)
+	slog.Info("Verified session update", "op", op, "type", kind, "session", id, "propagation", latency.Round(time.Microsecond))
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Updates for a session created right after the verifier subscribes must
+// not be missed.
+func TestSessionVerifierFirstEvent(t *testing.T) {
+	client := dialMock(t, startMockServer(t, FaultPlan{}))
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		v, err := startSessionVerifier(ctx, client, 2*time.Second, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = createAndDeleteSession(ctx, client, v)
+		cancel()
+		if err != nil {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+	}
+}
+
+// sessionExecutor answers the workflow's mutations for session s1.
+type sessionExecutor struct{}
+
+func (sessionExecutor) Transport() string { return "test" }
+
+func (sessionExecutor) Execute(ctx context.Context, opType OperationType, prefix, query, variables string) (json.RawMessage, error) {
+	if prefix == "createSession" {
+		return json.RawMessage(`{"data": {"createSessions": {"sessions": [{"id": "s1"}]}}}`), nil
+	}
+	return json.RawMessage(`{"data": {"deleteSessions": {"success": true}}}`), nil
+}
+
+func TestCreateAndDeleteSessionMissingUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []string
+		want    []string
+		notWant []string
+	}{
+		{name: "both", updates: []string{"CREATED", "DELETED"}},
+		{name: "no CREATED", updates: []string{"DELETED"}, want: []string{"no CREATED"}, notWant: []string{"DELETED"}},
+		{name: "no DELETED", updates: []string{"CREATED"}, want: []string{"no DELETED"}, notWant: []string{"CREATED"}},
+		{name: "neither", want: []string{"no CREATED", "no DELETED"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &sessionVerifier{
+				deadline: 100 * time.Millisecond,
+				seen:     make(map[[2]string]time.Time),
+				waiting:  make(map[[2]string]chan time.Time),
+			}
+			for _, kind := range tt.updates {
+				v.seen[[2]string{kind, "s1"}] = time.Now()
+			}
+			err := createAndDeleteSession(context.Background(), sessionExecutor{}, v)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil {
+				t.Fatalf("got no error, want %q", tt.want)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("got %v, want it to mention %q", err, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(err.Error(), s+" update") {
+					t.Errorf("got %v, want it not to mention %q", err, s)
+				}
+			}
+			if len(v.seen) != 0 {
+				t.Errorf("updates %v were never awaited", v.seen)
+			}
+		})
+	}
+}
+
+// endingSubscriber starts subscriptions that the test ends by closing their
+// event channels.
+type endingSubscriber struct {
+	mu     sync.Mutex
+	events []chan json.RawMessage
+}
+
+func (s *endingSubscriber) Subscribe(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	_, sub, events := newSubscription(ctx, prefix)
+	s.mu.Lock()
+	s.events = append(s.events, events)
+	s.mu.Unlock()
+	return sub, nil
+}
+
+func (s *endingSubscriber) started() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+func TestSessionVerifierResubscribes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscriber := &endingSubscriber{}
+	v, err := startSessionVerifier(ctx, subscriber, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(subscriber.events[0])
+
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriber.started() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("verification subscription was not re-established")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	subscriber.mu.Lock()
+	events := subscriber.events[1]
+	subscriber.mu.Unlock()
+	events <- json.RawMessage(`{"data": {"sessionUpdates": {"type": "CREATED", "session": {"id": "s1"}}}}`)
+	if err := v.expect(ctx, "createSession", "CREATED", "s1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionVerifierStopsWhenClosed(t *testing.T) {
+	client := dialMock(t, startMockServer(t, FaultPlan{}))
+	subscriber := &countingSubscriber{Subscriber: client}
+	if _, err := startSessionVerifier(context.Background(), subscriber, time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := subscriber.calls.Load(); n != 1 {
+		t.Errorf("got %d subscriptions, want no attempt to re-establish it on a closed client", n)
+	}
+}
+
+type countingSubscriber struct {
+	Subscriber
+	calls atomic.Int32
+}
+
+func (s *countingSubscriber) Subscribe(ctx context.Context, prefix, query, variables string) (*Subscription, error) {
+	s.calls.Add(1)
+	return s.Subscriber.Subscribe(ctx, prefix, query, variables)
+}